@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// archiveEntry is the size and content hash of a file stored inside an archive
+type archiveEntry struct {
+	size int64
+	sum  Hash
+}
+
+// isArchive reports if the contents of path can be enumerated by archiveEntries
+func isArchive(path string) bool {
+	name := strings.ToLower(path)
+	return filepath.Ext(name) == ".zip" || filepath.Ext(name) == ".tgz" || strings.HasSuffix(name, ".tar.gz")
+}
+
+// archiveEntries returns the size and hash of every regular file inside a zip or gzipped tar archive
+func archiveEntries(path string) ([]archiveEntry, error) {
+	if filepath.Ext(strings.ToLower(path)) == ".zip" {
+		return zipEntries(path)
+	}
+	return tgzEntries(path)
+}
+
+func zipEntries(path string) ([]archiveEntry, error) {
+	file, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []archiveEntry
+	for _, f := range r.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		entry, err := hashEntry(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func tgzEntries(path string) ([]archiveEntry, error) {
+	file, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var entries []archiveEntry
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		entry, err := hashEntry(tr)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func hashEntry(r io.Reader) (archiveEntry, error) {
+	hasher := newHash()
+	n, err := io.Copy(hasher, r)
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	return archiveEntry{size: n, sum: sumOf(hasher)}, nil
+}
+
+// looseFileIndex finds loose files by content, only hashing the files that share a size with an archive entry and
+// only hashing each of them once
+type looseFileIndex struct {
+	fileSizes map[int64][]string
+	hashes    map[string]Hash
+}
+
+// contains reports if any loose file, other than the archive itself, has the same content as the entry
+func (idx *looseFileIndex) contains(archive string, entry archiveEntry) (bool, error) {
+	for _, path := range idx.fileSizes[entry.size] {
+		if path == archive {
+			continue
+		}
+		sum, ok := idx.hashes[path]
+		if !ok {
+			var err error
+			sum, err = fileSum(path)
+			if err != nil {
+				return false, err
+			}
+			idx.hashes[path] = sum
+		}
+		if sum == entry.sum {
+			return true, nil
+		}
+	}
+	return false, nil
+}
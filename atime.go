@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+)
+
+// When set, files are read without leaving a changed access time behind
+var preserveAtime bool
+
+// readFile is a file opened by openFile, closing it puts back the access time if needed
+type readFile struct {
+	*os.File
+	restore func()
+}
+
+func (f *readFile) Close() error {
+	err := f.File.Close()
+	if f.restore != nil {
+		f.restore()
+	}
+	return err
+}
+
+// openFile opens a file for reading. With -preserve-atime it uses O_NOATIME where the platform supports it, and
+// otherwise records the access time and restores it with os.Chtimes when the file is closed.
+func openFile(path string) (*readFile, error) {
+	if !preserveAtime {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return &readFile{File: file}, nil
+	}
+
+	if file, ok := openNoAtime(path); ok {
+		return &readFile{File: file}, nil
+	}
+	return openRestoringAtime(path)
+}
+
+// openRestoringAtime opens path and puts back its access time when it is closed. The modification time is looked up
+// again at that point, since putting back the one from before would hide a change made while the file was read, and
+// the -cache would then trust a stale sum.
+func openRestoringAtime(path string) (*readFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &readFile{File: file}
+	if atime, ok := accessTime(info); ok {
+		f.restore = func() {
+			if now, err := os.Stat(path); err == nil {
+				os.Chtimes(path, atime, now.ModTime())
+			}
+		}
+	}
+	return f, nil
+}
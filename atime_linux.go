@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// openNoAtime opens path with O_NOATIME, which is only allowed for the owner of the file
+func openNoAtime(path string) (*os.File, bool) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOATIME, 0)
+	if err != nil {
+		return nil, false
+	}
+	return file, true
+}
+
+func accessTime(info os.FileInfo) (time.Time, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec)), true
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+func openNoAtime(path string) (*os.File, bool) {
+	return nil, false
+}
+
+// accessTime isn't implemented here, so -preserve-atime has no effect on this platform
+func accessTime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenRestoringAtime_KeepsNewModTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := ioutil.WriteFile(path, []byte("before"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	f, err := openRestoringAtime(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.restore == nil {
+		f.Close()
+		t.Skip("access times aren't available on this platform")
+	}
+
+	// the file is changed while it is being read
+	changed := old.Add(time.Hour)
+	if err := ioutil.WriteFile(path, []byte("after"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, changed, changed); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(changed) {
+		t.Errorf("modification time after closing = %s, want the one of the change %s", info.ModTime(), changed)
+	}
+	if atime, ok := accessTime(info); ok && !atime.Equal(old) {
+		t.Errorf("access time after closing = %s, want it put back to %s", atime, old)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func openNoAtime(path string) (*os.File, bool) {
+	return nil, false
+}
+
+func accessTime(info os.FileInfo) (time.Time, bool) {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, data.LastAccessTime.Nanoseconds()), true
+}
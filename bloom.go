@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	bloomBits   = 1 << 24 // 2MiB on disk, about a 1% false positive rate at a million files
+	bloomHashes = 7
+)
+
+// bloomFilter is a persistent set of files that earlier runs confirmed to be unique. A false positive only means that a
+// duplicate can go unnoticed until the file changes, it never causes a file to be moved.
+type bloomFilter struct {
+	k    uint32
+	bits []byte
+}
+
+func newBloomFilter() *bloomFilter {
+	return &bloomFilter{k: bloomHashes, bits: make([]byte, bloomBits/8)}
+}
+
+// loadBloomFilter reads the filter from filePath, returning an empty filter if the file doesn't exist yet
+func loadBloomFilter(filePath string) (*bloomFilter, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return newBloomFilter(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= 4 {
+		return nil, errors.New("bloom filter file is too short")
+	}
+	k := binary.BigEndian.Uint32(data[:4])
+	if k == 0 || k > 64 {
+		return nil, fmt.Errorf("bloom filter file has %d hash functions, it is damaged or from another program", k)
+	}
+	if len(data)-4 != bloomBits/8 {
+		return nil, fmt.Errorf("bloom filter file has %d bytes of bits, want %d", len(data)-4, bloomBits/8)
+	}
+	return &bloomFilter{k: k, bits: data[4:]}, nil
+}
+
+// save writes the filter to filePath via a temporary file so a crash never leaves a truncated filter behind
+func (b *bloomFilter) save(filePath string) error {
+	data := make([]byte, 4, 4+len(b.bits))
+	binary.BigEndian.PutUint32(data, b.k)
+	data = append(data, b.bits...)
+	tmp := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
+
+func (b *bloomFilter) add(key []byte) {
+	for _, bit := range b.positions(key) {
+		b.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func (b *bloomFilter) contains(key []byte) bool {
+	for _, bit := range b.positions(key) {
+		if b.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// positions uses double hashing to derive k bit positions from two halves of a 64 bit FNV hash
+func (b *bloomFilter) positions(key []byte) []uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	m := uint64(len(b.bits)) * 8
+	result := make([]uint64, b.k)
+	for i := range result {
+		result[i] = (h1 + uint64(i)*h2) % m
+	}
+	return result
+}
+
+// uniqueKey identifies a file by its path, size, modification time and end blocks, so that any change to the file
+// gives it a new key
+func uniqueKey(path string, block blockKey) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%s\x00%d\x00%d\x00%s", path, block.size, info.ModTime().UnixNano(), block.sum)), nil
+}
+
+// skipKnownUnique drops the end block buckets where every file was confirmed unique by an earlier run. It returns
+// the remaining candidates, their keys so they can be added to the filter after hashing and how many files were skipped.
+// A file that can't be looked at any more, like one deleted since the walk, is left out with its error while the rest
+// of its bucket is still compared.
+func skipKnownUnique(endBlocks map[blockKey][]string, filter *bloomFilter) ([]string, map[string][]byte, int, []error) {
+	var candidates []string
+	var errs []error
+	keys := make(map[string][]byte)
+	skipped := 0
+	for block, paths := range endBlocks {
+		if len(paths) < 2 {
+			continue
+		}
+		known := true
+		var bucket []string
+		for _, path := range paths {
+			key, err := uniqueKey(path, block)
+			if err != nil {
+				errs = append(errs, err)
+				known = false
+				continue
+			}
+			bucket = append(bucket, path)
+			keys[path] = key
+			if !filter.contains(key) {
+				known = false
+			}
+		}
+		if known {
+			skipped += len(paths)
+			for _, path := range paths {
+				delete(keys, path)
+			}
+			continue
+		}
+		if len(bucket) > 1 {
+			candidates = append(candidates, bucket...)
+		}
+	}
+	return candidates, keys, skipped, errs
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBloomFilter_RejectsDamagedFiles(t *testing.T) {
+	dir := t.TempDir()
+	header := func(k uint32, bits int) []byte {
+		data := make([]byte, 4+bits)
+		binary.BigEndian.PutUint32(data, k)
+		return data
+	}
+	tests := map[string][]byte{
+		"too short":     {0, 0, 0, 7},
+		"no hashes":     header(0, bloomBits/8),
+		"too many bits": header(bloomHashes, bloomBits/8+1),
+		"too few bits":  header(bloomHashes, 16),
+	}
+	for name, data := range tests {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadBloomFilter(path); err == nil {
+			t.Errorf("loadBloomFilter() of a file with %s = nil error, want it rejected", name)
+		}
+	}
+
+	path := filepath.Join(dir, "saved")
+	saved := newBloomFilter()
+	saved.add([]byte("key"))
+	if err := saved.save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadBloomFilter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.contains([]byte("key")) {
+		t.Errorf("loadBloomFilter() lost the key that was saved")
+	}
+}
+
+func TestSkipKnownUnique_FileGoneSinceWalk(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"a.jpg", "b.jpg", "gone.jpg"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("same"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	block := blockKey{size: 4, sum: "sum"}
+	filter := newBloomFilter()
+	for _, path := range paths[:2] {
+		key, err := uniqueKey(path, block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter.add(key)
+	}
+	if err := os.Remove(paths[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	candidates, keys, skipped, errs := skipKnownUnique(map[blockKey][]string{block: paths}, filter)
+	if len(errs) != 1 {
+		t.Fatalf("skipKnownUnique() = %v errors, want one for the file that is gone", errs)
+	}
+	// the bucket isn't known to be unique any more, the files that are left are still compared
+	if len(candidates) != 2 || len(keys) != 2 || skipped != 0 {
+		t.Errorf("skipKnownUnique() = %q, %d keys, %d skipped, want a.jpg and b.jpg compared", candidates, len(keys), skipped)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "sort"
+
+// sizeBucket is a file size and how many files have it
+type sizeBucket struct {
+	Size  int64
+	Files int
+}
+
+// capSizeBuckets leaves out the sizes that more than max files share, for -max-group. Comparing thousands of files of
+// the same size, like empty sidecar files, costs far more time and memory than it is likely to reclaim. The skipped
+// sizes are returned ordered by size, fileSizes itself isn't changed.
+func capSizeBuckets(fileSizes map[int64][]string, max int) (map[int64][]string, []sizeBucket) {
+	kept := make(map[int64][]string, len(fileSizes))
+	var skipped []sizeBucket
+	for size, paths := range fileSizes {
+		if len(paths) > max {
+			skipped = append(skipped, sizeBucket{Size: size, Files: len(paths)})
+			continue
+		}
+		kept[size] = paths
+	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].Size < skipped[j].Size })
+	return kept, skipped
+}
+
+// sortedSizes returns the sizes in fileSizes from small to large
+func sortedSizes(fileSizes map[int64][]string) []int64 {
+	sizes := make([]int64, 0, len(fileSizes))
+	for size := range fileSizes {
+		sizes = append(sizes, size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	return sizes
+}
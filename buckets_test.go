@@ -0,0 +1,25 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCapSizeBuckets(t *testing.T) {
+	fileSizes := map[int64][]string{
+		0:  {"a.xmp", "b.xmp", "c.xmp", "d.xmp"},
+		10: {"a.jpg", "b.jpg"},
+		20: {"c.jpg", "d.jpg", "e.jpg"},
+		30: {"f.jpg"},
+	}
+	kept, skipped := capSizeBuckets(fileSizes, 3)
+	if want := []sizeBucket{{Size: 0, Files: 4}}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("capSizeBuckets() skipped %v, want %v", skipped, want)
+	}
+	if len(kept) != 3 || kept[0] != nil || len(kept[20]) != 3 {
+		t.Errorf("capSizeBuckets() kept %v", kept)
+	}
+	if len(fileSizes) != 4 {
+		t.Errorf("capSizeBuckets() changed its argument: %v", fileSizes)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// errBudgetUsed is returned instead of a sum for a file that wasn't hashed because -max-bytes was used up
+var errBudgetUsed = errors.New("the -max-bytes budget is used up")
+
+// hashBudget is how many bytes the hash sweep may still read for -max-bytes, a nil budget has no limit. Once a file
+// doesn't fit, no later file is hashed either, even a smaller one, so the sweep stops rather than picking files that
+// happen to fit.
+type hashBudget struct {
+	mu   sync.Mutex
+	left int64
+	used bool // a file didn't fit
+}
+
+func newHashBudget(max int64) *hashBudget {
+	if max <= 0 {
+		return nil
+	}
+	return &hashBudget{left: max}
+}
+
+// take reserves size bytes of the budget, it returns false once the budget is used up
+func (b *hashBudget) take(size int64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used || size > b.left {
+		b.used = true
+		return false
+	}
+	b.left -= size
+	return true
+}
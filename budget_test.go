@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestHashBudget(t *testing.T) {
+	b := newHashBudget(100)
+	for i, tt := range []struct {
+		size int64
+		want bool
+	}{{60, true}, {40, true}, {1, false}, {0, false}} {
+		if got := b.take(tt.size); got != tt.want {
+			t.Errorf("take #%d of %d = %t, want %t", i+1, tt.size, got, tt.want)
+		}
+	}
+
+	b = newHashBudget(100)
+	if b.take(150) || b.take(10) {
+		t.Error("a file was hashed after a bigger one didn't fit the budget")
+	}
+
+	if unlimited := newHashBudget(0); !unlimited.take(1 << 40) {
+		t.Error("a zero budget isn't unlimited")
+	}
+}
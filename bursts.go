@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default -burst-window, how long after the frame before it a frame can still be taken to belong to the same burst
+const defaultBurstWindow = 2 * time.Second
+
+// burstFrame is an image with what findBursts compares it by
+type burstFrame struct {
+	path      string
+	hash      uint64
+	taken     time.Time
+	sharpness float64
+}
+
+// findBursts returns the burst sequences among frames. A burst is a run of images in the same directory where each
+// was taken at most window after the one before it and differs from it in at most threshold bits of their dHash. The
+// sharpest frame is the first of each burst and the others follow in the order they were taken. The bursts are
+// ordered by their first path.
+func findBursts(frames []burstFrame, threshold int, window time.Duration) [][]string {
+	dirs := make(map[string][]burstFrame)
+	for _, frame := range frames {
+		dir := filepath.Dir(frame.path)
+		dirs[dir] = append(dirs[dir], frame)
+	}
+
+	var bursts [][]string
+	addBurst := func(run []burstFrame) {
+		if len(run) < 2 {
+			return
+		}
+		sharpest := 0
+		for i := range run {
+			if run[i].sharpness > run[sharpest].sharpness {
+				sharpest = i
+			}
+		}
+		burst := []string{run[sharpest].path}
+		for i := range run {
+			if i != sharpest {
+				burst = append(burst, run[i].path)
+			}
+		}
+		bursts = append(bursts, burst)
+	}
+	for _, run := range dirs {
+		sort.Slice(run, func(i, j int) bool {
+			if !run[i].taken.Equal(run[j].taken) {
+				return run[i].taken.Before(run[j].taken)
+			}
+			return run[i].path < run[j].path
+		})
+		start := 0
+		for i := 1; i <= len(run); i++ {
+			if i < len(run) && run[i].taken.Sub(run[i-1].taken) <= window && bits.OnesCount64(run[i].hash^run[i-1].hash) <= threshold {
+				continue
+			}
+			addBurst(run[start:i])
+			start = i
+		}
+	}
+	sort.Slice(bursts, func(i, j int) bool { return bursts[i][0] < bursts[j][0] })
+	return bursts
+}
+
+// sharpness returns the variance of the Laplacian of the brightness of img. Edges in focus change the brightness
+// sharply from one pixel to the next, so a blurred frame of the same scene has a lower variance.
+func sharpness(img image.Image) float64 {
+	b := img.Bounds()
+	if b.Dx() < 3 || b.Dy() < 3 {
+		return 0
+	}
+	// only three rows of brightness are kept at a time, a whole photo would need several bytes for every pixel
+	row := func(y int) []float64 {
+		values := make([]float64, b.Dx())
+		for x := range values {
+			values[x] = luminance(img, b.Min.X+x, y)
+		}
+		return values
+	}
+	var sum, sumSquares float64
+	var n int
+	prev, cur := row(b.Min.Y), row(b.Min.Y+1)
+	for y := b.Min.Y + 1; y < b.Max.Y-1; y++ {
+		next := row(y + 1)
+		for x := 1; x < len(cur)-1; x++ {
+			laplacian := prev[x] + next[x] + cur[x-1] + cur[x+1] - 4*cur[x]
+			sum += laplacian
+			sumSquares += laplacian * laplacian
+			n++
+		}
+		prev, cur = cur, next
+	}
+	mean := sum / float64(n)
+	return sumSquares/float64(n) - mean*mean
+}
+
+// reportBursts is the -bursts pipeline. It decodes every image in fileSizes, prints the burst sequences with the
+// sharpest frame first and returns them with the errors of the images that couldn't be read.
+func reportBursts(fileSizes map[int64][]string, threshold int, window time.Duration, newPrinter func(int, string) *ProgressPrinter, stdout, progress, status io.Writer, display func(string) string) ([][]string, []error) {
+	var images []string
+	for _, paths := range fileSizes {
+		for _, path := range paths {
+			if canThumbnail(path) {
+				images = append(images, path)
+			}
+		}
+	}
+	sort.Strings(images)
+
+	fmt.Fprintf(progress, "Looking for bursts among %d images\n", len(images))
+	printer := newPrinter(len(images), "bursts")
+	var frames []burstFrame
+	var errs []error
+	for _, path := range images {
+		taken, err := captureDate(path)
+		if err != nil {
+			errs = append(errs, err)
+			printer.Err()
+			continue
+		}
+		img, err := decodeImage(path)
+		if err != nil {
+			errs = append(errs, err)
+			printer.Err()
+			continue
+		}
+		frames = append(frames, burstFrame{path: path, hash: dHash(img), taken: taken, sharpness: sharpness(img)})
+		printer.Print(false)
+	}
+	fmt.Fprintf(progress, "\n\n")
+	printErrorSummary(status, "The following images could not be read and were left out of the bursts", errs)
+
+	bursts := findBursts(frames, threshold, window)
+	for _, burst := range bursts {
+		fmt.Fprintf(stdout, "\n%s\n", display(burst[0]))
+		for _, path := range burst[1:] {
+			fmt.Fprintln(stdout, display(path))
+		}
+	}
+	fmt.Fprintf(status, "\nFound %s bursts among %s images\n", thousands(len(bursts)), thousands(len(frames)))
+	return bursts, errs
+}
+
+// confirmBurstMoves asks on in if the frames that aren't the sharpest of their burst can be moved, for -bursts -apply
+// without -yes. Only y or yes is a yes, so an empty answer or running out of input leaves every frame where it is.
+func confirmBurstMoves(in io.Reader, out io.Writer, frames int, rejectFolder string) bool {
+	fmt.Fprintf(out, "\nMove the %s frames that aren't the sharpest of their burst into %s folders? [y/N]: ", thousands(frames), rejectFolder)
+	line, _ := bufio.NewReader(in).ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+// moveBurstFrames moves every frame but the sharpest of each burst into the reject folder next to it, after asking
+// with confirm. Frames in one of the references directories or matching the protected names are left where they are.
+// It returns the errors of the frames that couldn't be moved.
+func moveBurstFrames(bursts [][]string, rejectFolder, template string, references, protected []string, confirm func(frames int) bool, status io.Writer, display func(string) string) []error {
+	var moving []string
+	for _, burst := range bursts {
+		for _, path := range burst[1:] {
+			if reason, ok := leftInPlace(path, references, protected); ok {
+				fmt.Fprintf(status, "Leaving %s where it is since it %s\n", display(path), reason)
+				continue
+			}
+			moving = append(moving, path)
+		}
+	}
+	if len(moving) == 0 {
+		return nil
+	}
+	if !confirm(len(moving)) {
+		fmt.Fprintln(status, "Nothing was moved")
+		return nil
+	}
+	var errs []error
+	taken := make(map[string]bool)
+	for _, path := range moving {
+		if err := moveIntoRejectFolder(path, rejectFolder, template, taken); err != nil {
+			fmt.Fprintf(status, "Could not move %s, %s\n", display(path), err)
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// blurred returns img with every pixel averaged with its neighbours, like a frame that is slightly out of focus
+func blurred(img image.Image) image.Image {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var r, g, bl, n uint32
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					if !(image.Point{X: x + dx, Y: y + dy}).In(b) {
+						continue
+					}
+					cr, cg, cb, _ := img.At(x+dx, y+dy).RGBA()
+					r, g, bl, n = r+cr, g+cg, bl+cb, n+1
+				}
+			}
+			i := out.PixOffset(x, y)
+			out.Pix[i], out.Pix[i+1], out.Pix[i+2], out.Pix[i+3] = uint8(r/n>>8), uint8(g/n>>8), uint8(bl/n>>8), 255
+		}
+	}
+	return out
+}
+
+// writeFrame saves img as a png that was taken at the given time according to its modification time
+func writeFrame(t testing.TB, path string, img image.Image, taken time.Time) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, taken, taken); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindBursts(t *testing.T) {
+	start := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	frames := []burstFrame{
+		{path: "/a/1.jpg", hash: 0, taken: start, sharpness: 1},
+		{path: "/a/2.jpg", hash: 0x3, taken: start.Add(time.Second), sharpness: 5},
+		// each frame only has to be close to the one before it
+		{path: "/a/3.jpg", hash: 0x7, taken: start.Add(2500 * time.Millisecond), sharpness: 2},
+		{path: "/a/later.jpg", hash: 0x7, taken: start.Add(time.Minute), sharpness: 9},
+		{path: "/b/1.jpg", hash: 0, taken: start, sharpness: 1},
+		{path: "/b/other.jpg", hash: ^uint64(0), taken: start.Add(time.Second), sharpness: 2},
+		// the same moment in another directory is another camera
+		{path: "/c/1.jpg", hash: 0, taken: start.Add(time.Second), sharpness: 1},
+	}
+	want := [][]string{{"/a/2.jpg", "/a/1.jpg", "/a/3.jpg"}}
+	if got := findBursts(frames, 4, 2*time.Second); !reflect.DeepEqual(got, want) {
+		t.Errorf("findBursts() = %q, want %q", got, want)
+	}
+}
+
+func TestSharpness_BlurredFrameIsLower(t *testing.T) {
+	sharp := testPattern(160, 120, false)
+	soft := blurred(sharp)
+	if s, b := sharpness(sharp), sharpness(soft); s <= b {
+		t.Errorf("sharpness() of the blurred frame is %f, want less than the %f of the sharp one", b, s)
+	}
+}
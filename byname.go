@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// copySuffix matches what file managers and cameras add to the name of a copy, a " (n)" or a "_n" of one or two
+// digits. Longer numbers after an underscore are left alone, they usually are the counter in names like IMG_1234.
+var copySuffix = regexp.MustCompile(`( \(\d+\)|_\d{1,2})$`)
+
+// normalizedName returns the lower case base name of path without its extension and without any copy suffixes, so
+// that IMG_1234.jpg, IMG_1234 (1).JPG and IMG_1234_2 (1).jpg all become img_1234
+func normalizedName(path string) string {
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	for {
+		stripped := copySuffix.ReplaceAllString(name, "")
+		if stripped == name || stripped == "" {
+			break
+		}
+		name = stripped
+	}
+	return strings.ToLower(name)
+}
+
+// sameNameSizes keeps the paths in fileSizes that share their size and their normalized name with another file. With
+// -dedupe-by-name only these are compared further, which leaves out the files of a common size that have no similarly
+// named copy.
+func sameNameSizes(fileSizes map[int64][]string) map[int64][]string {
+	result := make(map[int64][]string)
+	for size, paths := range fileSizes {
+		if len(paths) < 2 {
+			continue
+		}
+		byName := make(map[string][]string)
+		var names []string
+		for _, path := range paths {
+			name := normalizedName(path)
+			if byName[name] == nil {
+				names = append(names, name)
+			}
+			byName[name] = append(byName[name], path)
+		}
+		for _, name := range names {
+			if namePaths := byName[name]; len(namePaths) > 1 {
+				result[size] = append(result[size], namePaths...)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestNormalizedName(t *testing.T) {
+	tests := map[string]string{
+		"IMG_1234.jpg":          "img_1234",
+		"IMG_1234 (1).jpg":      "img_1234",
+		"IMG_1234 (12).JPG":     "img_1234",
+		"IMG_1234_1.jpg":        "img_1234",
+		"IMG_1234_2 (1).jpg":    "img_1234",
+		"dir/IMG_1234.jpeg":     "img_1234",
+		"IMG_12.jpg":            "img",
+		"DSC_01234.nef":         "dsc_01234",
+		"holiday (1) trip.jpg":  "holiday (1) trip",
+		"_1.jpg":                "_1",
+		"(1).jpg":               "(1)",
+		"photo.tar.gz":          "photo.tar",
+		"Beach (copy).jpg":      "beach (copy)",
+		"IMG_1234 (1) (2).heic": "img_1234",
+	}
+	for path, want := range tests {
+		if got := normalizedName(path); got != want {
+			t.Errorf("normalizedName(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestSameNameSizes(t *testing.T) {
+	got := sameNameSizes(map[int64][]string{
+		10: {"a/IMG_1234.jpg", "b/IMG_1234 (1).jpg", "a/IMG_5678.jpg"},
+		20: {"a/IMG_5678.jpg"},
+		30: {"a/IMG_0001.jpg", "b/IMG_0002.jpg"},
+	})
+	for _, paths := range got {
+		sort.Strings(paths)
+	}
+	want := map[int64][]string{10: {"a/IMG_1234.jpg", "b/IMG_1234 (1).jpg"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sameNameSizes() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// caseVariants returns the sets of identical files whose paths only differ in upper and lower case. They can exist
+// side by side on a case-sensitive filesystem but would collide when the tree is copied to a case-insensitive one.
+func caseVariants(duplicates [][]string) [][]string {
+	var result [][]string
+	for _, paths := range duplicates {
+		folded := make(map[string][]string)
+		for _, path := range paths {
+			key := strings.ToLower(path)
+			folded[key] = append(folded[key], path)
+		}
+		for _, variants := range folded {
+			if len(variants) > 1 {
+				sort.Strings(variants)
+				result = append(result, variants)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i][0] < result[j][0] })
+	return result
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/stojg/deduper/dedupe"
+)
+
+// How many bytes are hashed between each checkpoint of the hash state, a variable so that tests can use small files
+var checkpointInterval int64 = 1 << 30
+
+// fileSumCheckpointed works like fileSum but periodically saves the running hash state of large files into dir
+// so that an interrupted run can continue hashing from the last checkpoint instead of from the start of the file.
+// Files smaller than a checkpoint are hashed by fileSum, and each part of a large one is read with the same -bufsize
+// buffers and short read check.
+func fileSumCheckpointed(filePath, dir string) (Hash, error) {
+	hasher := newHash()
+
+	file, err := openFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	marshaler, ok := hasher.(encoding.BinaryMarshaler)
+	if !ok || info.Size() < checkpointInterval {
+		return fileSum(filePath)
+	}
+
+	checkpoint := checkpointPath(dir, filePath, info)
+	offset, err := restoreCheckpoint(checkpoint, hasher)
+	if err != nil || offset > info.Size() {
+		// a broken or unreadable checkpoint just means starting over
+		hasher.Reset()
+		offset = 0
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	buf := readBuffer()
+	defer readBuffers.Put(buf)
+	for {
+		n := info.Size() - offset
+		if n > checkpointInterval {
+			n = checkpointInterval
+		}
+		sum, err := dedupe.ReaderSumBuffer(io.LimitReader(file, n), n, hasher, *buf)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", filePath, err)
+		}
+		offset += n
+		if offset == info.Size() {
+			if err := os.Remove(checkpoint); err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+			return sum, nil
+		}
+		if err := saveCheckpoint(checkpoint, offset, marshaler); err != nil {
+			return "", err
+		}
+	}
+}
+
+// checkpointPath returns a checkpoint file name that changes if the file is modified or another -hash is used, so that a
+// stale state is never resumed
+func checkpointPath(dir, filePath string, info os.FileInfo) string {
+	key := fmt.Sprintf("%s\x00%d\x00%d\x00%s", filePath, info.Size(), info.ModTime().UnixNano(), hashAlgorithm)
+	return filepath.Join(dir, fmt.Sprintf("%x.checkpoint", sha1.Sum([]byte(key))))
+}
+
+func saveCheckpoint(checkpoint string, offset int64, marshaler encoding.BinaryMarshaler) error {
+	state, err := marshaler.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 8, 8+len(state))
+	binary.BigEndian.PutUint64(data, uint64(offset))
+	data = append(data, state...)
+
+	tmp := checkpoint + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, checkpoint)
+}
+
+func restoreCheckpoint(checkpoint string, hasher hash.Hash) (int64, error) {
+	data, err := ioutil.ReadFile(checkpoint)
+	if err != nil {
+		return 0, err
+	}
+	unmarshaler, ok := hasher.(encoding.BinaryUnmarshaler)
+	if !ok || len(data) < 8 {
+		return 0, errors.New("unusable checkpoint")
+	}
+	if err := unmarshaler.UnmarshalBinary(data[8:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(data[:8])), nil
+}
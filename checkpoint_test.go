@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSumCheckpointed_MatchesFileSum(t *testing.T) {
+	defer func(interval int64, algorithm string) { checkpointInterval, hashAlgorithm = interval, algorithm }(checkpointInterval, hashAlgorithm)
+	checkpointInterval = 1 << 20
+	dir := t.TempDir()
+	data := make([]byte, 3<<20+17)
+	rand.New(rand.NewSource(1)).Read(data)
+	large := filepath.Join(dir, "clip.mov")
+	small := filepath.Join(dir, "photo.jpg")
+	if err := ioutil.WriteFile(large, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(small, data[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkpoints := filepath.Join(dir, "checkpoints")
+	if err := os.Mkdir(checkpoints, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for algorithm := range hashAlgorithms {
+		hashAlgorithm = algorithm
+		for _, path := range []string{small, large} {
+			want, err := fileSum(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := fileSumCheckpointed(path, checkpoints)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("fileSumCheckpointed(%s) with %s = %s, want %s like fileSum", filepath.Base(path), algorithm, got, want)
+			}
+		}
+	}
+	if entries, _ := ioutil.ReadDir(checkpoints); len(entries) != 0 {
+		t.Errorf("%d checkpoints were left behind after hashing the whole file", len(entries))
+	}
+}
+
+func TestFileSumCheckpointed_Resumes(t *testing.T) {
+	defer func(interval int64) { checkpointInterval = interval }(checkpointInterval)
+	checkpointInterval = 1 << 20
+	dir := t.TempDir()
+	data := make([]byte, 3<<20+17)
+	rand.New(rand.NewSource(2)).Read(data)
+	path := filepath.Join(dir, "clip.mov")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := fileSum(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a run that was interrupted after hashing the first two parts of the file
+	hasher := newHash()
+	hasher.Write(data[:2<<20])
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveCheckpoint(checkpointPath(dir, path, info), 2<<20, hasher.(encoding.BinaryMarshaler)); err != nil {
+		t.Fatal(err)
+	}
+	// the part that was already hashed isn't read again, so changing it without touching the size and modification
+	// time still gives the sum of the original content
+	data[0]++
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fileSumCheckpointed(path, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("fileSumCheckpointed() from a checkpoint = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,2177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stojg/deduper/dedupe"
+)
+
+// TestMain lets the tests run the whole command by executing the test binary itself with runMainEnv set
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) != "" {
+		os.Args = append([]string{"deduper"}, os.Args[1:]...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+const runMainEnv = "DEDUPER_TEST_RUN_MAIN"
+
+// runDeduper runs the command with args and returns its combined output and exit code
+func runDeduper(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	return runDeduperWithInput(t, "", args...)
+}
+
+// runDeduperWithInput works like runDeduper with stdin reading from input
+func runDeduperWithInput(t *testing.T, input string, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	cmd.Stdin = strings.NewReader(input)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return out.String(), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), 0
+}
+
+// runDeduperSplit runs the command with args and returns what it wrote to stdout and stderr, failing the test if it
+// didn't exit cleanly or with exitDuplicatesFound
+func runDeduperSplit(t *testing.T, args ...string) (string, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == exitDuplicatesFound {
+		err = nil
+	}
+	if err != nil {
+		t.Fatalf("deduper failed with %s: %s", err, stderr.String())
+	}
+	return stdout.String(), stderr.String()
+}
+
+// duplicateTree creates a directory with photo.jpg and copy/photo.jpg holding the same bytes
+func duplicateTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "copy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"photo.jpg", filepath.Join("copy", "photo.jpg")} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("the same photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCLI_RejectFolder(t *testing.T) {
+	dir := duplicateTree(t)
+
+	if out, code := runDeduper(t, "-apply", "-reject=_dupes", dir); code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if !exists(filepath.Join(dir, "_dupes", "photo_1.jpg")) {
+		t.Fatalf("the duplicate wasn't moved into _dupes")
+	}
+	if exists(filepath.Join(dir, defaultRejectFolder)) {
+		t.Errorf("%s was created even though -reject was set", defaultRejectFolder)
+	}
+
+	// the moved file is identical to the original but must not be found again
+	out, code := runDeduper(t, "-reject=_dupes", dir)
+	if code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if !bytes.Contains([]byte(out), []byte("No duplicates found")) {
+		t.Errorf("second run found duplicates inside _dupes: %s", out)
+	}
+}
+
+func TestCLI_RejectFolderMustBeAName(t *testing.T) {
+	for _, name := range []string{"", "/tmp/dupes", "a/b"} {
+		if out, code := runDeduper(t, "-reject="+name, t.TempDir()); code == 0 {
+			t.Errorf("-reject=%s was accepted: %s", name, out)
+		}
+	}
+}
+
+func TestCLI_Extensions(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.cr2", "b.cr2", "a.webp", "b.webp", "a.jpg", "b.jpg"} {
+		content := []byte("same " + filepath.Ext(name))
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, code := runDeduper(t, "-ext=cr2,webp", dir)
+	if code != exitDuplicatesFound {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	for _, name := range []string{"a.cr2", "a.webp"} {
+		if !bytes.Contains([]byte(out), []byte(filepath.Join(dir, name))) {
+			t.Errorf("-ext=cr2,webp didn't find %s: %s", name, out)
+		}
+	}
+	if bytes.Contains([]byte(out), []byte(filepath.Join(dir, "a.jpg"))) {
+		t.Errorf("-ext=cr2,webp scanned the unlisted a.jpg: %s", out)
+	}
+}
+
+func TestCLI_ApplyModes(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		moved     bool
+		deprecate bool
+	}{
+		{name: "no flags is read-only", args: nil, moved: false},
+		{name: "apply", args: []string{"-apply"}, moved: true},
+		{name: "legacy dryrun=false", args: []string{"-dryrun=false"}, moved: true, deprecate: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := duplicateTree(t)
+			want := exitDuplicatesFound
+			if tt.moved {
+				want = 0
+			}
+			out, code := runDeduper(t, append(tt.args, dir)...)
+			if code != want {
+				t.Fatalf("deduper exited with %d: %s", code, out)
+			}
+			if moved := exists(filepath.Join(dir, defaultRejectFolder, "photo_1.jpg")); moved != tt.moved {
+				t.Errorf("duplicate moved = %t, want %t: %s", moved, tt.moved, out)
+			}
+			if exists(filepath.Join(dir, "copy", "photo.jpg")) == tt.moved {
+				t.Errorf("copy/photo.jpg still exists = %t, want %t", !tt.moved, !tt.moved)
+			}
+			if warned := bytes.Contains([]byte(out), []byte("-dryrun is deprecated")); warned != tt.deprecate {
+				t.Errorf("deprecation warning printed = %t, want %t", warned, tt.deprecate)
+			}
+		})
+	}
+}
+
+func TestCLI_UndoRoundTrip(t *testing.T) {
+	dir := duplicateTree(t)
+	journalPath := filepath.Join(t.TempDir(), "journal")
+
+	if out, code := runDeduper(t, "-apply", "-journal", journalPath, dir); code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if exists(filepath.Join(dir, "copy", "photo.jpg")) {
+		t.Fatalf("the duplicate wasn't moved")
+	}
+
+	if out, code := runDeduper(t, "-undo", journalPath); code != 0 {
+		t.Fatalf("deduper -undo exited with %d: %s", code, out)
+	}
+	if !exists(filepath.Join(dir, "copy", "photo.jpg")) || exists(filepath.Join(dir, defaultRejectFolder, "photo_1.jpg")) {
+		t.Errorf("-undo didn't move the duplicate back")
+	}
+
+	// a second undo has nothing left to move
+	out, code := runDeduper(t, "-undo", journalPath)
+	if code != 0 || !bytes.Contains([]byte(out), []byte("Skipping")) {
+		t.Errorf("second -undo exited with %d: %s", code, out)
+	}
+}
+
+func TestCLI_ApplyFinishesInterruptedJournal(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("the same photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rejected := filepath.Join(dir, defaultRejectFolder)
+	done := moveOp{Src: filepath.Join(dir, "b.jpg"), Dst: filepath.Join(rejected, "b_1.jpg")}
+	left := moveOp{Src: filepath.Join(dir, "c.jpg"), Dst: filepath.Join(rejected, "c_1.jpg")}
+
+	// the earlier run was interrupted after moving b.jpg, since then a new b.jpg was saved that must stay where it is
+	if err := os.MkdirAll(rejected, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(done.Src, done.Dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(done.Src, []byte("a new photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	journalPath := filepath.Join(t.TempDir(), "journal")
+	j, err := openJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := fileSum(filepath.Join(dir, "a.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.plan(0, filepath.Join(dir, "a.jpg"), 14, sum, []moveOp{done, left})
+	j.begin(0, []moveOp{done, left})
+	j.moved(0, done)
+	j.Close()
+
+	out, code := runDeduperWithInput(t, "y\n", "-apply", "-journal", journalPath, dir)
+	if code != 0 || !strings.Contains(out, "1 groups that an earlier run didn't finish") {
+		t.Fatalf("deduper didn't offer to finish the interrupted run, exit code %d: %s", code, out)
+	}
+	if exists(left.Src) || !exists(left.Dst) {
+		t.Errorf("the move the earlier run didn't get to wasn't finished: %s", out)
+	}
+	if !exists(done.Src) || strings.Contains(out, "Moved "+done.Dst) {
+		t.Errorf("the move the earlier run finished was carried out again: %s", out)
+	}
+
+	out, code = runDeduperWithInput(t, "", "-apply", "-journal", journalPath, dir)
+	if code != 0 || strings.Contains(out, "didn't finish") {
+		t.Errorf("deduper offered to finish a journal that has nothing left, exit code %d: %s", code, out)
+	}
+}
+
+func TestCLI_ReclaimableSummary(t *testing.T) {
+	for _, args := range [][]string{nil, {"-apply"}} {
+		dir := duplicateTree(t)
+		out, code := runDeduper(t, append(args, dir)...)
+		if code != 0 && code != exitDuplicatesFound {
+			t.Fatalf("deduper exited with %d: %s", code, out)
+		}
+		// "the same photo" is 14 bytes and has one duplicate
+		if !regexp.MustCompile(`Reclaimable: +14 B`).MatchString(out) {
+			t.Errorf("deduper %v didn't report 14 reclaimable bytes: %s", args, out)
+		}
+	}
+}
+
+func TestCLI_MultipleRoots(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	for _, dir := range []string{first, second} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "photo.jpg"), []byte("on two drives"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, code := runDeduper(t, first, second)
+	if code != exitDuplicatesFound {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if !bytes.Contains([]byte(out), []byte("Found 1 duplicate groups among 2 files")) {
+		t.Errorf("the copies in two roots weren't grouped: %s", out)
+	}
+
+	// a root inside another root must not make a file its own duplicate
+	out, code = runDeduper(t, first, filepath.Join(first, "."), first)
+	if code != 0 || !bytes.Contains([]byte(out), []byte("No duplicates found among 1 files")) {
+		t.Errorf("overlapping roots were walked twice: %s", out)
+	}
+}
+
+func TestCLI_SymlinkedRoots(t *testing.T) {
+	dir := t.TempDir()
+	photos := filepath.Join(dir, "photos")
+	if err := os.MkdirAll(filepath.Join(photos, "2019"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.jpg", filepath.Join("2019", "b.jpg")} {
+		if err := ioutil.WriteFile(filepath.Join(photos, name), []byte("only one copy of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(photos, link); err != nil {
+		t.Skipf("can't create symlinks: %s", err)
+	}
+
+	// every root here scans the same files, through the symlink or not, so none of them is a duplicate
+	for _, args := range [][]string{
+		{photos, link + string(filepath.Separator)},
+		{filepath.Join(link, "2019"), photos},
+		{"-follow-symlinks", link, photos},
+	} {
+		out, code := runDeduper(t, args...)
+		if code != 0 || !strings.Contains(out, "No duplicates found") {
+			t.Errorf("deduper %v made a file its own duplicate, exit code %d: %s", args, code, out)
+		}
+	}
+
+	list := filepath.Join(photos, "a.jpg") + "\n" + filepath.Join(link, "a.jpg") + "\n" + filepath.Join(link, "2019", "..", "a.jpg") + "\n"
+	out, code := runDeduperWithInput(t, list, "-stdin", filepath.Join(link, "2019"))
+	if code != 0 || !strings.Contains(out, "No duplicates found among 2 files") {
+		t.Errorf("a file listed on stdin under three paths was scanned more than once, exit code %d: %s", code, out)
+	}
+}
+
+func TestCLI_ExcludeDirectory(t *testing.T) {
+	dir := duplicateTree(t)
+	previews := filepath.Join(dir, "Lightroom", "Previews")
+	if err := os.MkdirAll(previews, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(previews, "photo.jpg"), []byte("the same photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code := runDeduper(t, "-exclude", "Previews", "-exclude", "*.png", dir)
+	if code != exitDuplicatesFound {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if bytes.Contains([]byte(out), []byte(previews)) {
+		t.Errorf("a file inside the excluded Previews directory was scanned: %s", out)
+	}
+	if !bytes.Contains([]byte(out), []byte("among 2 files")) {
+		t.Errorf("the files outside the excluded directory weren't scanned: %s", out)
+	}
+}
+
+func TestCLI_LinkDuplicates(t *testing.T) {
+	dir := duplicateTree(t)
+
+	if out, code := runDeduper(t, "-apply", "-link", dir); code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if exists(filepath.Join(dir, defaultRejectFolder)) {
+		t.Errorf("-link created %s", defaultRejectFolder)
+	}
+	original, err := os.Stat(filepath.Join(dir, "photo.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	duplicate, err := os.Stat(filepath.Join(dir, "copy", "photo.jpg"))
+	if err != nil {
+		t.Fatalf("the duplicate path is gone after -link: %s", err)
+	}
+	if !os.SameFile(original, duplicate) {
+		t.Errorf("copy/photo.jpg isn't a hardlink to photo.jpg")
+	}
+
+	// a second run sees the hardlinks as one file and has nothing left to link
+	out, code := runDeduper(t, "-apply", "-link", dir)
+	if code != 0 || !bytes.Contains([]byte(out), []byte("No duplicates found")) {
+		t.Errorf("second -link run exited with %d: %s", code, out)
+	}
+}
+
+func TestCLI_FormatJSON(t *testing.T) {
+	dir := duplicateTree(t)
+
+	var groups []Group
+	out, _ := runDeduperSplit(t, "-format", "json", dir)
+	if err := json.Unmarshal([]byte(out), &groups); err != nil {
+		t.Fatalf("-format json didn't write a JSON array to stdout: %s\n%s", err, out)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("got %d groups, want 1: %s", len(groups), out)
+	}
+	group := groups[0]
+	if group.Original != filepath.Join(dir, "photo.jpg") || group.Size != 14 || len(group.Hash) != 40 {
+		t.Errorf("unexpected group %+v", group)
+	}
+	if len(group.Duplicates) != 1 || group.Duplicates[0] != filepath.Join(dir, "copy", "photo.jpg") {
+		t.Errorf("duplicates = %v, want only copy/photo.jpg", group.Duplicates)
+	}
+	if !exists(filepath.Join(dir, "copy", "photo.jpg")) {
+		t.Errorf("-format json without -apply moved the duplicate")
+	}
+
+	// no duplicates is an empty array rather than null
+	out, _ = runDeduperSplit(t, "-format", "json", t.TempDir())
+	if err := json.Unmarshal([]byte(out), &groups); err != nil || groups == nil || len(groups) != 0 {
+		t.Errorf("-format json with no duplicates wrote %s", out)
+	}
+}
+
+func TestCLI_MinSize(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]int{"small.jpg": 1023, "small_copy.jpg": 1023, "large.jpg": 1024, "large_copy.jpg": 1024, "empty.jpg": 0, "empty_copy.jpg": 0}
+	for name, size := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte("x"), size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, code := runDeduper(t, "-minsize=1k", dir)
+	if code != exitDuplicatesFound {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if !bytes.Contains([]byte(out), []byte("Found 1 duplicate groups among 2 files")) || !bytes.Contains([]byte(out), []byte("large")) {
+		t.Errorf("-minsize=1k didn't only consider the 1024 byte files: %s", out)
+	}
+
+	// any -minsize leaves out the empty files, which would otherwise all be duplicates
+	out, code = runDeduper(t, "-minsize=0", dir)
+	if code != exitDuplicatesFound || bytes.Contains([]byte(out), []byte("empty")) {
+		t.Errorf("-minsize=0 didn't skip the empty files, exit %d: %s", code, out)
+	}
+}
+
+func TestCLI_RejectNamesDontCollideAcrossGroups(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "copy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		for _, path := range []string{name, filepath.Join("copy", name)} {
+			if err := ioutil.WriteFile(filepath.Join(dir, path), []byte("content of "+name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// both originals share a reject folder and the template gives both duplicates the name 1.jpg
+	if out, code := runDeduper(t, "-apply", "-reject-template={n}{ext}", dir); code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	contents := make(map[string]bool)
+	for _, name := range []string{"1.jpg", "2.jpg"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, defaultRejectFolder, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[string(content)] = true
+	}
+	if len(contents) != 2 {
+		t.Errorf("a moved duplicate was overwritten, the reject folder holds %v", contents)
+	}
+}
+
+func TestCLI_ProgressOnStderr(t *testing.T) {
+	dir := duplicateTree(t)
+
+	stdout, stderr := runDeduperSplit(t, dir)
+	want := filepath.Join(dir, "photo.jpg") + "\n" + filepath.Join(dir, "copy", "photo.jpg") + "\n"
+	if strings.TrimSpace(stdout) != strings.TrimSpace(want) {
+		t.Errorf("stdout should only hold the duplicate group, got:\n%s", stdout)
+	}
+	for _, progress := range []string{"Scanning directory", "Comparing", "0% .d", "Found 1 duplicate groups"} {
+		if !strings.Contains(stderr, progress) {
+			t.Errorf("stderr is missing the progress output %q:\n%s", progress, stderr)
+		}
+	}
+}
+
+func TestCLI_Quiet(t *testing.T) {
+	dir := duplicateTree(t)
+
+	stdout, stderr := runDeduperSplit(t, "-quiet", dir)
+	if !strings.Contains(stdout, filepath.Join(dir, "copy", "photo.jpg")) {
+		t.Errorf("-quiet dropped the duplicate listing: %s", stdout)
+	}
+	for _, progress := range []string{".d", "%", "Scanning directory", "Comparing"} {
+		if strings.Contains(stderr, progress) {
+			t.Errorf("-quiet still printed the progress output %q:\n%s", progress, stderr)
+		}
+	}
+	if !strings.Contains(stderr, "Found 1 duplicate groups") {
+		t.Errorf("-quiet dropped the summary:\n%s", stderr)
+	}
+}
+
+func TestCLI_HardlinksAreOneFile(t *testing.T) {
+	dir := t.TempDir()
+	original := filepath.Join(dir, "photo.jpg")
+	if err := ioutil.WriteFile(original, []byte("one inode"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(original, filepath.Join(dir, "link.jpg")); err != nil {
+		t.Skipf("hardlinks aren't supported here: %s", err)
+	}
+
+	out, code := runDeduper(t, "-apply", dir)
+	if code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if !bytes.Contains([]byte(out), []byte("No duplicates found among 1 files")) || !bytes.Contains([]byte(out), []byte("Skipped 1 paths that are hardlinks")) {
+		t.Errorf("the hardlinks were treated as duplicates: %s", out)
+	}
+	if !exists(filepath.Join(dir, "link.jpg")) || exists(filepath.Join(dir, defaultRejectFolder)) {
+		t.Errorf("a hardlink was moved")
+	}
+}
+
+func TestCLI_FileListFromStdin(t *testing.T) {
+	dir := duplicateTree(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.jpg"), []byte("not the same photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const found = "Found 1 duplicate groups among 3 files"
+	if out, code := runDeduper(t, dir); code != exitDuplicatesFound || !strings.Contains(out, found) {
+		t.Fatalf("walking the directory exited with %d: %s", code, out)
+	}
+
+	// the same path twice must not become its own duplicate
+	list := strings.Join([]string{
+		filepath.Join(dir, "photo.jpg"),
+		filepath.Join(dir, "copy", "photo.jpg"),
+		filepath.Join(dir, "other.jpg"),
+		filepath.Join(dir, "photo.jpg"),
+		filepath.Join(dir, "missing.jpg"),
+		"",
+	}, "\n")
+	for _, args := range [][]string{{"-stdin"}, {"-"}} {
+		// the missing file makes the run complete with skipped files
+		out, code := runDeduperWithInput(t, list, args...)
+		if code != exitSkippedFiles {
+			t.Fatalf("deduper %v exited with %d: %s", args, code, out)
+		}
+		// the same group as the walk, and the missing file is reported
+		for _, want := range []string{filepath.Join(dir, "copy", "photo.jpg"), found, "missing.jpg"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("deduper %v with a file list is missing %q: %s", args, want, out)
+			}
+		}
+	}
+}
+
+func TestCLI_UnreadableFilesAreSkipped(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read files without permissions")
+	}
+	dir := duplicateTree(t)
+	locked := filepath.Join(dir, "locked")
+	if err := os.Mkdir(locked, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// an unreadable directory fails the walk and an unreadable file with the same size fails the hashing
+	unreadable := filepath.Join(dir, "unreadable.jpg")
+	if err := ioutil.WriteFile(unreadable, []byte("the same photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{locked, unreadable} {
+		if err := os.Chmod(path, 0); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(path, 0755)
+	}
+
+	out, code := runDeduper(t, dir)
+	if code != exitSkippedFiles {
+		t.Fatalf("deduper exited with %d, want %d: %s", code, exitSkippedFiles, out)
+	}
+	for _, want := range []string{"1 permission denied error:\n - 'open " + locked, "left out of the comparison:\n\n1 permission denied error:\n - 'open " + unreadable, filepath.Join(dir, "copy", "photo.jpg")} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q: %s", want, out)
+		}
+	}
+}
+
+func TestCLI_UnreadableCandidateKeepsTheRest(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read files without permissions")
+	}
+	dir := duplicateTree(t)
+	for _, name := range []string{"locked.jpg", "other.jpg", "other_copy.jpg"} {
+		content := "the same photo"
+		if name != "locked.jpg" {
+			content = "another photo"
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	locked := filepath.Join(dir, "locked.jpg")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0644)
+
+	out, code := runDeduper(t, "-verify", dir)
+	if code != exitSkippedFiles {
+		t.Fatalf("deduper exited with %d, want %d: %s", code, exitSkippedFiles, out)
+	}
+	if !strings.Contains(out, "Found 2 duplicate groups") || !strings.Contains(out, filepath.Join(dir, "copy", "photo.jpg")) || !strings.Contains(out, filepath.Join(dir, "other_copy.jpg")) {
+		t.Errorf("an unreadable candidate stopped the other duplicates from being found: %s", out)
+	}
+	if !strings.Contains(out, locked+": permission denied") {
+		t.Errorf("the unreadable candidate wasn't reported: %s", out)
+	}
+}
+
+func TestCLI_PreserveStructure(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join(dir, "photo.jpg"), filepath.Join(nested, "photo.jpg")} {
+		if err := ioutil.WriteFile(path, []byte("the same photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out, code := runDeduper(t, "-apply", "-preserve-structure", dir); code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if !exists(filepath.Join(dir, defaultRejectFolder, "a", "b", "photo.jpg")) {
+		t.Errorf("a/b/photo.jpg wasn't moved to %s/a/b/photo.jpg", defaultRejectFolder)
+	}
+	if exists(filepath.Join(nested, "photo.jpg")) {
+		t.Errorf("the duplicate is still in a/b")
+	}
+}
+
+func TestCLI_VerifyRejected(t *testing.T) {
+	dir := duplicateTree(t)
+	if out, code := runDeduper(t, "-apply", dir); code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+
+	if stdout, stderr := runDeduperSplit(t, "-verify-rejected", dir); stdout != "" || !strings.Contains(stderr, "All 1 rejected files") {
+		t.Errorf("the moved duplicate was reported while its original exists: %s%s", stdout, stderr)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "photo.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(dir, defaultRejectFolder, "photo_1.jpg")
+	if stdout, _ := runDeduperSplit(t, "-verify-rejected", dir); stdout != orphan+"\n" {
+		t.Errorf("-verify-rejected printed %q, want the orphaned %s", stdout, orphan)
+	}
+}
+
+func TestCLI_ExitCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		dir  func(t *testing.T) string
+		want int
+	}{
+		{name: "clean", dir: func(t *testing.T) string { return t.TempDir() }, want: 0},
+		{name: "duplicates found", dir: duplicateTree, want: exitDuplicatesFound},
+		{name: "duplicates moved", args: []string{"-apply"}, dir: duplicateTree, want: 0},
+		{name: "invalid flag", args: []string{"-no-such-flag"}, dir: duplicateTree, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if out, code := runDeduper(t, append(tt.args, tt.dir(t))...); code != tt.want {
+				t.Errorf("deduper exited with %d, want %d: %s", code, tt.want, out)
+			}
+		})
+	}
+}
+
+func TestCLI_SameDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		filepath.Join("event1", "a.jpg"): "the same photo",
+		filepath.Join("event1", "b.jpg"): "the same photo",
+		filepath.Join("event2", "c.jpg"): "the same photo",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b, c := filepath.Join(dir, "event1", "a.jpg"), filepath.Join(dir, "event1", "b.jpg"), filepath.Join(dir, "event2", "c.jpg")
+
+	stdout, _ := runDeduperSplit(t, "-format", "edges", dir)
+	if want := a + "\t" + b + "\n" + a + "\t" + c + "\n"; stdout != want {
+		t.Errorf("without -same-dir the output was %q, want %q", stdout, want)
+	}
+	stdout, _ = runDeduperSplit(t, "-same-dir", "-format", "edges", dir)
+	if want := a + "\t" + b + "\n"; stdout != want {
+		t.Errorf("with -same-dir the output was %q, want only the pair in event1 %q", stdout, want)
+	}
+}
+
+func TestCLI_MaxDepth(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{
+		filepath.Join(dir, "photo.jpg"),
+		filepath.Join(dir, "l1", "photo.jpg"),
+		filepath.Join(dir, "l1", "l2", "photo.jpg"),
+		filepath.Join(dir, "l1", "l2", "l3", "photo.jpg"),
+	}
+	for _, path := range paths {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("the same photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		depth string
+		want  []string
+	}{
+		{"-1", paths[1:]},
+		{"2", paths[1:3]},
+		{"1", paths[1:2]},
+		{"0", nil},
+	}
+	for _, tt := range tests {
+		stdout, _ := runDeduperSplit(t, "-maxdepth", tt.depth, "-format", "edges", dir)
+		var want string
+		expected := append([]string(nil), tt.want...)
+		sort.Strings(expected)
+		for _, path := range expected {
+			want += paths[0] + "\t" + path + "\n"
+		}
+		if stdout != want {
+			t.Errorf("-maxdepth %s printed %q, want %q", tt.depth, stdout, want)
+		}
+	}
+}
+
+func TestCLI_FollowSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	external := filepath.Join(dir, "external")
+	library := filepath.Join(dir, "library")
+	for _, d := range []string{external, library} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if err := ioutil.WriteFile(filepath.Join(external, name), []byte("the same photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(external, filepath.Join(library, "external")); err != nil {
+		t.Skipf("can't create symlinks: %s", err)
+	}
+
+	if out, code := runDeduper(t, library); code != 0 || !strings.Contains(out, "No duplicates found among 0 files") {
+		t.Errorf("the symlinked directory was walked without -follow-symlinks, exit code %d: %s", code, out)
+	}
+	stdout, _ := runDeduperSplit(t, "-follow-symlinks", "-format", "edges", library)
+	linked := filepath.Join(library, "external")
+	if want := filepath.Join(linked, "a.jpg") + "\t" + filepath.Join(linked, "b.jpg") + "\n"; stdout != want {
+		t.Errorf("-follow-symlinks printed %q, want %q", stdout, want)
+	}
+}
+
+func TestCLI_FollowSymlinksCycle(t *testing.T) {
+	dir := duplicateTree(t)
+	if err := os.Symlink(dir, filepath.Join(dir, "copy", "loop")); err != nil {
+		t.Skipf("can't create symlinks: %s", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "photo.jpg"), filepath.Join(dir, "link.jpg")); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr := runDeduperSplit(t, "-follow-symlinks", "-format", "edges", dir)
+	if !strings.Contains(stderr, "Skipped 1 directories that were already scanned") {
+		t.Errorf("the link back to the root wasn't skipped: %s", stderr)
+	}
+	if lines := strings.Count(stdout, "\n"); lines != 1 {
+		t.Errorf("-follow-symlinks printed %d duplicates, want only the real copy since the symlinked file is the same file: %q", lines, stdout)
+	}
+}
+
+func TestCLI_SortWaste(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.jpg":      "small",
+		"a_copy.jpg": "small",
+		"z.jpg":      strings.Repeat("big", 100),
+		"z_copy.jpg": strings.Repeat("big", 100),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	small, big := filepath.Join(dir, "a.jpg"), filepath.Join(dir, "z.jpg")
+
+	stdout, _ := runDeduperSplit(t, dir)
+	if strings.Index(stdout, small) > strings.Index(stdout, big) {
+		t.Errorf("-sort path didn't list the groups by path: %s", stdout)
+	}
+	stdout, _ = runDeduperSplit(t, "-sort", "waste", dir)
+	bigGroup := strings.Index(stdout, big+" (300 B reclaimable)")
+	smallGroup := strings.Index(stdout, small+" (5 B reclaimable)")
+	if bigGroup < 0 || smallGroup < 0 || bigGroup > smallGroup {
+		t.Errorf("-sort waste didn't list the bigger group first with its size: %s", stdout)
+	}
+}
+
+func TestCLI_OutputIsStable(t *testing.T) {
+	dir := t.TempDir()
+	for group := 0; group < 20; group++ {
+		for _, sub := range []string{"b", "a", "C", "c"} {
+			path := filepath.Join(dir, sub, fmt.Sprintf("photo_%d.jpg", group))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(fmt.Sprintf("photo %d", group)), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	first, _ := runDeduperSplit(t, "-workers", "8", "-all-groups", dir)
+	for i := 0; i < 3; i++ {
+		if again, _ := runDeduperSplit(t, "-workers", "8", "-all-groups", dir); again != first {
+			t.Fatalf("the output changed between runs:\n%s\nand\n%s", first, again)
+		}
+	}
+	if !strings.HasPrefix(first, "\n"+filepath.Join(dir, "C", "photo_0.jpg")+"\n"+filepath.Join(dir, "a", "photo_0.jpg")+"\n") {
+		t.Errorf("the paths in a group aren't sorted: %s", first)
+	}
+}
+
+func TestCLI_AllExt(t *testing.T) {
+	dir := t.TempDir()
+	photo, renamed := filepath.Join(dir, "photo.jpg"), filepath.Join(dir, "photo.bak")
+	for _, path := range []string{photo, renamed} {
+		if err := ioutil.WriteFile(path, []byte("the same photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out, code := runDeduper(t, dir); code != 0 || !strings.Contains(out, "No duplicates found among 1 files") {
+		t.Errorf("the unlisted .bak file was scanned without -all-ext, exit code %d: %s", code, out)
+	}
+	stdout, _ := runDeduperSplit(t, "-all-ext", "-format", "edges", dir)
+	// equal lengths keep the lexically first path as the original
+	if want := renamed + "\t" + photo + "\n"; stdout != want {
+		t.Errorf("-all-ext printed %q, want %q", stdout, want)
+	}
+	if out, code := runDeduper(t, "-all-ext", "-ext", "png", dir); code != 1 {
+		t.Errorf("-all-ext with -ext exited with %d, want 1: %s", code, out)
+	}
+}
+
+func TestCLI_Interactive(t *testing.T) {
+	dir := duplicateTree(t)
+	photo, copied := filepath.Join(dir, "photo.jpg"), filepath.Join(dir, "copy", "photo.jpg")
+
+	out, code := runDeduperWithInput(t, "2\n", "-interactive", "-apply", dir)
+	if code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if !exists(copied) || exists(photo) {
+		t.Errorf("the chosen copy/photo.jpg wasn't kept as the original: %s", out)
+	}
+	if !exists(filepath.Join(dir, "copy", defaultRejectFolder, "photo_1.jpg")) {
+		t.Errorf("photo.jpg wasn't moved next to the chosen original: %s", out)
+	}
+}
+
+func TestCLI_InteractiveSkipAndEOF(t *testing.T) {
+	dir := duplicateTree(t)
+	if out, code := runDeduperWithInput(t, "3\ns\n", "-interactive", "-apply", dir); code != 0 || !strings.Contains(out, "Pick a number from 1 to 2") {
+		t.Errorf("an invalid choice wasn't asked again, exit code %d: %s", code, out)
+	}
+	if exists(filepath.Join(dir, defaultRejectFolder)) {
+		t.Error("the skipped group was moved")
+	}
+
+	out, code := runDeduperWithInput(t, "", "-interactive", "-apply", dir)
+	if code != 0 || !strings.Contains(out, "keeping the automatic choice") {
+		t.Errorf("running out of input didn't fall back to the automatic choice, exit code %d: %s", code, out)
+	}
+	if !exists(filepath.Join(dir, "photo.jpg")) || !exists(filepath.Join(dir, defaultRejectFolder, "photo_1.jpg")) {
+		t.Errorf("the automatic original photo.jpg wasn't kept: %s", out)
+	}
+}
+
+func TestCLI_ThreadsWalkSameAsSerial(t *testing.T) {
+	dir := t.TempDir()
+	for group := 0; group < 10; group++ {
+		for _, sub := range []string{"a", "b/c", "d/e/f", defaultRejectFolder} {
+			for _, ext := range []string{".jpg", ".txt"} {
+				path := filepath.Join(dir, sub, fmt.Sprintf("photo_%d%s", group, ext))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, []byte(fmt.Sprintf("photo %d", group)), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	serial, serialStatus := runDeduperSplit(t, "-all-groups", dir)
+	parallel, parallelStatus := runDeduperSplit(t, "-threads-walk", "8", "-all-groups", dir)
+	if parallel != serial {
+		t.Errorf("-threads-walk 8 printed\n%s\nthe serial walk printed\n%s", parallel, serial)
+	}
+	if want := "Found 10 duplicate groups among 30 files"; !strings.Contains(serialStatus, want) || !strings.Contains(parallelStatus, want) {
+		t.Errorf("the walks didn't both skip %s and the .txt files:\n%s\n%s", defaultRejectFolder, serialStatus, parallelStatus)
+	}
+}
+
+func TestCLI_Prefer(t *testing.T) {
+	dir := duplicateTree(t)
+	out, code := runDeduper(t, "-apply", "-prefer", filepath.Join(dir, "copy"), dir)
+	if code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if !exists(filepath.Join(dir, "copy", "photo.jpg")) || exists(filepath.Join(dir, "photo.jpg")) {
+		t.Errorf("the file in the -prefer directory wasn't kept over the shorter path: %s", out)
+	}
+}
+
+func TestCLI_FormatCSV(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		filepath.Join("beach, 2020", "photo.jpg"): "beach",
+		filepath.Join("backup", "photo.jpg"):      "beach",
+		filepath.Join("backup", "copy,1.jpg"):     "beach",
+		filepath.Join("backup", "party.jpg"):      "party",
+		filepath.Join("party.jpg"):                "party",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdout, _ := runDeduperSplit(t, "-format", "csv", dir)
+	rows, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	if err != nil {
+		t.Fatalf("the output isn't valid CSV: %s\n%s", err, stdout)
+	}
+	if want := []string{"group_id", "role", "path", "size_bytes", "hash"}; !reflect.DeepEqual(rows[0], want) {
+		t.Fatalf("the header is %q, want %q", rows[0], want)
+	}
+	originals := make(map[string]int)
+	duplicates := make(map[string]int)
+	for _, row := range rows[1:] {
+		if !exists(row[2]) {
+			t.Errorf("the path %q in the CSV doesn't exist", row[2])
+		}
+		switch row[1] {
+		case "original":
+			originals[row[0]]++
+		case "duplicate":
+			duplicates[row[0]]++
+		default:
+			t.Errorf("unknown role %q", row[1])
+		}
+	}
+	if want := map[string]int{"1": 1, "2": 1}; !reflect.DeepEqual(originals, want) {
+		t.Errorf("originals per group = %v, want %v", originals, want)
+	}
+	if want := map[string]int{"1": 2, "2": 1}; !reflect.DeepEqual(duplicates, want) {
+		t.Errorf("duplicates per group = %v, want %v", duplicates, want)
+	}
+}
+
+func TestCLI_IncludeHidden(t *testing.T) {
+	dir := duplicateTree(t)
+	thumbnails := filepath.Join(dir, ".thumbnails")
+	if err := os.Mkdir(thumbnails, 0755); err != nil {
+		t.Fatal(err)
+	}
+	hiddenFile, prunedFile := filepath.Join(dir, ".photo.jpg"), filepath.Join(thumbnails, "photo.jpg")
+	for _, path := range []string{hiddenFile, prunedFile} {
+		if err := ioutil.WriteFile(path, []byte("the same photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdout, stderr := runDeduperSplit(t, "-format", "edges", dir)
+	if strings.Contains(stdout, hiddenFile) || strings.Contains(stdout, thumbnails) || !strings.Contains(stderr, "among 2 files") {
+		t.Errorf("hidden files were scanned by default: %s%s", stdout, stderr)
+	}
+	stdout, stderr = runDeduperSplit(t, "-include-hidden", "-format", "edges", dir)
+	if !strings.Contains(stdout, hiddenFile) || !strings.Contains(stdout, prunedFile) || !strings.Contains(stderr, "among 4 files") {
+		t.Errorf("-include-hidden didn't scan the hidden file and directory: %s%s", stdout, stderr)
+	}
+}
+
+func TestCLI_Summary(t *testing.T) {
+	dir := duplicateTree(t)
+	// a file with the size of the duplicates that differs from them, one with a size of its own and one that isn't
+	// an image, which is seen but not compared
+	for name, content := range map[string]string{"other.jpg": "not that photo!", "near.jpg": "the same phot0", "notes.txt": "the same photo"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, code := runDeduper(t, dir)
+	if code != exitDuplicatesFound {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	for label, want := range map[string]string{
+		"Files found":          "5",
+		"Matching files":       "4",
+		"Same size candidates": "3",
+		"Duplicate groups":     "1",
+		"Duplicate files":      "1",
+		"Reclaimable":          "14 B",
+		"  .jpg":               "1 duplicates, 14 B",
+	} {
+		if !regexp.MustCompile(`(?m)^  ` + label + `: +` + regexp.QuoteMeta(want) + `$`).MatchString(out) {
+			t.Errorf("summary doesn't have %s: %s: %s", label, want, out)
+		}
+	}
+}
+
+func TestCLI_Diff(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.jpg", "first photo")
+	write("a-copy.jpg", "first photo")
+	write("b.jpg", "second photo")
+	write("b-copy.jpg", "second photo")
+	snapshot := filepath.Join(t.TempDir(), "previous.json")
+	if out, code := runDeduper(t, "-json-out", snapshot, dir); code != exitDuplicatesFound {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+
+	// the copy of b is cleaned up and a new pair of c appears
+	if err := os.Remove(filepath.Join(dir, "b-copy.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	write("c.jpg", "third photo!")
+	write("c-copy.jpg", "third photo!")
+	out, code := runDeduper(t, "-diff", snapshot, dir)
+	if code != exitDuplicatesFound {
+		t.Fatalf("deduper -diff exited with %d: %s", code, out)
+	}
+	for _, want := range []string{
+		"+ " + filepath.Join(dir, "c.jpg") + " with 1 duplicates, 12 B each\n\n",
+		"- " + filepath.Join(dir, "b.jpg") + " with 1 duplicates, 12 B each\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("-diff output doesn't have %q: %s", want, out)
+		}
+	}
+	if strings.Contains(out, "a.jpg with") {
+		t.Errorf("-diff reported the unchanged group of a.jpg: %s", out)
+	}
+}
+
+func TestCLI_DedupeIgnore(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"photo.jpg", "edit.tmp.jpg", "keep.jpg", "previews/photo.jpg", "sub/photo.jpg"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("the same photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ignore := "# generated files\n*.tmp.jpg\npreviews/\nkeep*.jpg\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, ignoreFileName), []byte(ignore), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a deeper ignore file takes keep.jpg back in, but only below its own directory
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", ignoreFileName), []byte("!keep.jpg\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "keep.jpg"), []byte("the same photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code := runDeduper(t, "-format", "edges", dir)
+	if code != exitDuplicatesFound {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	for _, name := range []string{"sub/photo.jpg", "sub/keep.jpg"} {
+		if !strings.Contains(out, filepath.Join(dir, filepath.FromSlash(name))) {
+			t.Errorf("%s isn't a duplicate: %s", name, out)
+		}
+	}
+	for _, name := range []string{"edit.tmp.jpg", "previews", dir + string(filepath.Separator) + "keep.jpg"} {
+		if strings.Contains(out, name) {
+			t.Errorf("the ignored %s was scanned: %s", name, out)
+		}
+	}
+}
+
+func TestCLI_ReportNearMiss(t *testing.T) {
+	dir := t.TempDir()
+	// the same size and the same first and last bytes, so only the full hash tells them apart
+	middle := bytes.Repeat([]byte("x"), 2*defaultHeadBlockSize)
+	for name, mark := range map[string]string{"a.jpg": "a", "b.jpg": "b"} {
+		content := append(append(append([]byte{}, middle...), mark...), middle...)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, code := runDeduper(t, "-report-nearmiss", "-format", "edges", dir)
+	if code != 0 {
+		t.Fatalf("deduper exited with %d, want 0 for no duplicates: %s", code, out)
+	}
+	want := fmt.Sprintf("2 different: %s, %s\n", filepath.Join(dir, "a.jpg"), filepath.Join(dir, "b.jpg"))
+	if !strings.Contains(out, "1 groups of same sized files that aren't all identical") || !strings.Contains(out, want) {
+		t.Errorf("the two files aren't reported as a near miss: %s", out)
+	}
+}
+
+func TestCLI_Delete(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		input   string
+		code    int
+		deleted bool
+	}{
+		{"dry run", []string{"-delete", "-yes"}, "", exitDuplicatesFound, false},
+		{"declined", []string{"-delete", "-apply"}, "n\n", exitDuplicatesFound, false},
+		{"no answer", []string{"-delete", "-apply"}, "", exitDuplicatesFound, false},
+		{"confirmed", []string{"-delete", "-apply"}, "y\n", 0, true},
+		{"yes", []string{"-delete", "-apply", "-yes"}, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := duplicateTree(t)
+			out, code := runDeduperWithInput(t, tt.input, append(tt.args, dir)...)
+			if code != tt.code {
+				t.Fatalf("deduper exited with %d, want %d: %s", code, tt.code, out)
+			}
+			if !exists(filepath.Join(dir, "photo.jpg")) {
+				t.Fatalf("the original was deleted: %s", out)
+			}
+			duplicate := filepath.Join(dir, "copy", "photo.jpg")
+			if exists(duplicate) == tt.deleted {
+				t.Errorf("the duplicate exists is %t, want %t: %s", exists(duplicate), !tt.deleted, out)
+			}
+			if tt.deleted && !strings.Contains(out, "Deleted "+duplicate) {
+				t.Errorf("the deletion wasn't printed: %s", out)
+			}
+			if exists(filepath.Join(dir, defaultRejectFolder)) || exists(filepath.Join(dir, "copy", defaultRejectFolder)) {
+				t.Errorf("-delete created a reject folder: %s", out)
+			}
+		})
+	}
+}
+
+func TestCLI_MaxGroup(t *testing.T) {
+	dir := duplicateTree(t)
+	// five identical files of another size are more than -max-group allows
+	for i := 0; i < 5; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("sidecar%d.jpg", i)), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, code := runDeduper(t, "-max-group", "4", "-format", "edges", dir)
+	if code != exitDuplicatesFound {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if !strings.Contains(out, "Skipped 5 files of 1 B, too many collisions for -max-group 4") {
+		t.Errorf("the oversized size group wasn't reported: %s", out)
+	}
+	if strings.Contains(out, "sidecar1.jpg") {
+		t.Errorf("the files of the oversized size group were compared: %s", out)
+	}
+	if !strings.Contains(out, filepath.Join(dir, "photo.jpg")+"\t"+filepath.Join(dir, "copy", "photo.jpg")) {
+		t.Errorf("the photo and its copy weren't found: %s", out)
+	}
+}
+
+func TestCLI_RejectFolderIsAWholeName(t *testing.T) {
+	dir := duplicateTree(t)
+	lookalike := filepath.Join(dir, defaultRejectFolder+"xyz")
+	if err := os.Mkdir(lookalike, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(lookalike, "photo.jpg"), []byte("the same photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code := runDeduper(t, "-format", "edges", dir)
+	if code != exitDuplicatesFound {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if !strings.Contains(out, filepath.Join(lookalike, "photo.jpg")) {
+		t.Errorf("a file in %s was skipped as if it was rejected: %s", lookalike, out)
+	}
+}
+
+func TestCLI_MaxBytes(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("the same photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the budget fits the 14 bytes of the first file but not the second
+	out, code := runDeduper(t, "-max-bytes", "20", "-workers", "1", dir)
+	if code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if !strings.Contains(out, "The -max-bytes budget of 20 B was used up, 2 files were not checked yet") {
+		t.Errorf("the unchecked files weren't reported: %s", out)
+	}
+	for name, want := range map[string]bool{"a.jpg": false, "b.jpg": true, "c.jpg": true} {
+		if got := strings.Contains(out, fmt.Sprintf(" - '%s'", filepath.Join(dir, name))); got != want {
+			t.Errorf("%s listed as not checked is %t, want %t: %s", name, got, want, out)
+		}
+	}
+	if !strings.Contains(out, "No duplicates found") {
+		t.Errorf("a single hashed file was reported as a duplicate: %s", out)
+	}
+}
+
+func TestCLI_Verbose(t *testing.T) {
+	dir := duplicateTree(t)
+	notes := filepath.Join(dir, "notes.txt")
+	if err := ioutil.WriteFile(notes, []byte("the same photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr := runDeduperSplit(t, "-v=2", dir)
+	for _, want := range []string{
+		fmt.Sprintf(`level=DEBUG msg=skipped path=%s reason="wrong extension"`, notes),
+		`level=INFO msg="size group" size=14 files=2`,
+		`msg="hash group"`,
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr doesn't have %s: %s", want, stderr)
+		}
+	}
+	if strings.Contains(stdout, "level=") {
+		t.Errorf("the log ended up in stdout: %s", stdout)
+	}
+
+	if _, stderr := runDeduperSplit(t, dir); strings.Contains(stderr, "level=") {
+		t.Errorf("logged without -v: %s", stderr)
+	}
+}
+
+func TestCLI_MinCopies(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"pair.jpg": "two of these", "pair-copy.jpg": "two of these",
+		"triple.jpg": "three of these", "triple-copy.jpg": "three of these", "triple-copy2.jpg": "three of these",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, code := runDeduper(t, "-min-copies=3", "-format", "edges", dir)
+	if code != exitDuplicatesFound {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if !strings.Contains(out, filepath.Join(dir, "triple.jpg")+"\t"+filepath.Join(dir, "triple-copy.jpg")) {
+		t.Errorf("the group of three is missing: %s", out)
+	}
+	if strings.Contains(out, "pair-copy.jpg") {
+		t.Errorf("the group of two was reported: %s", out)
+	}
+	if !strings.Contains(out, "Left out 1 duplicate groups with fewer than 3 copies") {
+		t.Errorf("the left out group wasn't counted: %s", out)
+	}
+}
+
+func TestCLI_KeepExif(t *testing.T) {
+	dir := t.TempDir()
+	photo := exifJPEG("2019:06:01 12:00:00")
+	older := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	// the copies have the same EXIF date, so the one with the oldest modification time is kept over the shorter path
+	for name, mtime := range map[string]time.Time{"photo.jpg": older.Add(time.Hour), "from-camera.jpg": older} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, photo, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, code := runDeduper(t, "-keep", "exif", "-format", "edges", dir)
+	if code != exitDuplicatesFound {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if want := filepath.Join(dir, "from-camera.jpg") + "\t" + filepath.Join(dir, "photo.jpg"); !strings.Contains(out, want) {
+		t.Errorf("-keep exif didn't keep from-camera.jpg: %s", out)
+	}
+}
+
+// rejectedTree is a tree after an earlier run moved copy/photo.jpg into the reject folder of photo.jpg, with a second
+// rejected file that has no copy left outside of it
+func rejectedTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	rejected := filepath.Join(dir, defaultRejectFolder)
+	if err := os.MkdirAll(filepath.Join(rejected, "lookalikes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"photo.jpg":                                    "the same photo",
+		defaultRejectFolder + "/photo.jpg":             "the same photo",
+		defaultRejectFolder + "/orphan.jpg":            "only one left",
+		defaultRejectFolder + "/lookalikes/orphan.jpg": "only one left",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCLI_RejectedFilesAreSkipped(t *testing.T) {
+	dir := rejectedTree(t)
+	out, code := runDeduper(t, "-format", "edges", dir)
+	if code != 0 {
+		t.Fatalf("deduper exited with %d, want no duplicates outside the reject folder: %s", code, out)
+	}
+	if strings.Contains(out, defaultRejectFolder+string(filepath.Separator)) {
+		t.Errorf("a rejected file was scanned: %s", out)
+	}
+}
+
+func TestCLI_RescanRejected(t *testing.T) {
+	dir := rejectedTree(t)
+	rejected := filepath.Join(dir, defaultRejectFolder)
+	out, code := runDeduper(t, "-rescan-rejected", "-format", "edges", "-apply", dir)
+	if code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	for _, want := range []string{
+		filepath.Join(dir, "photo.jpg") + "\t" + filepath.Join(rejected, "photo.jpg"),
+		filepath.Join(rejected, "orphan.jpg") + "\t" + filepath.Join(rejected, "lookalikes", "orphan.jpg"),
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output doesn't have %q: %s", want, out)
+		}
+	}
+	// the rejected files are already where they belong and aren't moved a second time
+	for _, path := range []string{filepath.Join(rejected, "photo.jpg"), filepath.Join(rejected, "lookalikes", "orphan.jpg")} {
+		if !exists(path) {
+			t.Errorf("%s was moved again: %s", path, out)
+		}
+	}
+	if exists(filepath.Join(rejected, defaultRejectFolder)) {
+		t.Errorf("a reject folder was made inside the reject folder: %s", out)
+	}
+}
+
+func TestCLI_EmptyFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, code := runDeduper(t, "-format", "edges", dir)
+	if code != 0 {
+		t.Fatalf("deduper exited with %d, want no duplicates: %s", code, out)
+	}
+	if !strings.Contains(out, "Skipped 3 empty files, use -include-empty to compare them") || strings.Contains(out, "b.jpg") {
+		t.Errorf("the empty files weren't left out: %s", out)
+	}
+
+	out, code = runDeduper(t, "-include-empty", "-format", "edges", dir)
+	if code != exitDuplicatesFound {
+		t.Fatalf("deduper -include-empty exited with %d: %s", code, out)
+	}
+	for _, name := range []string{"b.jpg", "c.jpg"} {
+		if !strings.Contains(out, filepath.Join(dir, "a.jpg")+"\t"+filepath.Join(dir, name)) {
+			t.Errorf("%s isn't a duplicate of a.jpg with -include-empty: %s", name, out)
+		}
+	}
+}
+
+func TestCLI_Profile(t *testing.T) {
+	dir := duplicateTree(t)
+	cpu := filepath.Join(t.TempDir(), "cpu.out")
+	_, stderr := runDeduperSplit(t, "-profile="+cpu, dir)
+	if !strings.Contains(stderr, "\nProfile\n") {
+		t.Fatalf("no profile was printed: %s", stderr)
+	}
+	for _, phase := range []string{"walk:", "end blocks:", "full hash:", "move:"} {
+		if !strings.Contains(stderr, phase) {
+			t.Errorf("the profile doesn't have the %s phase: %s", phase, stderr)
+		}
+	}
+	if info, err := os.Stat(cpu); err != nil || info.Size() == 0 {
+		t.Errorf("no CPU profile was written: %v", err)
+	}
+
+	if _, stderr := runDeduperSplit(t, dir); strings.Contains(stderr, "Profile") {
+		t.Errorf("a profile was printed without -profile: %s", stderr)
+	}
+}
+
+func TestCLI_Reference(t *testing.T) {
+	dir := t.TempDir()
+	master := filepath.Join(dir, "master")
+	work := filepath.Join(dir, "work")
+	files := map[string]string{
+		// a working copy of a reference file, kept in the reference even though the working path is shorter
+		filepath.Join(master, "archive", "2019", "beach.jpg"): "beach",
+		filepath.Join(work, "beach.jpg"):                      "beach",
+		// two reference files that only duplicate each other
+		filepath.Join(master, "party.jpg"):           "party",
+		filepath.Join(master, "backup", "party.jpg"): "party",
+		// working files that only duplicate each other
+		filepath.Join(work, "dog.jpg"):           "dog",
+		filepath.Join(work, "sorted", "dog.jpg"): "dog",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, code := runDeduper(t, "-apply", "-reference", master, work)
+	if code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	for _, path := range []string{
+		filepath.Join(master, "archive", "2019", "beach.jpg"),
+		filepath.Join(master, "party.jpg"),
+		filepath.Join(master, "backup", "party.jpg"),
+		filepath.Join(work, "dog.jpg"),
+		filepath.Join(work, defaultRejectFolder, "dog_1.jpg"),
+		filepath.Join(work, defaultRejectFolder, "beach_1.jpg"),
+	} {
+		if !exists(path) {
+			t.Errorf("%s doesn't exist: %s", path, out)
+		}
+	}
+	for _, path := range []string{
+		filepath.Join(work, "beach.jpg"),
+		filepath.Join(work, "sorted", "dog.jpg"),
+		filepath.Join(master, defaultRejectFolder),
+		filepath.Join(master, "archive", "2019", defaultRejectFolder),
+	} {
+		if exists(path) {
+			t.Errorf("%s still exists: %s", path, out)
+		}
+	}
+}
+
+func TestCLI_DedupeByName(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"IMG_1234.jpg":     "burst",
+		"IMG_1234 (1).jpg": "burst",
+		// identical to the burst, but with an unrelated name that -dedupe-by-name doesn't compare
+		"beach.jpg": "burst",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdout, stderr := runDeduperSplit(t, "-dedupe-by-name", dir)
+	want := "\n" + filepath.Join(dir, "IMG_1234.jpg") + "\n" + filepath.Join(dir, "IMG_1234 (1).jpg") + "\n"
+	if !strings.Contains(stdout, want) {
+		t.Errorf("the burst copies weren't grouped:\n%s", stdout)
+	}
+	if strings.Contains(stdout, "beach.jpg") {
+		t.Errorf("a file with an unrelated name was compared:\n%s", stdout)
+	}
+	if !strings.Contains(stderr, "The name comparison left out 1 of 3 same sized files") {
+		t.Errorf("the name comparison wasn't reported: %s", stderr)
+	}
+
+	if stdout, _ := runDeduperSplit(t, dir); !strings.Contains(stdout, "beach.jpg") {
+		t.Errorf("without -dedupe-by-name every file of the same size should be compared:\n%s", stdout)
+	}
+}
+
+func TestCLI_List(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"photo.jpg":                          "beach",
+		filepath.Join("backup", "photo.jpg"): "beach",
+		filepath.Join("backup", "copy.jpg"):  "beach",
+		"party.jpg":                          "party",
+		filepath.Join("backup", "party.jpg"): "party",
+		"alone.jpg":                          "alone",
+	}
+	if err := os.Mkdir(filepath.Join(dir, "backup"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := func(out string) []string {
+		got := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+		sort.Strings(got)
+		return got
+	}
+
+	stdout, _ := runDeduperSplit(t, "-list", "originals", dir)
+	want := []string{filepath.Join(dir, "party.jpg"), filepath.Join(dir, "photo.jpg")}
+	if got := lines(stdout); !reflect.DeepEqual(got, want) {
+		t.Errorf("-list originals printed %q, want %q", got, want)
+	}
+
+	stdout, _ = runDeduperSplit(t, "-list", "duplicates", dir)
+	want = []string{filepath.Join(dir, "backup", "copy.jpg"), filepath.Join(dir, "backup", "party.jpg"), filepath.Join(dir, "backup", "photo.jpg")}
+	if got := lines(stdout); !reflect.DeepEqual(got, want) {
+		t.Errorf("-list duplicates printed %q, want %q", got, want)
+	}
+
+	if out, code := runDeduper(t, "-list", "everything", dir); code != 1 || !strings.Contains(out, "unknown -list 'everything'") {
+		t.Errorf("an unknown -list exited with %d: %s", code, out)
+	}
+	if out, code := runDeduper(t, "-list", "originals", "-format", "json", dir); code != 1 || !strings.Contains(out, "-list can't be combined with -format") {
+		t.Errorf("-list with -format exited with %d: %s", code, out)
+	}
+}
+
+func TestCLI_FormatJSONL(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"photo.jpg":      "beach",
+		"photo copy.jpg": "beach",
+		"party.jpg":      "party time",
+		"party_1.jpg":    "party time",
+		"party_2.jpg":    "party time",
+		"alone.jpg":      "alone",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdout, _ := runDeduperSplit(t, "-format", "jsonl", dir)
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want a line for each of the 2 groups, got:\n%s", stdout)
+	}
+	got := make(map[int64][]string)
+	for _, line := range lines {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("%q isn't valid JSON: %s", line, err)
+		}
+		for _, field := range []string{"hash", "size", "files"} {
+			if _, ok := fields[field]; !ok {
+				t.Errorf("%q doesn't have the %s field", line, field)
+			}
+		}
+		var group streamedGroup
+		if err := json.Unmarshal([]byte(line), &group); err != nil {
+			t.Fatal(err)
+		}
+		if len(group.Hash) != 40 {
+			t.Errorf("%q doesn't have a SHA1 hash", line)
+		}
+		got[group.Size] = group.Files
+	}
+	want := map[int64][]string{
+		5:  {filepath.Join(dir, "photo copy.jpg"), filepath.Join(dir, "photo.jpg")},
+		10: {filepath.Join(dir, "party.jpg"), filepath.Join(dir, "party_1.jpg"), filepath.Join(dir, "party_2.jpg")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("the streamed groups are %q, want %q", got, want)
+	}
+}
+
+// runDeduperAtPrompt runs the command with args, which have to include -interactive, calls atPrompt once it asks which
+// file of the first group to keep and then answers with input. It returns everything written and the exit code.
+func runDeduperAtPrompt(t *testing.T, atPrompt func(), input string, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	buf := make([]byte, 1024)
+	for !strings.Contains(out.String(), "Keep which file?") {
+		n, err := stderr.Read(buf)
+		out.Write(buf[:n])
+		if err != nil {
+			t.Fatalf("deduper didn't ask for the original: %s", out.String())
+		}
+	}
+	atPrompt()
+	io.WriteString(stdin, input)
+	stdin.Close()
+	rest, _ := ioutil.ReadAll(stderr)
+	out.Write(rest)
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return out.String(), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), 0
+}
+
+func TestCLI_OriginalGoneBeforeMove(t *testing.T) {
+	dir := duplicateTree(t)
+	original := filepath.Join(dir, "photo.jpg")
+	duplicate := filepath.Join(dir, "copy", "photo.jpg")
+	journalPath := filepath.Join(t.TempDir(), "journal")
+
+	// -interactive waits for an answer after the hash sweep, which is when the original is deleted
+	out, code := runDeduperAtPrompt(t, func() {
+		if err := os.Remove(original); err != nil {
+			t.Fatal(err)
+		}
+	}, "\n", "-apply", "-interactive", "-journal", journalPath, dir)
+	if code != exitSkippedFiles {
+		t.Errorf("deduper exited with %d, want %d: %s", code, exitSkippedFiles, out)
+	}
+	if !exists(duplicate) {
+		t.Errorf("the only copy left was moved: %s", out)
+	}
+	if !strings.Contains(out, "Skipping the group of "+original+", the original is gone") {
+		t.Errorf("the skipped group wasn't reported: %s", out)
+	}
+
+	// the skipped group is closed in the journal, the next run has nothing to finish there
+	out, _ = runDeduper(t, "-apply", "-journal", journalPath, "-yes", dir)
+	if !exists(duplicate) || strings.Contains(out, "didn't finish") {
+		t.Errorf("the next run resumed the skipped group: %s", out)
+	}
+}
+
+func TestCLI_MoveErrorIsCollected(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.jpg", "a_1.jpg", "a_2.jpg", "b.jpg", "b_1.jpg"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name[:1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a_1.jpg disappears before it is moved, which shouldn't stop the other duplicate of its group or the next group
+	gone := filepath.Join(dir, "a_1.jpg")
+	out, code := runDeduperAtPrompt(t, func() {
+		if err := os.Remove(gone); err != nil {
+			t.Fatal(err)
+		}
+	}, "\n\n", "-apply", "-interactive", dir)
+	if code != exitSkippedFiles {
+		t.Errorf("deduper exited with %d, want %d: %s", code, exitSkippedFiles, out)
+	}
+	for _, name := range []string{"a.jpg", "b.jpg", filepath.Join(defaultRejectFolder, "a_2.jpg"), filepath.Join(defaultRejectFolder, "b_1.jpg")} {
+		if !exists(filepath.Join(dir, name)) {
+			t.Errorf("%s doesn't exist, the other moves didn't finish: %s", name, out)
+		}
+	}
+	if !strings.Contains(out, "The following files could not be handled and were left as they are") || !strings.Contains(out, gone) {
+		t.Errorf("the failed move wasn't reported at the end: %s", out)
+	}
+}
+
+func TestCLI_RenameTemplate(t *testing.T) {
+	dir := duplicateTree(t)
+	out, code := runDeduper(t, "-apply", "-rename-template", "{parent}-{base}_{n}{ext}", dir)
+	if code != 0 {
+		t.Fatalf("deduper exited with %d: %s", code, out)
+	}
+	if want := filepath.Join(dir, defaultRejectFolder, filepath.Base(dir)+"-photo_1.jpg"); !exists(want) {
+		t.Errorf("the duplicate wasn't moved to %s: %s", want, out)
+	}
+}
+
+func TestCLI_Histogram(t *testing.T) {
+	dir := duplicateTree(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "clip.mov"), make([]byte, 2<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := runDeduperSplit(t, "-histogram", dir)
+	for _, want := range []string{
+		`Files by size and extension`,
+		`\.mov +0 files, 0 B +1 files, 2\.0 MiB `,
+		`\.jpg +2 files, 28 B +0 files, 0 B `,
+		`total +2 files, 28 B +1 files, 2\.0 MiB .* 3 files, 2\.0 MiB`,
+	} {
+		if !regexp.MustCompile(want).MatchString(stdout) {
+			t.Errorf("the histogram doesn't match %q:\n%s", want, stdout)
+		}
+	}
+	if strings.Contains(stderr, "Comparing") || !exists(filepath.Join(dir, "copy", "photo.jpg")) {
+		t.Errorf("-histogram went on to compare the files: %s", stderr)
+	}
+}
+
+func TestCLI_RawJPEG(t *testing.T) {
+	for keep, moved := range map[string]string{"raw": "DSC_001.jpg", "jpeg": "DSC_001.nef"} {
+		dir := t.TempDir()
+		for name, content := range map[string]string{"DSC_001.nef": "the raw sensor data", "DSC_001.jpg": "the jpeg", "DSC_002.jpg": "no raw"} {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		out, code := runDeduper(t, "-apply", "-raw-jpeg", "-raw-jpeg-keep", keep, dir)
+		if code != 0 {
+			t.Fatalf("-raw-jpeg-keep %s exited with %d: %s", keep, code, out)
+		}
+		kept := map[string]string{"raw": "DSC_001.nef", "jpeg": "DSC_001.jpg"}[keep]
+		if !exists(filepath.Join(dir, kept)) || exists(filepath.Join(dir, moved)) {
+			t.Errorf("-raw-jpeg-keep %s didn't keep %s and move %s: %s", keep, kept, moved, out)
+		}
+		if !exists(filepath.Join(dir, defaultRejectFolder, strings.TrimSuffix(moved, filepath.Ext(moved))+"_1"+filepath.Ext(moved))) {
+			t.Errorf("%s wasn't moved into the reject folder: %s", moved, out)
+		}
+		if !exists(filepath.Join(dir, "DSC_002.jpg")) || !strings.Contains(out, "Found 1 RAW+JPEG pairs among 3 files") {
+			t.Errorf("a JPEG without a RAW was paired: %s", out)
+		}
+	}
+}
+
+func TestCLI_RawJPEGLeavesReferenceAndProtected(t *testing.T) {
+	dir := t.TempDir()
+	ref, lib := filepath.Join(dir, "ref"), filepath.Join(dir, "lib")
+	for _, name := range []string{"ref/a.nef", "ref/a.jpg", "lib/b.nef", "lib/b.jpg", "lib/keep_me.nef", "lib/keep_me.jpg"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, code := runDeduper(t, "-apply", "-raw-jpeg", "-reference", ref, "-protect-names", "keep_me.*", lib)
+	if code != 0 {
+		t.Fatalf("-raw-jpeg exited with %d: %s", code, out)
+	}
+	for _, kept := range []string{"ref/a.jpg", "lib/keep_me.jpg"} {
+		if !exists(filepath.Join(dir, filepath.FromSlash(kept))) {
+			t.Errorf("%s was moved: %s", kept, out)
+		}
+	}
+	if exists(filepath.Join(lib, "b.jpg")) {
+		t.Errorf("lib/b.jpg wasn't moved: %s", out)
+	}
+
+	for _, flag := range []string{"-trash", "-delete", "-link"} {
+		if out, code := runDeduper(t, "-apply", "-raw-jpeg", flag, lib); code != 1 || !strings.Contains(out, "-raw-jpeg can't be combined") {
+			t.Errorf("-raw-jpeg -apply %s = %d, want it refused: %s", flag, code, out)
+		}
+	}
+	if out, code := runDeduper(t, "-apply", "-raw-jpeg", "-journal", filepath.Join(dir, "journal"), lib); code != 1 || !strings.Contains(out, "-raw-jpeg can't be combined") {
+		t.Errorf("-raw-jpeg -apply -journal = %d, want it refused: %s", code, out)
+	}
+}
+
+func TestCLI_Output(t *testing.T) {
+	dir := duplicateTree(t)
+	want, _ := runDeduperSplit(t, dir)
+	output := filepath.Join(t.TempDir(), "duplicates.txt")
+	stdout, stderr := runDeduperSplit(t, "-output", output, dir)
+	if stdout != "" {
+		t.Errorf("the results were still written to stdout: %s", stdout)
+	}
+	if !strings.Contains(stderr, "Found 1 duplicate groups") {
+		t.Errorf("the summary didn't go to stderr: %s", stderr)
+	}
+	got, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("-output wrote %q, want %q", got, want)
+	}
+}
+
+func TestCLI_OutputKeptWhenTheRunFails(t *testing.T) {
+	dir := duplicateTree(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "party.jpg"), []byte("party"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "copy", "party.jpg"), []byte("party"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "duplicates.jsonl")
+	if err := ioutil.WriteFile(output, []byte("the last report\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first group is streamed before the second one aborts the run
+	out, code := runDeduper(t, "-format", "jsonl", "-workers", "1", "-abort-after-groups", "1", "-output", output, dir)
+	if code != exitAborted {
+		t.Fatalf("deduper exited with %d, want %d: %s", code, exitAborted, out)
+	}
+	if got, err := ioutil.ReadFile(output); err != nil || string(got) != "the last report\n" {
+		t.Errorf("the existing output was changed to %q, %v", got, err)
+	}
+	if exists(output + ".tmp") {
+		t.Error("the partial output was left behind")
+	}
+}
+
+func TestCLI_Explain(t *testing.T) {
+	dir := duplicateTree(t)
+	copies := filepath.Join(dir, "copy")
+
+	stdout, _ := runDeduperSplit(t, "-explain", dir)
+	if want := filepath.Join(dir, "photo.jpg") + "  kept (shortest path)\n"; !strings.Contains(stdout, want) {
+		t.Errorf("-explain didn't say the original was the shortest path: %s", stdout)
+	}
+	stdout, _ = runDeduperSplit(t, "-explain", "-prefer", copies, "-sort", "waste", dir)
+	if want := filepath.Join(copies, "photo.jpg") + " (14 B reclaimable)  kept (matches -prefer " + copies + ")\n"; !strings.Contains(stdout, want) {
+		t.Errorf("-explain didn't name the -prefer directory: %s", stdout)
+	}
+	stdout, _ = runDeduperSplit(t, dir)
+	if strings.Contains(stdout, "kept (") {
+		t.Errorf("the reasons were shown without -explain: %s", stdout)
+	}
+
+	if out, code := runDeduper(t, "-explain", "-format", "json", dir); code != 1 || !strings.Contains(out, "-explain shows the reasons in the text output") {
+		t.Errorf("-explain -format json exited with %d: %s", code, out)
+	}
+}
+
+func TestCLI_PruneEmpty(t *testing.T) {
+	dir := duplicateTree(t)
+	copies := filepath.Join(dir, "copy")
+
+	// the duplicate is the last file in copy, a dry run only lists the directory
+	out, _ := runDeduper(t, "-prune-empty", dir)
+	if !exists(copies) || !strings.Contains(out, "Would remove the empty directory "+copies) {
+		t.Errorf("-prune-empty without -apply didn't just list the directory it would remove: %s", out)
+	}
+
+	out, code := runDeduper(t, "-apply", dir)
+	if code != 0 || !exists(copies) || strings.Contains(out, "empty directory") {
+		t.Errorf("-apply without -prune-empty removed the directory the duplicate was in, exit code %d: %s", code, out)
+	}
+
+	// put the duplicate back to move it again with -prune-empty
+	if err := os.Rename(filepath.Join(dir, defaultRejectFolder, "photo_1.jpg"), filepath.Join(copies, "photo.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	out, code = runDeduper(t, "-apply", "-prune-empty", dir)
+	if code != 0 || exists(copies) || !strings.Contains(out, "Removed the empty directory "+copies) {
+		t.Errorf("-prune-empty -apply didn't remove the empty directory, exit code %d: %s", code, out)
+	}
+	if !exists(filepath.Join(dir, "photo.jpg")) {
+		t.Errorf("-prune-empty removed the root or the original")
+	}
+}
+
+func TestCLI_ReadOnlyChangesNothing(t *testing.T) {
+	for _, flags := range [][]string{
+		{"-apply"},
+		{"-trash"},
+		{"-trash", "-apply"},
+		{"-prune-empty"},
+		{"-prune-empty", "-apply"},
+		{"-normalize-originals", "{seq}{ext}"},
+		{"-normalize-originals", "{seq}{ext}", "-apply"},
+		{"-preserve-atime"},
+		// {root} is replaced by the scanned directory
+		{"-multi-hash", "{root}/manifest.json"},
+		{"-json-out", "{root}/report.json"},
+		{"-csv-out", "{root}/report.csv"},
+		{"-output", "{root}/duplicates.txt"},
+		{"-results", "{root}/copy/results.jsonl"},
+	} {
+		t.Run(strings.Join(flags, " "), func(t *testing.T) {
+			dir := duplicateTree(t)
+			before := treeState(t, dir)
+			args := []string{"-read-only"}
+			for _, flag := range flags {
+				args = append(args, strings.Replace(flag, "{root}", dir, 1))
+			}
+			out, _ := runDeduper(t, append(args, dir)...)
+			if after := treeState(t, dir); !reflect.DeepEqual(after, before) {
+				t.Errorf("-read-only %s changed the tree from %q to %q: %s", strings.Join(flags, " "), before, after, out)
+			}
+		})
+	}
+
+	// a report outside of the scanned tree is still written
+	dir := duplicateTree(t)
+	report := filepath.Join(t.TempDir(), "report.json")
+	if out, code := runDeduper(t, "-read-only", "-json-out", report, dir); code != exitDuplicatesFound || !exists(report) {
+		t.Errorf("-read-only -json-out outside of the root = %d, want the report written: %s", code, out)
+	}
+}
+
+// treeState returns every path below dir with the content and modification time of the files
+func treeState(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	state := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			state[path] = "dir"
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		state[path] = fmt.Sprintf("%s %s", data, info.ModTime())
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestCLI_ProgressSocketDisconnect(t *testing.T) {
+	dir := duplicateTree(t)
+	// enough files that the scan keeps sending events well after the monitor is gone
+	for i := 0; i < 500; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("unique_%d.jpg", i)), []byte(strings.Repeat("x", i+1)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// unix socket paths are limited to about a hundred bytes, which a test's temp dir can go over
+	sockDir, err := ioutil.TempDir("", "sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sockDir)
+	listener, err := net.Listen("unix", filepath.Join(sockDir, "progress"))
+	if err != nil {
+		t.Skipf("no unix sockets: %s", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- ""
+			return
+		}
+		// the monitor reads the first event and goes away in the middle of the scan
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		received <- line
+	}()
+
+	type result struct {
+		out  string
+		code int
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, code := runDeduper(t, "-progress-socket", listener.Addr().String(), dir)
+		done <- result{out, code}
+	}()
+	select {
+	case r := <-done:
+		if r.code != exitDuplicatesFound || !strings.Contains(r.out, "Found 1 duplicate groups") {
+			t.Errorf("deduper exited with %d after the monitor disconnected, want it to finish the scan: %s", r.code, r.out)
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("deduper is still running a minute after the monitor disconnected")
+	}
+	if line := <-received; !strings.Contains(line, `"event"`) {
+		t.Errorf("the monitor read %q before disconnecting, want an event", line)
+	}
+}
+
+func TestCLI_Bursts(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	sharp := testPattern(160, 120, false)
+	frames := map[string]image.Image{"burst_1.png": blurred(sharp), "burst_2.png": sharp, "burst_3.png": blurred(blurred(sharp))}
+	for i, name := range []string{"burst_1.png", "burst_2.png", "burst_3.png"} {
+		writeFrame(t, filepath.Join(dir, name), frames[name], start.Add(time.Duration(i)*time.Second))
+	}
+	// the same picture an hour later was another shot
+	writeFrame(t, filepath.Join(dir, "later.png"), sharp, start.Add(time.Hour))
+
+	stdout, stderr := runDeduperSplit(t, "-bursts", dir)
+	want := "\n" + strings.Join([]string{
+		filepath.Join(dir, "burst_2.png"),
+		filepath.Join(dir, "burst_1.png"),
+		filepath.Join(dir, "burst_3.png"),
+	}, "\n") + "\n"
+	if stdout != want {
+		t.Fatalf("-bursts printed %q, want %q: %s", stdout, want, stderr)
+	}
+
+	before := treeState(t, dir)
+	if out, code := runDeduperWithInput(t, "n\n", "-bursts", "-apply", dir); code != 0 || !strings.Contains(out, "Nothing was moved") {
+		t.Errorf("declining the moves exited with %d: %s", code, out)
+	}
+	if after := treeState(t, dir); !reflect.DeepEqual(after, before) {
+		t.Errorf("declining the moves changed the tree: %q", after)
+	}
+
+	if out, code := runDeduperWithInput(t, "y\n", "-bursts", "-apply", dir); code != 0 || !strings.Contains(out, "Move the 2 frames that aren't the sharpest") {
+		t.Fatalf("-bursts -apply exited with %d: %s", code, out)
+	}
+	for _, name := range []string{"burst_2.png", "later.png", filepath.Join(defaultRejectFolder, "burst_1_1.png"), filepath.Join(defaultRejectFolder, "burst_3_1.png")} {
+		if !exists(filepath.Join(dir, name)) {
+			t.Errorf("%s isn't there after keeping only the sharpest frame", name)
+		}
+	}
+	if exists(filepath.Join(dir, "burst_1.png")) || exists(filepath.Join(dir, "burst_3.png")) {
+		t.Error("the blurred frames are still next to the sharpest one")
+	}
+
+	if out, code := runDeduper(t, "-bursts", "-similar", dir); code != 1 {
+		t.Errorf("-bursts with -similar exited with %d, want 1: %s", code, out)
+	}
+}
+
+func TestCLI_SimilarTimeWindow(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	writeFrame(t, filepath.Join(dir, "monday.png"), testPattern(160, 120, false), day)
+	writeFrame(t, filepath.Join(dir, "tuesday.png"), testPattern(160, 120, false), day.Add(24*time.Hour))
+
+	if stdout, stderr := runDeduperSplit(t, "-similar", dir); !strings.Contains(stdout, "tuesday.png") {
+		t.Errorf("-similar didn't group the two views: %q %s", stdout, stderr)
+	}
+	if stdout, stderr := runDeduperSplit(t, "-similar", "-time-window", "2h", dir); stdout != "" || !strings.Contains(stderr, "Found 0 groups of similar images") {
+		t.Errorf("-time-window grouped views taken a day apart: %q %s", stdout, stderr)
+	}
+	if stdout, stderr := runDeduperSplit(t, "-similar", "-time-window", "48h", dir); !strings.Contains(stdout, "tuesday.png") {
+		t.Errorf("-time-window left out views within the window: %q %s", stdout, stderr)
+	}
+	if out, code := runDeduper(t, "-time-window", "2h", dir); code != 1 || !strings.Contains(out, "-time-window only applies to -similar") {
+		t.Errorf("-time-window without -similar exited with %d: %s", code, out)
+	}
+}
+
+func TestRun_SameGroupsAsFindDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"beach.jpg":              "beach",
+		"backup/beach.jpg":       "beach",
+		"backup/old/beach 2.jpg": "beach",
+		"city.jpg":               "city!",
+		"2019/city.jpg":          "city!",
+		"forest.jpg":             "trees",
+		"notes.txt":              "trees",
+		"_Rejected/city.jpg":     "city!",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a hardlink takes no space of its own, neither of them counts it as a duplicate
+	if err := os.Link(filepath.Join(dir, "forest.jpg"), filepath.Join(dir, "forest_link.jpg")); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := dedupe.FindDuplicates([]string{dir}, dedupe.Options{Extensions: []string{"jpg"}, RejectFolder: dedupe.DefaultRejectFolder})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := filepath.Join(t.TempDir(), "report.json")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-ext", "jpg", "-json-out", report, dir}, &stdout, &stderr); code != exitDuplicatesFound {
+		t.Fatalf("run() = %d, want %d: %s", code, exitDuplicatesFound, stderr.String())
+	}
+	data, err := ioutil.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed Report
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	var got [][]string
+	for _, group := range parsed.Groups {
+		paths := append([]string{group.Original}, group.Duplicates...)
+		sort.Strings(paths[1:])
+		got = append(got, paths)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i][0] < got[j][0] })
+
+	if len(want) != 2 {
+		t.Fatalf("FindDuplicates() = %q, want the beach and city groups", want)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("run() found the groups %q, FindDuplicates() found %q", got, want)
+	}
+}
+
+func TestRun_Fixture(t *testing.T) {
+	photos := filepath.Join("dedupe", "testdata", "photos")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{photos}, &stdout, &stderr); code != exitDuplicatesFound {
+		t.Fatalf("run() = %d, want %d: %s", code, exitDuplicatesFound, stderr.String())
+	}
+	want := "\n" + strings.Join([]string{
+		filepath.Join(photos, "2019", "beach.jpg"),
+		filepath.Join(photos, "backup", "beach.jpg"),
+		filepath.Join(photos, "backup", "beach_copy.jpg"),
+	}, "\n") + "\n"
+	if stdout.String() != want {
+		t.Errorf("run() wrote %q to stdout, want %q", stdout.String(), want)
+	}
+	if !strings.Contains(stderr.String(), "Found 1 duplicate groups among 4 files") {
+		t.Errorf("run() didn't write the summary to stderr: %s", stderr.String())
+	}
+}
+
+func TestRun_FatalErrorReturnsOne(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-hash=md5", t.TempDir()}, &stdout, &stderr); code != 1 {
+		t.Errorf("run() with an unknown -hash = %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "Error: 'unknown -hash 'md5'") || stdout.Len() != 0 {
+		t.Errorf("run() didn't report the error on stderr: %q %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestRun_WorkersPerDevice(t *testing.T) {
+	dir := duplicateTree(t)
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-workers", dir + "=1,2", dir}, &stdout, &stderr); code != exitDuplicatesFound {
+		t.Errorf("run() with -workers per device = %d, want %d: %s", code, exitDuplicatesFound, stderr.String())
+	}
+	stdout.Reset()
+	stderr.Reset()
+	if code := run([]string{"-workers", "/mnt/hdd=0", dir}, &stdout, &stderr); code != 1 {
+		t.Errorf("run() with a -workers count of 0 = %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "invalid count in -workers '/mnt/hdd=0'") {
+		t.Errorf("run() didn't report the invalid -workers: %s", stderr.String())
+	}
+}
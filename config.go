@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultConfigFile is read from the current directory when -config isn't given
+const defaultConfigFile = "deduper.yaml"
+
+// configValue is one flag value from a config file
+type configValue struct {
+	name  string
+	value string
+	line  int
+}
+
+// readConfig parses a config file. It is the flat part of YAML, a flag name and its value on each line, with a list of
+// "- value" lines below an empty value for flags such as -exclude that can be given more than once:
+//
+//	keep: oldest
+//	ext: jpg,png
+//	exclude:
+//	  - "*.tmp"
+//	  - cache
+func readConfig(path string) ([]configValue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var values []configValue
+	var list string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			if list == "" {
+				return nil, fmt.Errorf("%s:%d: list item without a flag name above it", path, n)
+			}
+			value, err := configScalar(strings.TrimSpace(line[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+			}
+			values = append(values, configValue{name: list, value: value, line: n})
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 1 {
+			return nil, fmt.Errorf("%s:%d: expected 'flag: value', got '%s'", path, n, line)
+		}
+		name, raw := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if raw == "" {
+			list = name
+			continue
+		}
+		list = ""
+		value, err := configScalar(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		values = append(values, configValue{name: name, value: value, line: n})
+	}
+	return values, scanner.Err()
+}
+
+// stripComment drops a # comment that starts the line or follows a space outside of quotes, so a # inside a value like
+// a glob is kept
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// configScalar unquotes a single or double quoted value, anything else is used as it is
+func configScalar(raw string) (string, error) {
+	if len(raw) >= 2 && raw[0] == '\'' && raw[len(raw)-1] == '\'' {
+		return strings.Replace(raw[1:len(raw)-1], "''", "'", -1), nil
+	}
+	if strings.HasPrefix(raw, `"`) {
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("bad quoted value %s", raw)
+		}
+		return value, nil
+	}
+	return raw, nil
+}
+
+// applyConfig sets the flags in the config file at path that weren't given on the command line, so the precedence is
+// the flag defaults, then the config file, then the command line
+func applyConfig(fs *flag.FlagSet, path string) error {
+	values, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, v := range values {
+		if v.name == "config" || fs.Lookup(v.name) == nil {
+			return fmt.Errorf("%s:%d: unknown flag '%s'", path, v.line, v.name)
+		}
+		if given[v.name] {
+			continue
+		}
+		if err := fs.Set(v.name, v.value); err != nil {
+			return fmt.Errorf("%s:%d: invalid value '%s' for -%s: %s", path, v.line, v.value, v.name, err)
+		}
+	}
+	return nil
+}
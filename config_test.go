@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, defaultConfigFile)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfig(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), `# defaults for the photo library
+keep: oldest
+ext: "jpg,png"   # only pictures
+exclude:
+  - '*.tmp'
+  - "#recycle"
+quiet: true
+`)
+	values, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, v := range values {
+		got = append(got, v.name+"="+v.value)
+	}
+	want := []string{"keep=oldest", "ext=jpg,png", "exclude=*.tmp", "exclude=#recycle", "quiet=true"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readConfig() = %q, want %q", got, want)
+	}
+}
+
+func TestApplyConfig_Precedence(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), "keep: oldest\nformat: json\nexclude:\n  - cache\n")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	keep := fs.String("keep", "shortest", "")
+	format := fs.String("format", "text", "")
+	quiet := fs.Bool("quiet", false, "")
+	var excludes stringList
+	fs.Var(&excludes, "exclude", "")
+	if err := fs.Parse([]string{"-format", "edges", "-exclude", "*.tmp"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if *keep != "oldest" {
+		t.Errorf("-keep = %s, want the config value oldest", *keep)
+	}
+	if *format != "edges" || !reflect.DeepEqual([]string(excludes), []string{"*.tmp"}) {
+		t.Errorf("-format = %s and -exclude = %q, want the command line values", *format, excludes)
+	}
+	if *quiet {
+		t.Error("-quiet isn't in the config but changed from its default")
+	}
+}
+
+func TestApplyConfig_Errors(t *testing.T) {
+	dir := t.TempDir()
+	for content, want := range map[string]string{
+		"colour: red\n":       "deduper.yaml:1: unknown flag 'colour'",
+		"\nquiet: maybe\n":    "deduper.yaml:2: invalid value 'maybe' for -quiet",
+		"  - orphan\n":        "deduper.yaml:1: list item without a flag name",
+		"keep oldest\n":       "deduper.yaml:1: expected 'flag: value'",
+		"config: other.yml\n": "unknown flag 'config'",
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Bool("quiet", false, "")
+		fs.String("config", "", "")
+		err := applyConfig(fs, writeConfig(t, dir, content))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("applyConfig(%q) = %v, want an error containing %q", content, err, want)
+		}
+	}
+}
+
+func TestRun_ConfigInWorkingDirectory(t *testing.T) {
+	dir := duplicateTree(t)
+	writeConfig(t, dir, "format: edges\n")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	var stdout, stderr bytes.Buffer
+	run([]string{"."}, &stdout, &stderr)
+	if want := "photo.jpg\tcopy/photo.jpg\n"; stdout.String() != want {
+		t.Errorf("with format: edges in %s the output was %q, want %q", defaultConfigFile, stdout.String(), want)
+	}
+
+	stdout.Reset()
+	run([]string{"-format", "json", "."}, &stdout, &stderr)
+	if !strings.HasPrefix(stdout.String(), "[") {
+		t.Errorf("-format json on the command line didn't win over the config file: %q", stdout.String())
+	}
+}
@@ -0,0 +1,245 @@
+// Package dedupe finds files with identical content. It is the scan and hash pipeline of the deduper command without
+// any of its reporting or moving, so that other programs can find duplicates without shelling out.
+//
+// The deduper command doesn't call FindDuplicates itself. It reports progress and collects the errors of each file
+// instead of stopping at the first one, compares first blocks before full sums, reuses a -cache, spends a -max-bytes
+// budget and can be interrupted with the groups found so far, none of which fit a call that returns when it is done.
+// It is built from the same parts, NormalizeExtensions, InRejectFolder, DuplicatesInt64, DuplicatesSHA1, ShortestIdx
+// and ReaderSumBuffer, and its tests check that both find the same groups.
+package dedupe
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Hash is the hex encoded digest of a file. Its length depends on the algorithm that produced it.
+type Hash string
+
+// ErrShortRead is returned when fewer bytes than the size of a file could be hashed
+var ErrShortRead = errors.New("short read")
+
+// DefaultRejectFolder is the name of the folder next to each original that the deduper command moves duplicates into
+const DefaultRejectFolder = "_Rejected"
+
+// Options change which files FindDuplicates looks at and which of them it keeps. The zero value scans every regular
+// file and keeps the shortest path of each group, like the deduper command without any flags.
+type Options struct {
+	// Extensions limits the scan to files with these extensions. They are compared in lower case and the dot is
+	// optional, so "JPG" is the same as ".jpg" like for the -ext flag. Every regular file is scanned if empty.
+	Extensions []string
+	// RejectFolder leaves out the files inside folders with this name, which is what the deduper command does with the
+	// folders it moved duplicates into. Set it to DefaultRejectFolder to match it, nothing is left out if empty.
+	RejectFolder string
+	// Skip is called for every file and directory, returning true leaves it, or everything inside it, out of the scan
+	Skip func(path string, info os.FileInfo) bool
+	// Original returns the index of the file to keep among the paths of a group, ShortestIdx if nil. An error stops
+	// FindDuplicates.
+	Original func(paths []string) (int, error)
+	// NewHash returns the hasher for the full file sums, SHA1 if nil
+	NewHash func() hash.Hash
+}
+
+// FindDuplicates walks every root and returns the groups of files with the same content. The original, the file
+// picked by opts.Original, is the first path of each group and the groups are ordered by it. Like the deduper command
+// it only scans the first path of a file that has several hardlinks, those take no space of their own.
+func FindDuplicates(roots []string, opts Options) ([][]string, error) {
+	extensions := make(map[string]bool)
+	for _, ext := range NormalizeExtensions(opts.Extensions) {
+		extensions[ext] = true
+	}
+	newHash := opts.NewHash
+	if newHash == nil {
+		newHash = sha1.New
+	}
+	fileSizes := make(map[int64][]string)
+	infos := make(map[int64][]os.FileInfo)
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if opts.Skip != nil && opts.Skip(path, info) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.Mode().IsRegular() || (len(extensions) > 0 && !extensions[strings.ToLower(filepath.Ext(path))]) {
+				return nil
+			}
+			if opts.RejectFolder != "" && InRejectFolder(path, opts.RejectFolder) {
+				return nil
+			}
+			// a hardlink has the same size as the file it links to, so only the files of that size are compared
+			for _, other := range infos[info.Size()] {
+				if os.SameFile(info, other) {
+					return nil
+				}
+			}
+			infos[info.Size()] = append(infos[info.Size()], info)
+			fileSizes[info.Size()] = append(fileSizes[info.Size()], path)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	candidates := DuplicatesInt64(fileSizes)
+	fileHashes := make(map[Hash][]string)
+	for _, path := range candidates {
+		sum, err := fileSum(path, newHash())
+		if err != nil {
+			return nil, err
+		}
+		fileHashes[sum] = append(fileHashes[sum], path)
+	}
+
+	groups := DuplicatesSHA1(fileHashes)
+	for _, paths := range groups {
+		i := ShortestIdx(paths)
+		if opts.Original != nil {
+			var err error
+			if i, err = opts.Original(paths); err != nil {
+				return nil, err
+			}
+		}
+		paths[0], paths[i] = paths[i], paths[0]
+		sort.Strings(paths[1:])
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups, nil
+}
+
+// NormalizeExtensions returns the extensions in lower case with a leading dot, without empty or repeated ones, which is
+// how the -ext flag of the deduper command reads them
+func NormalizeExtensions(extensions []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if !seen[ext] {
+			seen[ext] = true
+			result = append(result, ext)
+		}
+	}
+	return result
+}
+
+// InRejectFolder reports if path is inside a folder named rejectFolder. Only whole directory names count, so a file or
+// folder that merely starts with the name, like _Rejected2019, isn't inside one. Windows accepts both / and \ as the
+// separator, filepath.ToSlash turns them into the same one.
+func InRejectFolder(path, rejectFolder string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(filepath.Dir(path)), "/") {
+		if part == rejectFolder {
+			return true
+		}
+	}
+	return false
+}
+
+// FileSHA1Sum returns the SHA1 sum of a file
+func FileSHA1Sum(filePath string) (Hash, error) {
+	return fileSum(filePath, sha1.New())
+}
+
+// fileSum returns the sum of a file with hasher
+func fileSum(filePath string, hasher hash.Hash) (Hash, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	sum, err := ReaderSum(file, info.Size(), hasher)
+	if err != nil {
+		return sum, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return sum, nil
+}
+
+// ReaderSum hashes everything in r with hasher and returns ErrShortRead if that was not exactly size bytes
+func ReaderSum(r io.Reader, size int64, hasher hash.Hash) (Hash, error) {
+	return ReaderSumBuffer(r, size, hasher, nil)
+}
+
+// ReaderSumBuffer works like ReaderSum but reads through buf, larger buffers are faster on disks that prefer long
+// sequential reads. A nil buf uses the default size of io.Copy.
+func ReaderSumBuffer(r io.Reader, size int64, hasher hash.Hash, buf []byte) (Hash, error) {
+	// hiding any WriteTo method of r, like the one of *os.File, makes sure that buf is actually used
+	n, err := io.CopyBuffer(hasher, struct{ io.Reader }{r}, buf)
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", fmt.Errorf("%w: hashed %d of %d bytes", ErrShortRead, n, size)
+	}
+	return Hash(hex.EncodeToString(hasher.Sum(nil))), nil
+}
+
+// DuplicatesInt64 returns every path that shares its size with another path, sorted so that the result doesn't depend
+// on the order of the map
+func DuplicatesInt64(f map[int64][]string) []string {
+	var result []string
+	for _, paths := range f {
+		if len(paths) < 2 {
+			continue
+		}
+		result = append(result, paths...)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// DuplicatesSHA1 returns the groups of paths that share a hash. The paths of each group are sorted and the groups are
+// ordered by their first path, so the result doesn't depend on the order of the map or on the order files were found.
+func DuplicatesSHA1(f map[Hash][]string) [][]string {
+	var result [][]string
+	for _, paths := range f {
+		if len(paths) < 2 {
+			continue
+		}
+		group := append([]string(nil), paths...)
+		sort.Strings(group)
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i][0] < result[j][0] })
+	return result
+}
+
+// ShortestIdx returns the index of the shortest path. Paths of equal length are ordered lexically, so the same path
+// is picked no matter the order of a.
+func ShortestIdx(a []string) int {
+	idx := 0
+	for i, path := range a {
+		if Shorter(path, a[idx]) {
+			idx = i
+		}
+	}
+	return idx
+}
+
+// Shorter reports if path a comes before b in the order of ShortestIdx, by length and then lexically. Other ways of
+// picking an original use it to break their ties the same way.
+func Shorter(a, b string) bool {
+	return len(a) < len(b) || (len(a) == len(b) && a < b)
+}
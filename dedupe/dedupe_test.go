@@ -0,0 +1,300 @@
+package dedupe
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFileHashes_AddDuplicates(t *testing.T) {
+
+	type args struct {
+		h Hash
+		p string
+	}
+	tests := []struct {
+		name     string
+		existing string
+		toAdd    string
+		want     int
+	}{
+		{
+			name:     "simple_add",
+			existing: "",
+			toAdd:    "aaaaaaaaaaaaaaaaaaaa",
+			want:     0,
+		},
+		{
+			name:     "dupe_add",
+			existing: "aaaaaaaaaaaaaaaaaaaa",
+			toAdd:    "aaaaaaaaaaaaaaaaaaaa",
+			want:     1,
+		},
+
+		{
+			name:     "nondupe_add",
+			existing: "aaaaaaaaaaaaaaaaaaaa",
+			toAdd:    "bbbbbbbbbbbbbbbbbbbb",
+			want:     0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := make(map[Hash][]string)
+			if tt.existing != "" {
+				f[Hash(tt.existing)] = []string{tt.existing}
+			}
+
+			x := Hash(tt.toAdd)
+			f[x] = append(f[x], tt.toAdd)
+			dups := DuplicatesSHA1(f)
+			if tt.want != len(dups) {
+				t.Errorf("Duplicates() size = %v, want %v", len(dups), tt.want)
+				t.Errorf("%+v\n", f)
+				t.Errorf("%+v\n", dups)
+			}
+		})
+	}
+}
+
+// truncatingReader stops early as if the filesystem returned fewer bytes than the file size without an error
+type truncatingReader struct {
+	r    io.Reader
+	left int
+}
+
+func (t *truncatingReader) Read(p []byte) (int, error) {
+	if t.left <= 0 {
+		return 0, io.EOF
+	}
+	if len(p) > t.left {
+		p = p[:t.left]
+	}
+	n, err := t.r.Read(p)
+	t.left -= n
+	return n, err
+}
+
+func TestReaderSum_ShortReads(t *testing.T) {
+	data := bytes.Repeat([]byte("deduper"), 10000)
+	want := Hash(fmt.Sprintf("%x", sha1.Sum(data)))
+
+	got, err := ReaderSum(iotest.OneByteReader(bytes.NewReader(data)), int64(len(data)), sha1.New())
+	if err != nil {
+		t.Fatalf("ReaderSum() with one byte reads returned error %s", err)
+	}
+	if got != want {
+		t.Errorf("ReaderSum() with one byte reads = %s, want %s", got, want)
+	}
+
+	truncated := &truncatingReader{r: bytes.NewReader(data), left: len(data) / 2}
+	if _, err := ReaderSum(truncated, int64(len(data)), sha1.New()); !errors.Is(err, ErrShortRead) {
+		t.Errorf("ReaderSum() with a truncated read returned error %v, want %v", err, ErrShortRead)
+	}
+}
+
+func TestShortestIdx_EqualLengthTieBreak(t *testing.T) {
+	orders := [][]string{
+		{"/photos/b.jpg", "/photos/a.jpg", "/photos/c.jpg"},
+		{"/photos/c.jpg", "/photos/b.jpg", "/photos/a.jpg"},
+		{"/photos/a.jpg", "/photos/c.jpg", "/photos/b.jpg"},
+	}
+	for _, paths := range orders {
+		if got := paths[ShortestIdx(paths)]; got != "/photos/a.jpg" {
+			t.Errorf("ShortestIdx(%v) picked %s, want the lexically first /photos/a.jpg", paths, got)
+		}
+	}
+
+	paths := []string{"/photos/longer/a.jpg", "/photos/zz.jpg"}
+	if got := paths[ShortestIdx(paths)]; got != "/photos/zz.jpg" {
+		t.Errorf("ShortestIdx(%v) picked %s, want the shortest /photos/zz.jpg", paths, got)
+	}
+}
+
+func TestShortestIdx(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		want  int
+	}{
+		{"single", []string{"/a/b.jpg"}, 0},
+		{"shortest first", []string{"/a.jpg", "/photos/a.jpg"}, 0},
+		{"shortest last", []string{"/photos/2020/a.jpg", "/photos/a.jpg", "/a.jpg"}, 2},
+		{"equal length", []string{"/x/b.jpg", "/x/a.jpg"}, 1},
+		{"tie with a longer path between", []string{"/b.jpg", "/longer/a.jpg", "/a.jpg"}, 2},
+		{"identical paths", []string{"/a.jpg", "/a.jpg"}, 0},
+		{"upper case sorts first", []string{"/a.jpg", "/A.jpg"}, 1},
+		{"empty path", []string{"/a.jpg", ""}, 1},
+	}
+	for _, tt := range tests {
+		if got := ShortestIdx(tt.paths); got != tt.want {
+			t.Errorf("%s: ShortestIdx(%q) = %d, want %d", tt.name, tt.paths, got, tt.want)
+		}
+	}
+}
+
+func FuzzShortestIdx(f *testing.F) {
+	f.Add("/photos/a.jpg\x00/a.jpg\x00/b.jpg")
+	f.Add("")
+	f.Add("\x00\x00")
+	f.Fuzz(func(t *testing.T, joined string) {
+		paths := strings.Split(joined, "\x00")
+		idx := ShortestIdx(paths)
+		if idx < 0 || idx >= len(paths) {
+			t.Fatalf("ShortestIdx(%q) = %d, out of range", paths, idx)
+		}
+		for _, path := range paths {
+			if len(path) < len(paths[idx]) || (len(path) == len(paths[idx]) && path < paths[idx]) {
+				t.Fatalf("ShortestIdx(%q) picked %q over %q", paths, paths[idx], path)
+			}
+		}
+	})
+}
+
+func BenchmarkFileSHA1Sum(b *testing.B) {
+	for _, size := range []int{4 << 10, 1 << 20, 32 << 20} {
+		b.Run(fmt.Sprintf("%dKiB", size>>10), func(b *testing.B) {
+			path := filepath.Join(b.TempDir(), "photo.jpg")
+			if err := ioutil.WriteFile(path, bytes.Repeat([]byte{0x42}, size), 0644); err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := FileSHA1Sum(path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDuplicatesSHA1_SortedOrder(t *testing.T) {
+	f := map[Hash][]string{
+		"bb": {"/z/photo.jpg", "/b/photo.jpg", "/m/photo.jpg"},
+		"aa": {"/y/beach.jpg", "/c/beach.jpg"},
+		"cc": {"/a/unique.jpg"},
+	}
+	want := [][]string{
+		{"/b/photo.jpg", "/m/photo.jpg", "/z/photo.jpg"},
+		{"/c/beach.jpg", "/y/beach.jpg"},
+	}
+	for i := 0; i < 10; i++ {
+		if got := DuplicatesSHA1(f); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("DuplicatesSHA1() = %v, want %v", got, want)
+		}
+	}
+	if f["bb"][0] != "/z/photo.jpg" {
+		t.Error("DuplicatesSHA1() reordered the paths in the map")
+	}
+}
+
+func TestInRejectFolder(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"photos/_Rejected/photo.jpg", true},
+		{"photos/_Rejected/2019/photo.jpg", true},
+		{"_Rejected/photo.jpg", true},
+		{"photos/_Rejectedxyz/photo.jpg", false},
+		{"photos/old_Rejected/photo.jpg", false},
+		{"photos/_Rejected.jpg", false},
+		{"photos/photo_Rejected.jpg", false},
+		{`photos\_Rejected\photo.jpg`, filepath.Separator == '\\'},
+	}
+	for _, tt := range tests {
+		if got := InRejectFolder(filepath.FromSlash(tt.path), "_Rejected"); got != tt.want {
+			t.Errorf("InRejectFolder(%s) = %t, want %t", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeExtensions(t *testing.T) {
+	got := NormalizeExtensions([]string{"JPG", " .png ", "", ".jpg", "Tiff"})
+	want := []string{".jpg", ".png", ".tiff"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NormalizeExtensions() = %q, want %q", got, want)
+	}
+}
+
+func TestFindDuplicates_Options(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"a.JPG":                 "same",
+		"longer_name.jpg":       "same",
+		"_Rejected/a.jpg":       "same",
+		"notes.txt":             "same",
+		"other/different.jpg":   "different",
+		"other/different_2.jpg": "differenx",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	short := filepath.Join(dir, "a.JPG")
+	long := filepath.Join(dir, "longer_name.jpg")
+	if err := os.Link(long, filepath.Join(dir, "z_hardlink.jpg")); err != nil {
+		t.Skipf("no hardlinks: %s", err)
+	}
+
+	longest := func(paths []string) (int, error) {
+		i := 0
+		for j, path := range paths {
+			if len(path) > len(paths[i]) {
+				i = j
+			}
+		}
+		return i, nil
+	}
+	var hashed int
+	newHash := func() hash.Hash {
+		hashed++
+		return sha1.New()
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want [][]string
+	}{
+		{"extensions without a dot", Options{Extensions: []string{"JPG"}, RejectFolder: DefaultRejectFolder}, [][]string{{short, long}}},
+		{"reject folder", Options{Extensions: []string{".jpg"}}, [][]string{{short, filepath.Join(dir, "_Rejected", "a.jpg"), long}}},
+		{"original", Options{Extensions: []string{"jpg"}, RejectFolder: DefaultRejectFolder, Original: longest}, [][]string{{long, short}}},
+		{"hash", Options{Extensions: []string{"jpg"}, RejectFolder: DefaultRejectFolder, NewHash: newHash}, [][]string{{short, long}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FindDuplicates([]string{dir}, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindDuplicates() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	// the two different files of the same size are hashed too, the hardlink isn't
+	if hashed != 4 {
+		t.Errorf("FindDuplicates() hashed %d files with NewHash, want 4", hashed)
+	}
+
+	failed := errors.New("no original")
+	_, err := FindDuplicates([]string{dir}, Options{Original: func([]string) (int, error) { return 0, failed }})
+	if !errors.Is(err, failed) {
+		t.Errorf("FindDuplicates() = %v, want the error from Original", err)
+	}
+}
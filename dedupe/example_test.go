@@ -0,0 +1,25 @@
+package dedupe_test
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/stojg/deduper/dedupe"
+)
+
+func ExampleFindDuplicates() {
+	groups, err := dedupe.FindDuplicates([]string{"testdata/photos"}, dedupe.Options{Extensions: []string{".jpg"}})
+	if err != nil {
+		panic(err)
+	}
+	for _, paths := range groups {
+		fmt.Println("keep", filepath.ToSlash(paths[0]))
+		for _, duplicate := range paths[1:] {
+			fmt.Println("  duplicate", filepath.ToSlash(duplicate))
+		}
+	}
+	// Output:
+	// keep testdata/photos/2019/beach.jpg
+	//   duplicate testdata/photos/backup/beach.jpg
+	//   duplicate testdata/photos/backup/beach_copy.jpg
+}
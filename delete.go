@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// confirmDelete asks on in if the duplicates can be deleted for good, for -delete without -yes. Only y or yes is a
+// yes, so an empty answer or running out of input keeps everything.
+func confirmDelete(in io.Reader, out io.Writer, files int, size int64) bool {
+	fmt.Fprintf(out, "\nDelete %s duplicates with %s for good? This can't be undone [y/N]: ", thousands(files), humanBytes(size))
+	line, _ := bufio.NewReader(in).ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+// deleteDuplicate removes duplicate, but only after checking that original is still there. A file that was moved or
+// deleted since the scan would otherwise lose its last copy.
+func deleteDuplicate(original, duplicate string) error {
+	info, err := os.Stat(original)
+	if err != nil {
+		return fmt.Errorf("the original of %s is gone: %w", duplicate, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("the original %s of %s isn't a regular file anymore", original, duplicate)
+	}
+	return os.Remove(duplicate)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfirmDelete(t *testing.T) {
+	for input, want := range map[string]bool{"y\n": true, "YES\n": true, "\n": false, "n\n": false, "": false, "sure\n": false} {
+		if got := confirmDelete(strings.NewReader(input), ioutil.Discard, 1, 14); got != want {
+			t.Errorf("confirmDelete(%q) = %t, want %t", input, got, want)
+		}
+	}
+}
+
+func TestDeleteDuplicate_KeepsDuplicateWithoutOriginal(t *testing.T) {
+	dir := t.TempDir()
+	original, duplicate := filepath.Join(dir, "photo.jpg"), filepath.Join(dir, "copy.jpg")
+	if err := ioutil.WriteFile(duplicate, []byte("the same photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deleteDuplicate(original, duplicate); err == nil {
+		t.Fatal("deleteDuplicate() deleted the last copy of the photo")
+	}
+	if !exists(duplicate) {
+		t.Error("the duplicate is gone although its original doesn't exist")
+	}
+
+	if err := os.Mkdir(original, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteDuplicate(original, duplicate); err == nil || !exists(duplicate) {
+		t.Errorf("deleteDuplicate() with a directory as the original returned %v", err)
+	}
+}
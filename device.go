@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/stojg/deduper/dedupe"
+)
+
+// inode identifies a file on disk no matter how many paths link to it
+type inode struct {
+	dev, ino uint64
+}
+
+// deviceTiers maps a device id to a storage tier, where a lower tier is faster
+type deviceTiers map[uint64]int
+
+// parseTiers parses a spec like "/mnt/ssd=1,/mnt/hdd=2" into the tiers of the devices those paths are on
+func parseTiers(spec string) (deviceTiers, error) {
+	tiers := make(deviceTiers)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		idx := strings.LastIndex(entry, "=")
+		if idx < 1 {
+			return nil, fmt.Errorf("invalid tier '%s', expected path=rank", entry)
+		}
+		rank, err := strconv.Atoi(entry[idx+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rank in tier '%s': %s", entry, err)
+		}
+		dev, ok := deviceID(entry[:idx])
+		if !ok {
+			return nil, fmt.Errorf("could not detect the device for '%s'", entry[:idx])
+		}
+		tiers[dev] = rank
+	}
+	return tiers, nil
+}
+
+// unranked is the rank of devices without a configured tier, which puts them last
+const unranked = int(^uint(0) >> 1)
+
+// rank returns the tier of the device that path is on, devices without a configured tier are ranked last
+func (t deviceTiers) rank(path string) int {
+	dev, ok := deviceID(path)
+	if !ok {
+		return unranked
+	}
+	rank, ok := t[dev]
+	if !ok {
+		return unranked
+	}
+	return rank
+}
+
+// fastestDeviceIdx returns the index of the path on the fastest tier, with the shortest path winning between equal
+// tiers like for -keep oldest, and the reason for -explain
+func fastestDeviceIdx(paths []string, tiers deviceTiers) (int, string) {
+	idx := 0
+	best := tiers.rank(paths[0])
+	for i := 1; i < len(paths); i++ {
+		rank := tiers.rank(paths[i])
+		if rank < best || (rank == best && dedupe.Shorter(paths[i], paths[idx])) {
+			idx = i
+			best = rank
+		}
+	}
+	if best == unranked {
+		return idx, "shortest path, none of the files is on a -tiers device"
+	}
+	return idx, fmt.Sprintf("fastest device, tier %d", best)
+}
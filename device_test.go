@@ -0,0 +1,13 @@
+package main
+
+import "testing"
+
+func TestFastestDeviceIdx_TieBreak(t *testing.T) {
+	// without -tiers every file is on the same unranked tier, equally long paths must not depend on the order
+	for _, paths := range [][]string{{"b.jpg", "a.jpg", "long.jpg"}, {"long.jpg", "a.jpg", "b.jpg"}} {
+		i, _ := fastestDeviceIdx(paths, nil)
+		if paths[i] != "a.jpg" {
+			t.Errorf("fastestDeviceIdx(%q) = %s, want a.jpg", paths, paths[i])
+		}
+	}
+}
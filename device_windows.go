@@ -0,0 +1,18 @@
+package main
+
+import "os"
+
+// deviceID isn't supported on windows so every path ends up on the same unknown tier
+func deviceID(path string) (uint64, bool) {
+	return 0, false
+}
+
+// fileInode isn't supported on windows so symlinks to a scanned file are scanned like separate files
+func fileInode(info os.FileInfo) (inode, bool) {
+	return inode{}, false
+}
+
+// linkedInode isn't supported on windows so hardlinks are scanned like separate files
+func linkedInode(info os.FileInfo) (inode, bool) {
+	return inode{}, false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// readJSONReport reads a report written by -json-out
+func readJSONReport(filePath string) (Report, error) {
+	var report Report
+	file, err := os.Open(filePath)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&report); err != nil {
+		return report, fmt.Errorf("%s isn't a -json-out report: %s", filePath, err)
+	}
+	return report, nil
+}
+
+// diffGroups returns the groups in current whose content wasn't a duplicate group in previous, and the groups in
+// previous that are gone from current. Groups are matched by their hash so that renaming or moving one of the files
+// doesn't make its group look new.
+func diffGroups(previous, current []Group) (added, resolved []Group) {
+	before := make(map[string]bool)
+	for _, group := range previous {
+		before[group.Hash] = true
+	}
+	now := make(map[string]bool)
+	for _, group := range current {
+		now[group.Hash] = true
+		if !before[group.Hash] {
+			added = append(added, group)
+		}
+	}
+	for _, group := range previous {
+		if !now[group.Hash] {
+			resolved = append(resolved, group)
+		}
+	}
+	return added, resolved
+}
+
+// printGroupDiff writes the groups that appeared and disappeared since the snapshot in name for -diff
+func printGroupDiff(w io.Writer, name string, added, resolved []Group) {
+	fmt.Fprintf(w, "\nDuplicate groups that are new since %s\n\n", name)
+	for _, group := range added {
+		fmt.Fprintf(w, "+ %s with %d duplicates, %s each\n", group.Original, len(group.Duplicates), humanBytes(group.Size))
+	}
+	if len(added) == 0 {
+		fmt.Fprintln(w, "none")
+	}
+	fmt.Fprintf(w, "\nDuplicate groups in %s that are resolved\n\n", name)
+	for _, group := range resolved {
+		fmt.Fprintf(w, "- %s with %d duplicates, %s each\n", group.Original, len(group.Duplicates), humanBytes(group.Size))
+	}
+	if len(resolved) == 0 {
+		fmt.Fprintln(w, "none")
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffGroups(t *testing.T) {
+	previous := []Group{
+		{Original: "a.jpg", Duplicates: []string{"copy/a.jpg"}, Size: 10, Hash: "aaa"},
+		{Original: "b.jpg", Duplicates: []string{"copy/b.jpg"}, Size: 20, Hash: "bbb"},
+	}
+	current := []Group{
+		// the same content under new names is still the same group
+		{Original: "renamed/a.jpg", Duplicates: []string{"copy/a.jpg"}, Size: 10, Hash: "aaa"},
+		{Original: "c.jpg", Duplicates: []string{"copy/c.jpg"}, Size: 30, Hash: "ccc"},
+	}
+
+	added, resolved := diffGroups(previous, current)
+	if want := current[1:]; !reflect.DeepEqual(added, want) {
+		t.Errorf("diffGroups() added %v, want %v", added, want)
+	}
+	if want := previous[1:]; !reflect.DeepEqual(resolved, want) {
+		t.Errorf("diffGroups() resolved %v, want %v", resolved, want)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// dirMatch is a directory where every scanned file also exists in Other
+type dirMatch struct {
+	Dir   string
+	Other string
+	Files int
+	Both  bool // Other only contains files that exist in Dir as well, the directories are identical
+}
+
+// duplicateDirs finds directories where every scanned file has a duplicate in one other directory. Only the files
+// directly inside a directory are considered.
+func duplicateDirs(fileSizes map[int64][]string, duplicates [][]string) []dirMatch {
+	files := make(map[string][]string)
+	for _, paths := range fileSizes {
+		for _, path := range paths {
+			dir := filepath.Dir(path)
+			files[dir] = append(files[dir], path)
+		}
+	}
+
+	groupOf := make(map[string]int)
+	for i, paths := range duplicates {
+		for _, path := range paths {
+			groupOf[path] = i
+		}
+	}
+
+	// dirsWith returns the other directories that contain a copy of path
+	dirsWith := func(path string) map[string]bool {
+		result := make(map[string]bool)
+		i, ok := groupOf[path]
+		if !ok {
+			return result
+		}
+		for _, member := range duplicates[i] {
+			if dir := filepath.Dir(member); dir != filepath.Dir(path) {
+				result[dir] = true
+			}
+		}
+		return result
+	}
+
+	contained := make(map[string]map[string]bool)
+	for dir, paths := range files {
+		others := dirsWith(paths[0])
+		for _, path := range paths[1:] {
+			if len(others) == 0 {
+				break
+			}
+			with := dirsWith(path)
+			for other := range others {
+				if !with[other] {
+					delete(others, other)
+				}
+			}
+		}
+		if len(others) > 0 {
+			contained[dir] = others
+		}
+	}
+
+	var result []dirMatch
+	for dir, others := range contained {
+		for other := range others {
+			both := contained[other][dir]
+			if both && other < dir {
+				continue // already reported from the other side
+			}
+			result = append(result, dirMatch{Dir: dir, Other: other, Files: len(files[dir]), Both: both})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Dir != result[j].Dir {
+			return result[i].Dir < result[j].Dir
+		}
+		return result[i].Other < result[j].Other
+	})
+	return result
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+)
+
+// event is a progress update written as a line of JSON to the -progress-socket
+type event struct {
+	Event   string `json:"event"`
+	Phase   string `json:"phase,omitempty"`
+	Current int    `json:"current,omitempty"`
+	Total   int    `json:"total,omitempty"`
+	Dupe    bool   `json:"dupe,omitempty"`
+	Error   bool   `json:"error,omitempty"`
+	Groups  int    `json:"groups,omitempty"`
+}
+
+// eventSink writes events to a named pipe or unix socket. If the reader goes away the sink quietly stops writing
+// so that a monitor can never break a run.
+type eventSink struct {
+	w io.WriteCloser
+}
+
+// openEventSink opens path for writing, as a named pipe if it is one and otherwise as a unix socket
+func openEventSink(path string) (*eventSink, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeNamedPipe != 0 {
+		file, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &eventSink{w: file}, nil
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &eventSink{w: conn}, nil
+}
+
+func (s *eventSink) emit(e event) {
+	if s == nil || s.w == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if _, err := s.w.Write(append(data, '\n')); err != nil {
+		s.w.Close()
+		s.w = nil
+	}
+}
+
+func (s *eventSink) Close() {
+	if s == nil || s.w == nil {
+		return
+	}
+	s.w.Close()
+	s.w = nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// stringList is a flag that can be given several times, every value is appended
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// isExcluded reports if path matches one of the -exclude glob patterns, either by its name or by its path relative
+// to the root it was found under. Excluding a directory excludes everything inside it.
+func isExcluded(patterns []string, root, path string) bool {
+	name := filepath.Base(path)
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(filepath.FromSlash(pattern), rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// depthBelow returns how many directories path is below root, a directory directly inside root is at depth 1
+func depthBelow(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// isHidden reports if name is a dotfile or dot directory, which are left out of the scan without -include-hidden
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIsExcluded(t *testing.T) {
+	root := filepath.FromSlash("/photos")
+	patterns := []string{"*.tmp.jpg", "Previews", "Lightroom/Cache*"}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/photos/2020/edit.tmp.jpg", true},
+		{"/photos/Lightroom/Previews", true},
+		{"/photos/Lightroom/Cache 2", true},
+		{"/photos/2020/Cache 2", false},
+		{"/photos/2020/beach.jpg", false},
+		{"/photos/Lightroom", false},
+	}
+	for _, tt := range tests {
+		if got := isExcluded(patterns, root, filepath.FromSlash(tt.path)); got != tt.want {
+			t.Errorf("isExcluded(%s) = %t, want %t", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDepthBelow(t *testing.T) {
+	root := filepath.FromSlash("/photos")
+	for path, want := range map[string]int{"/photos": 0, "/photos/2020": 1, "/photos/2020/summer/beach.jpg": 3} {
+		if got := depthBelow(root, filepath.FromSlash(path)); got != want {
+			t.Errorf("depthBelow(%s) = %d, want %d", path, got, want)
+		}
+	}
+}
+
+func TestIsHidden(t *testing.T) {
+	for name, want := range map[string]bool{".thumbnails": true, ".DS_Store": true, "photo.jpg": false, ".": false, "..": false} {
+		if got := isHidden(name); got != want {
+			t.Errorf("isHidden(%s) = %t, want %t", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	fsIocFiemap        = 0xC020660B // FS_IOC_FIEMAP
+	fiemapFlagSync     = 0x1
+	fiemapExtentLast   = 0x1
+	fiemapExtentShared = 0x2000
+	fiemapMaxExtents   = 64
+)
+
+// fiemapExtent and fiemap mirror struct fiemap_extent and struct fiemap from linux/fiemap.h
+type fiemapExtent struct {
+	Logical  uint64
+	Physical uint64
+	Length   uint64
+	_        [2]uint64
+	Flags    uint32
+	_        [3]uint32
+}
+
+type fiemap struct {
+	Start         uint64
+	Length        uint64
+	Flags         uint32
+	MappedExtents uint32
+	ExtentCount   uint32
+	_             uint32
+	Extents       [fiemapMaxExtents]fiemapExtent
+}
+
+// sharesExtents reports if two files are already backed by the same physical extents, which is the case for copies
+// that were reflinked on a copy-on-write filesystem like Btrfs or XFS. Deduplicating those reclaims nothing.
+func sharesExtents(a, b string) bool {
+	extA, ok := fileExtents(a)
+	if !ok {
+		return false
+	}
+	extB, ok := fileExtents(b)
+	if !ok || len(extA) != len(extB) {
+		return false
+	}
+	for i := range extA {
+		if extA[i].Flags&fiemapExtentShared == 0 || extA[i].Physical != extB[i].Physical ||
+			extA[i].Logical != extB[i].Logical || extA[i].Length != extB[i].Length {
+			return false
+		}
+	}
+	return true
+}
+
+func fileExtents(path string) ([]fiemapExtent, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	return collectExtents(func(m *fiemap) bool {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(m)))
+		return errno == 0
+	})
+}
+
+// collectExtents asks query for the extents of a file fiemapMaxExtents at a time, starting each request where the last
+// extent of the previous one ended, until the extent flagged as the last one of the file
+func collectExtents(query func(m *fiemap) bool) ([]fiemapExtent, bool) {
+	var extents []fiemapExtent
+	var start uint64
+	for {
+		m := &fiemap{Start: start, Length: ^uint64(0) - start, Flags: fiemapFlagSync, ExtentCount: fiemapMaxExtents}
+		if !query(m) {
+			return nil, false
+		}
+		if m.MappedExtents == 0 {
+			// nothing past start means the last extent was seen without the flag, which some filesystems leave out
+			return extents, len(extents) > 0
+		}
+		extents = append(extents, m.Extents[:m.MappedExtents]...)
+		last := m.Extents[m.MappedExtents-1]
+		if last.Flags&fiemapExtentLast != 0 {
+			return extents, true
+		}
+		start = last.Logical + last.Length
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestCollectExtents_MoreThanOneRequest(t *testing.T) {
+	// a fragmented file with more extents than fit in a single request
+	const count = fiemapMaxExtents*2 + 5
+	var file []fiemapExtent
+	for i := uint64(0); i < count; i++ {
+		file = append(file, fiemapExtent{Logical: i * 4096, Physical: 1 << 20 * i, Length: 4096, Flags: fiemapExtentShared})
+	}
+	file[count-1].Flags |= fiemapExtentLast
+
+	requests := 0
+	extents, ok := collectExtents(func(m *fiemap) bool {
+		requests++
+		for _, e := range file {
+			if e.Logical >= m.Start && m.MappedExtents < m.ExtentCount {
+				m.Extents[m.MappedExtents] = e
+				m.MappedExtents++
+			}
+		}
+		return true
+	})
+	if !ok || len(extents) != count {
+		t.Fatalf("collectExtents() = %d extents, %t, want all %d", len(extents), ok, count)
+	}
+	for i := range file {
+		if extents[i] != file[i] {
+			t.Fatalf("extent %d = %+v, want %+v", i, extents[i], file[i])
+		}
+	}
+	if requests != 3 {
+		t.Errorf("collectExtents() made %d requests, want 3", requests)
+	}
+}
+
+func TestCollectExtents_Unsupported(t *testing.T) {
+	if _, ok := collectExtents(func(m *fiemap) bool { return false }); ok {
+		t.Errorf("collectExtents() = true when the filesystem doesn't support FIEMAP, want false")
+	}
+	if _, ok := collectExtents(func(m *fiemap) bool { return true }); ok {
+		t.Errorf("collectExtents() = true for a file without extents, want false")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// sharesExtents can only be detected on linux, elsewhere copies are never considered to share their storage
+func sharesExtents(a, b string) bool {
+	return false
+}
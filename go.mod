@@ -1,3 +1,10 @@
 module github.com/stojg/deduper
 
-go 1.13
+go 1.21
+
+require (
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
+)
+
+require golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
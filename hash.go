@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	"github.com/stojg/deduper/dedupe"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hash is the hex encoded digest of a file. Its length depends on the algorithm that produced it.
+type Hash = dedupe.Hash
+
+// hashAlgorithms are the choices for -hash
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha1":    sha1.New,
+	"sha256":  sha256.New,
+	"blake2b": newBlake2b,
+}
+
+// hashAlgorithm is used for the full file sums that decide if files are duplicates
+var hashAlgorithm = "sha1"
+
+func newBlake2b() hash.Hash {
+	// New256 only fails for keys longer than 64 bytes
+	h, _ := blake2b.New256(nil)
+	return h
+}
+
+// newHash returns a hasher for the selected -hash algorithm
+func newHash() hash.Hash {
+	return hashAlgorithms[hashAlgorithm]()
+}
+
+// sumOf returns the current digest of a hasher as a Hash
+func sumOf(h hash.Hash) Hash {
+	return Hash(hex.EncodeToString(h.Sum(nil)))
+}
+
+// validateHashAlgorithm makes sure the -hash flag names a known algorithm
+func validateHashAlgorithm(name string) error {
+	if _, ok := hashAlgorithms[name]; ok {
+		return nil
+	}
+	var names []string
+	for known := range hashAlgorithms {
+		names = append(names, known)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown -hash '%s', use one of %s", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/gob"
+	"os"
+	"sync"
+)
+
+// cacheEntry is the sum of a file as it was when it was hashed
+type cacheEntry struct {
+	Size    int64
+	ModTime int64
+	Sum     Hash
+}
+
+// hashCache remembers the sums of earlier runs so unchanged files don't have to be read again. An entry is only used
+// while the size and modification time of its file stay the same. The whole cache is dropped when -hash changes.
+type hashCache struct {
+	Algorithm string
+	Entries   map[string]cacheEntry
+
+	mu     sync.Mutex
+	hits   int
+	misses int
+}
+
+// loadHashCache reads the cache in filePath, a missing file or one made with another algorithm gives an empty cache
+func loadHashCache(filePath, algorithm string) (*hashCache, error) {
+	empty := &hashCache{Algorithm: algorithm, Entries: make(map[string]cacheEntry)}
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return empty, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	c := &hashCache{}
+	if err := gob.NewDecoder(file).Decode(c); err != nil {
+		return nil, err
+	}
+	if c.Algorithm != algorithm || c.Entries == nil {
+		return empty, nil
+	}
+	return c, nil
+}
+
+// sum returns the cached sum of filePath if the file is unchanged, otherwise it hashes the file with hash and
+// remembers the result
+func (c *hashCache) sum(filePath string, hash func(string) (Hash, error)) (Hash, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	entry, ok := c.Entries[filePath]
+	if ok && entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano() {
+		c.hits++
+		c.mu.Unlock()
+		return entry.Sum, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	sum, err := hash(filePath)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.Entries[filePath] = cacheEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Sum: sum}
+	c.mu.Unlock()
+	return sum, nil
+}
+
+// save writes the cache to a temporary file first so a crash never leaves a half written cache behind
+func (c *hashCache) save(filePath string) error {
+	tmp := filePath + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	err = gob.NewEncoder(file).Encode(c)
+	c.mu.Unlock()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashCache_ReusesUnchangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	photo := filepath.Join(dir, "photo.jpg")
+	if err := ioutil.WriteFile(photo, []byte("cached"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cachePath := filepath.Join(dir, "cache.db")
+
+	first, err := loadHashCache(cachePath, "sha1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := first.sum(photo, fileSum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.hits != 0 || first.misses != 1 {
+		t.Errorf("first run had %d hits and %d misses, want 0 and 1", first.hits, first.misses)
+	}
+	if err := first.save(cachePath); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := loadHashCache(cachePath, "sha1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := second.sum(photo, fileSum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want || second.hits != 1 || second.misses != 0 {
+		t.Errorf("second run = %s with %d hits and %d misses, want %s from the cache", got, second.hits, second.misses, want)
+	}
+
+	// touching the file must force it to be hashed again
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(photo, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.sum(photo, fileSum); err != nil {
+		t.Fatal(err)
+	}
+	if second.misses != 1 {
+		t.Errorf("touched file had %d misses, want 1", second.misses)
+	}
+
+	other, err := loadHashCache(cachePath, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(other.Entries) != 0 {
+		t.Errorf("cache made with sha1 was used for sha256")
+	}
+}
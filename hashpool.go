@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// hashResult is the sum of one file, or the error that stopped it from being hashed
+type hashResult struct {
+	path string
+	sum  Hash
+	err  error
+}
+
+// workerCounts is how many files are hashed at the same time, on the devices that have a count of their own and on
+// all the others together
+type workerCounts struct {
+	others  int
+	devices map[uint64]int
+}
+
+// parseWorkers parses a -workers spec, either a plain count like "8" or path=count pairs like "/mnt/ssd=8,/mnt/hdd=2"
+// for the devices those paths are on, like -tiers. A plain count in the pairs is for the files on every other device,
+// which get fallback workers when there is none.
+func parseWorkers(spec string, fallback int) (workerCounts, error) {
+	workers := workerCounts{others: fallback, devices: make(map[uint64]int)}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		idx := strings.LastIndex(entry, "=")
+		count, err := strconv.Atoi(entry[idx+1:])
+		if err != nil || count < 1 {
+			return workerCounts{}, fmt.Errorf("invalid count in -workers '%s', expected a number of at least 1", entry)
+		}
+		if idx < 0 {
+			workers.others = count
+			continue
+		}
+		if idx == 0 {
+			return workerCounts{}, fmt.Errorf("invalid -workers '%s', expected path=count", entry)
+		}
+		dev, ok := deviceID(entry[:idx])
+		if !ok {
+			return workerCounts{}, fmt.Errorf("could not detect the device for '%s'", entry[:idx])
+		}
+		workers.devices[dev] = count
+	}
+	return workers, nil
+}
+
+// hashFiles hashes paths with sum on the given number of goroutines. The files on a device with a count of its own
+// are hashed by that many goroutines of their own, so that a slow disk doesn't get more readers than it can serve
+// while a fast one gets enough. The results are delivered in the order of paths no matter which worker finishes
+// first, so that the grouping is the same as when hashing one file at a time.
+//
+// Once ctx is cancelled no new files are started and the channel is closed after the results that are already done,
+// which are always a prefix of paths. A caller that stops reading early must cancel ctx, or the goroutines are left
+// waiting to deliver the next result.
+func hashFiles(ctx context.Context, paths []string, workers workerCounts, sum func(path string) (Hash, error)) <-chan hashResult {
+	pending := make([]chan hashResult, len(paths))
+	for i := range pending {
+		pending[i] = make(chan hashResult, 1)
+	}
+
+	// the files of every device with its own count, and of all other devices, are queued separately
+	type queueKey struct {
+		own bool
+		dev uint64
+	}
+	queues := make(map[queueKey][]int)
+	counts := map[queueKey]int{{}: workers.others}
+	for i, path := range paths {
+		var queue queueKey
+		if len(workers.devices) > 0 {
+			if dev, ok := deviceID(path); ok && workers.devices[dev] > 0 {
+				queue = queueKey{own: true, dev: dev}
+				counts[queue] = workers.devices[dev]
+			}
+		}
+		queues[queue] = append(queues[queue], i)
+	}
+	for queue, indexes := range queues {
+		jobs := make(chan int)
+		go func(indexes []int) {
+			defer close(jobs)
+			for _, i := range indexes {
+				select {
+				case jobs <- i:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(indexes)
+		count := counts[queue]
+		if count < 1 {
+			count = 1
+		}
+		for w := 0; w < count; w++ {
+			go func() {
+				for i := range jobs {
+					s, err := sum(paths[i])
+					pending[i] <- hashResult{path: paths[i], sum: s, err: err}
+				}
+			}()
+		}
+	}
+
+	results := make(chan hashResult)
+	go func() {
+		defer close(results)
+		for _, result := range pending {
+			select {
+			case r := <-result:
+				select {
+				case results <- r:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return results
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// histogramBounds are the upper bounds of the -histogram size ranges, the last range holds every bigger file
+var histogramBounds = []int64{1 << 20, 10 << 20, 100 << 20}
+
+// histogramLabels name the ranges of histogramBounds in the table
+var histogramLabels = []string{"under 1 MiB", "1-10 MiB", "10-100 MiB", "100 MiB and more"}
+
+// histogramCell is how many files of a size range there are and how big they are together
+type histogramCell struct {
+	Files int
+	Bytes int64
+}
+
+func (c *histogramCell) add(size int64) {
+	c.Files++
+	c.Bytes += size
+}
+
+// histogramRow is the distribution of the files with one extension over the size ranges
+type histogramRow struct {
+	Ext    string // lower case with the dot, empty for files without an extension
+	Ranges []histogramCell
+	Total  histogramCell
+}
+
+// sizeHistogram sorts the files of fileSizes into the size ranges of histogramBounds by their extension. The rows are
+// ordered by the bytes they hold, biggest first, and the last row is the total of all of them.
+func sizeHistogram(fileSizes map[int64][]string) []histogramRow {
+	byExt := make(map[string]*histogramRow)
+	total := &histogramRow{Ext: "total", Ranges: make([]histogramCell, len(histogramBounds)+1)}
+	for size, paths := range fileSizes {
+		i := sort.Search(len(histogramBounds), func(i int) bool { return size < histogramBounds[i] })
+		for _, path := range paths {
+			ext := strings.ToLower(filepath.Ext(path))
+			row, ok := byExt[ext]
+			if !ok {
+				row = &histogramRow{Ext: ext, Ranges: make([]histogramCell, len(histogramBounds)+1)}
+				byExt[ext] = row
+			}
+			for _, r := range []*histogramRow{row, total} {
+				r.Ranges[i].add(size)
+				r.Total.add(size)
+			}
+		}
+	}
+	var rows []histogramRow
+	for _, row := range byExt {
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Total.Bytes != rows[j].Total.Bytes {
+			return rows[i].Total.Bytes > rows[j].Total.Bytes
+		}
+		return rows[i].Ext < rows[j].Ext
+	})
+	return append(rows, *total)
+}
+
+// printHistogram writes the rows of sizeHistogram as a table with the file count and size of every range
+func printHistogram(w io.Writer, rows []histogramRow) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "\nFiles by size and extension\n\n")
+	fmt.Fprintf(tw, "  extension\t%s\ttotal\n", strings.Join(histogramLabels, "\t"))
+	for _, row := range rows {
+		ext := row.Ext
+		if ext == "" {
+			ext = "(none)"
+		}
+		fmt.Fprintf(tw, "  %s", ext)
+		for _, cell := range append(row.Ranges, row.Total) {
+			fmt.Fprintf(tw, "\t%s files, %s", thousands(cell.Files), humanBytes(cell.Bytes))
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
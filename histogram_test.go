@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSizeHistogram(t *testing.T) {
+	rows := sizeHistogram(map[int64][]string{
+		100:       {"a.jpg", "b.JPG", "notes"},
+		2 << 20:   {"c.jpg", "clip.mov"},
+		50 << 20:  {"d.mov"},
+		200 << 20: {"e.mov"},
+		1 << 20:   {"f.jpg"},
+	})
+	if len(rows) != 4 {
+		t.Fatalf("want rows for .mov, .jpg, no extension and the total, got %+v", rows)
+	}
+	want := []histogramRow{
+		{Ext: ".mov", Ranges: []histogramCell{{}, {1, 2 << 20}, {1, 50 << 20}, {1, 200 << 20}}, Total: histogramCell{3, 252 << 20}},
+		{Ext: ".jpg", Ranges: []histogramCell{{2, 200}, {2, 3 << 20}, {}, {}}, Total: histogramCell{4, 3<<20 + 200}},
+		{Ext: "", Ranges: []histogramCell{{1, 100}, {}, {}, {}}, Total: histogramCell{1, 100}},
+		{Ext: "total", Ranges: []histogramCell{{3, 300}, {3, 5 << 20}, {1, 50 << 20}, {1, 200 << 20}}, Total: histogramCell{8, 255<<20 + 300}},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("sizeHistogram() =\n%+v\nwant\n%+v", rows, want)
+	}
+
+	var out bytes.Buffer
+	printHistogram(&out, rows)
+	for _, s := range []string{"under 1 MiB", "100 MiB and more", "(none)", "3 files, 252.0 MiB"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("the histogram doesn't have %q:\n%s", s, out.String())
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFileName is the file in a scanned directory with patterns, like a .gitignore, for what to leave out below it
+const ignoreFileName = ".dedupeignore"
+
+// ignoreRule is one pattern from an ignore file
+type ignoreRule struct {
+	segments []string // the pattern split at its slashes
+	negate   bool     // a ! pattern includes what an earlier pattern excluded
+	dirOnly  bool     // a pattern with a trailing slash only matches directories
+	anchored bool     // a pattern with a slash matches the path below the ignore file, otherwise it matches any name
+}
+
+// parseIgnoreRules reads the patterns of an ignore file. Blank lines and lines starting with # are skipped, a \ in
+// front of a leading # or ! makes it part of the pattern.
+func parseIgnoreRules(lines []string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.segments = strings.Split(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// matches reports if rel, a path relative to the directory of the ignore file, matches the rule
+func (r ignoreRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if !r.anchored {
+		parts = parts[len(parts)-1:]
+	}
+	return matchSegments(r.segments, parts)
+}
+
+// matchSegments matches a path split at its slashes against a pattern split the same way, where a ** segment matches
+// any number of directories
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], parts[0])
+	return ok && matchSegments(pattern[1:], parts[1:])
+}
+
+// ignoreFiles are the rules of the ignore files found so far during a walk, by the directory they are in
+type ignoreFiles map[string][]ignoreRule
+
+// load reads the ignore file in dir if it has one. It has to be called for a directory before the paths inside it
+// are checked, which is the order a walk visits them in.
+func (f ignoreFiles) load(dir string) error {
+	file, err := os.Open(filepath.Join(dir, ignoreFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if rules := parseIgnoreRules(lines); len(rules) > 0 {
+		f[filepath.Clean(dir)] = rules
+	}
+	return nil
+}
+
+// ignored reports if path is left out by the ignore files in the directories from root down to the one path is in.
+// Like with git the last matching pattern decides, and the patterns of a deeper ignore file come after those above it.
+func (f ignoreFiles) ignored(root, path string, isDir bool) bool {
+	if len(f) == 0 {
+		return false
+	}
+	root = filepath.Clean(root)
+	var dirs []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == root || filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rules := f[dirs[i]]
+		if len(rules) == 0 {
+			continue
+		}
+		rel, err := filepath.Rel(dirs[i], path)
+		if err != nil {
+			continue
+		}
+		for _, rule := range rules {
+			if rule.matches(rel, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
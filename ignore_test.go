@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreRuleMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		isDir   bool
+		want    bool
+	}{
+		{"*.tmp", "edit.tmp", false, true},
+		{"*.tmp", "2020/edit.tmp", false, true},
+		{"Previews/", "Lightroom/Previews", true, true},
+		{"Previews/", "Previews", false, false},
+		{"/cache", "cache", true, true},
+		{"/cache", "2020/cache", true, false},
+		{"2020/*.jpg", "2020/beach.jpg", false, true},
+		{"2020/*.jpg", "2020/summer/beach.jpg", false, false},
+		{"2020/**/*.jpg", "2020/summer/beach.jpg", false, true},
+		{"**/raw", "a/b/raw", true, true},
+		{`\#1.jpg`, "#1.jpg", false, true},
+	}
+	for _, tt := range tests {
+		rules := parseIgnoreRules([]string{tt.pattern})
+		if len(rules) != 1 {
+			t.Fatalf("parseIgnoreRules(%q) = %v, want one rule", tt.pattern, rules)
+		}
+		if got := rules[0].matches(filepath.FromSlash(tt.rel), tt.isDir); got != tt.want {
+			t.Errorf("%q matches %s = %t, want %t", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestParseIgnoreRules_SkipsComments(t *testing.T) {
+	rules := parseIgnoreRules([]string{"# previews", "", "  ", "!keep.jpg"})
+	if len(rules) != 1 || !rules[0].negate {
+		t.Errorf("parseIgnoreRules() = %+v, want only the negated keep.jpg", rules)
+	}
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "2020")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for dir, content := range map[string]string{root: "*.jpg\n!keep.jpg\nscratch/\n", sub: "# this one is wanted after all\n!beach.jpg\n"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, ignoreFileName), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ignores := make(ignoreFiles)
+	for _, dir := range []string{root, sub, filepath.Join(root, "missing")} {
+		if err := ignores.load(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"photo.jpg", false, true},
+		{"keep.jpg", false, false},
+		{"notes.png", false, false},
+		{"scratch", true, true},
+		{"2020", true, false},
+		{"2020/other.jpg", false, true},
+		{"2020/keep.jpg", false, false},
+		{"2020/beach.jpg", false, false},
+	}
+	for _, tt := range tests {
+		if got := ignores.ignored(root, filepath.Join(root, filepath.FromSlash(tt.path)), tt.isDir); got != tt.want {
+			t.Errorf("ignored(%s) = %t, want %t", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// chooseOriginals asks on in which file of each group to keep, for -interactive. Every prompt defaults to the file
+// picked by -keep, a number picks another file as the original, o opens the images and videos of the group in their
+// default application to compare them and s leaves the group alone. Once in runs out, like when it isn't a terminal,
+// the remaining groups keep their automatic choice.
+func chooseOriginals(groups []Group, in io.Reader, out io.Writer, display func(string) string) []Group {
+	reader := bufio.NewReader(in)
+	eof := false
+	var result []Group
+	for n, group := range groups {
+		if eof {
+			result = append(result, group)
+			continue
+		}
+		members := append([]string{group.Original}, group.Duplicates...)
+		fmt.Fprintf(out, "\nGroup %d of %d, %s each:\n", n+1, len(groups), humanBytes(group.Size))
+		for i, path := range members {
+			fmt.Fprintf(out, "  %d) %s\n", i+1, display(path))
+		}
+
+		prompt := "Keep which file? [1], or s to skip the group: "
+		if len(viewable(members)) > 0 {
+			prompt = "Keep which file? [1], o to open them, or s to skip the group: "
+		}
+		for {
+			fmt.Fprint(out, prompt)
+			line, err := reader.ReadString('\n')
+			if err != nil && line == "" {
+				fmt.Fprintln(out, "\nNo more input, keeping the automatic choice for the remaining groups")
+				eof = true
+				result = append(result, group)
+				break
+			}
+			answer := strings.TrimSpace(line)
+			if answer == "" {
+				result = append(result, group)
+				break
+			}
+			if strings.EqualFold(answer, "s") {
+				break
+			}
+			if strings.EqualFold(answer, "o") {
+				// files that aren't images or videos are never opened, for those o does nothing
+				for _, path := range viewable(members) {
+					if err := openInViewer(path); err != nil {
+						fmt.Fprintf(out, "Could not open %s: %s\n", display(path), err)
+					}
+				}
+				continue
+			}
+			choice, err := strconv.Atoi(answer)
+			if err != nil || choice < 1 || choice > len(members) {
+				fmt.Fprintf(out, "Pick a number from 1 to %d\n", len(members))
+				continue
+			}
+			if choice != 1 {
+				group.Reason = "picked with -interactive"
+			}
+			group.Original = members[choice-1]
+			group.Duplicates = append(append([]string(nil), members[:choice-1]...), members[choice:]...)
+			result = append(result, group)
+			break
+		}
+	}
+	return result
+}
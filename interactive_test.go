@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChooseOriginals_OpenInViewer(t *testing.T) {
+	var opened []string
+	previous := openInViewer
+	defer func() { openInViewer = previous }()
+	openInViewer = func(path string) error {
+		opened = append(opened, path)
+		if strings.HasSuffix(path, ".mov") {
+			return errors.New("no application for it")
+		}
+		return nil
+	}
+
+	groups := []Group{
+		{Original: "/photos/beach.jpg", Duplicates: []string{"/backup/beach.jpg", "/backup/beach.mov"}, Size: 10},
+		{Original: "/docs/notes.txt", Duplicates: []string{"/backup/notes.txt"}, Size: 10},
+	}
+	var out bytes.Buffer
+	result := chooseOriginals(groups, strings.NewReader("o\n2\no\n\n"), &out, func(path string) string { return path })
+
+	if want := []string{"/photos/beach.jpg", "/backup/beach.jpg", "/backup/beach.mov"}; !reflect.DeepEqual(opened, want) {
+		t.Errorf("o opened %q, want only the images and videos of the first group %q", opened, want)
+	}
+	if !strings.Contains(out.String(), "Could not open /backup/beach.mov: no application for it") {
+		t.Errorf("the file that couldn't be opened wasn't reported: %s", out.String())
+	}
+	if strings.Count(out.String(), "o to open them") != 2 {
+		t.Errorf("o should only be offered, and asked again after, for the group with images: %s", out.String())
+	}
+	if len(result) != 2 || result[0].Original != "/backup/beach.jpg" || result[1].Original != "/docs/notes.txt" {
+		t.Errorf("chooseOriginals() = %+v, want the picked original after opening the files", result)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runContext returns the context that run stops on, a variable so that tests can interrupt a run at a chosen point
+var runContext = interruptContext
+
+// interruptContext returns a context that is cancelled by the first SIGINT or SIGTERM, so the scan can stop at the next
+// file and a move is never cut off halfway. The handler is removed after that signal, a second one kills the program.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cancelWhen is a run context that cancels itself the first time stop returns true when it is asked for Err, like a
+// signal arriving at that point of the run
+type cancelWhen struct {
+	context.Context
+	cancel func()
+	stop   func() bool
+}
+
+func (c *cancelWhen) Err() error {
+	if c.Context.Err() == nil && c.stop() {
+		c.cancel()
+	}
+	return c.Context.Err()
+}
+
+// interruptRunWhen makes the runs of the test stop once stop returns true
+func interruptRunWhen(t *testing.T, stop func() bool) {
+	previous := runContext
+	t.Cleanup(func() { runContext = previous })
+	runContext = func() (context.Context, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		return &cancelWhen{Context: ctx, cancel: cancel, stop: stop}, cancel
+	}
+}
+
+func TestCLI_InterruptedDuringWalk(t *testing.T) {
+	dir := duplicateTree(t)
+	for i := 0; i < 20; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("photo_%d.jpg", i)), []byte("the same photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := treeState(t, dir)
+	// the walk asks for every file it finds, so this stops it a few files in
+	checks := 0
+	interruptRunWhen(t, func() bool {
+		checks++
+		return checks > 3
+	})
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-apply", dir}, &stdout, &stderr); code != exitInterrupted {
+		t.Fatalf("run() = %d, want %d: %s", code, exitInterrupted, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "Interrupted after finding") || !strings.Contains(stderr.String(), "nothing was hashed or moved") {
+		t.Errorf("run() didn't say where it was interrupted: %s", stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("run() printed results after being interrupted during the walk: %s", stdout.String())
+	}
+	if after := treeState(t, dir); !reflect.DeepEqual(after, before) {
+		t.Errorf("the interrupted walk changed the tree: %q", after)
+	}
+}
+
+func TestCLI_InterruptedBetweenGroups(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.Mkdir(filepath.Join(dir, name+"_copies"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{name + ".jpg", filepath.Join(name+"_copies", name+".jpg")} {
+			if err := ioutil.WriteFile(filepath.Join(dir, path), []byte("photo "+name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// the signal arrives while the first group is moved, which is seen before the second one is started
+	rejected := filepath.Join(dir, defaultRejectFolder)
+	interruptRunWhen(t, func() bool { return exists(rejected) })
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-apply", dir}, &stdout, &stderr); code != exitInterrupted {
+		t.Fatalf("run() = %d, want %d: %s", code, exitInterrupted, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "Interrupted after handling 1 of 3 duplicate groups") {
+		t.Errorf("run() didn't summarize the groups that were handled: %s", stderr.String())
+	}
+	entries, err := ioutil.ReadDir(rejected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d files were moved, want only the duplicate of the first group", len(entries))
+	}
+	for _, name := range []string{"b", "c"} {
+		if !exists(filepath.Join(dir, name+"_copies", name+".jpg")) {
+			t.Errorf("the group of %s.jpg was handled after the interrupt", name)
+		}
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// moveOp is a single rename of a duplicate into a reject folder
+type moveOp struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+// movePlan is everything that will happen to one group: its duplicates are moved into the reject folder and then the
+// original may be renamed by -normalize-originals. Notes explain why duplicates were kept.
+//
+// With -link the duplicates are replaced by hardlinks to the original instead, as links from Src to Dst. They aren't
+// part of ops since there is nothing to move back.
+type movePlan struct {
+	moves   []moveOp
+	links   []moveOp
+	deletes []moveOp // Dst is deleted once Src, its original, is confirmed to still exist
+	renames []moveOp
+	notes   []string
+}
+
+// ops returns the renames of the plan in the order they are carried out
+func (p movePlan) ops() []moveOp {
+	return append(append([]moveOp(nil), p.moves...), p.renames...)
+}
+
+// journalRecord is one line in the journal. Before anything is moved every group gets a plan record, which also holds the
+// size and sum of the original so that a resumed run can check it is still the same file. A group then starts with a
+// begin record listing all of its planned moves, gets a move record after each rename and ends with a commit, or a
+// rollback if it was undone by -recover or left alone.
+type journalRecord struct {
+	Op       string   `json:"op"`
+	Run      string   `json:"run"`
+	Group    int      `json:"group"`
+	Original string   `json:"original,omitempty"`
+	Size     int64    `json:"size,omitempty"`
+	Hash     string   `json:"hash,omitempty"`
+	HashAlg  string   `json:"hash_algorithm,omitempty"`
+	Moves    []moveOp `json:"moves,omitempty"`
+	Src      string   `json:"src,omitempty"`
+	Dst      string   `json:"dst,omitempty"`
+}
+
+// journal is an append only log of the move phase that is synced to disk after every record, so that a crash always
+// leaves enough behind to finish or undo the group that was interrupted
+type journal struct {
+	file *os.File
+	run  string
+}
+
+// openJournal opens the journal at path for appending. A torn last line from a crash mid-write is ended first, or the
+// next record would be appended to it and be unreadable too.
+func openJournal(path string) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if last[0] != '\n' {
+			if _, err := file.Write([]byte{'\n'}); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+	}
+	return &journal{file: file, run: strconv.FormatInt(time.Now().UnixNano(), 36)}, nil
+}
+
+func (j *journal) write(r journalRecord) error {
+	if j == nil {
+		return nil
+	}
+	r.Run = j.run
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *journal) plan(group int, original string, size int64, sum Hash, ops []moveOp) error {
+	return j.write(journalRecord{Op: "plan", Group: group, Original: original, Size: size, Hash: string(sum), HashAlg: hashAlgorithm, Moves: ops})
+}
+
+func (j *journal) begin(group int, ops []moveOp) error {
+	return j.write(journalRecord{Op: "begin", Group: group, Moves: ops})
+}
+
+func (j *journal) moved(group int, op moveOp) error {
+	return j.write(journalRecord{Op: "move", Group: group, Src: op.Src, Dst: op.Dst})
+}
+
+func (j *journal) commit(group int) error {
+	return j.write(journalRecord{Op: "commit", Group: group})
+}
+
+// rollback closes a group that was left as it is, so that neither -recover nor -resume-actions touches it later
+func (j *journal) rollback(group int) error {
+	return j.write(journalRecord{Op: "rollback", Group: group})
+}
+
+func (j *journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// readJournal reads every record in the journal. A torn last line from a crash mid-write is ignored.
+func readJournal(path string) ([]journalRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []journalRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var r journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// incompleteGroups returns the begin records of groups that were never committed or rolled back
+func incompleteGroups(records []journalRecord) []journalRecord {
+	type key struct {
+		run   string
+		group int
+	}
+	done := make(map[key]bool)
+	for _, r := range records {
+		if r.Op == "commit" || r.Op == "rollback" {
+			done[key{r.Run, r.Group}] = true
+		}
+	}
+	var result []journalRecord
+	for _, r := range records {
+		if r.Op == "begin" && !done[key{r.Run, r.Group}] {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// recoverJournal finds the groups that were interrupted and either undoes the moves that happened or finishes the
+// ones that didn't, depending on action. A group is only finished if its plan record shows that the original is still
+// the file that was hashed, otherwise it is rolled back in the journal and its files are left where they are.
+func recoverJournal(path, action string, out io.Writer) error {
+	if action != "rollback" && action != "complete" {
+		return fmt.Errorf("unknown -recover-action '%s'", action)
+	}
+	records, err := readJournal(path)
+	if err != nil {
+		return err
+	}
+	pending := incompleteGroups(records)
+	if len(pending) == 0 {
+		fmt.Fprintln(out, "Nothing to recover, every group in the journal is complete")
+		return nil
+	}
+
+	type key struct {
+		run   string
+		group int
+	}
+	plans := make(map[key]journalRecord)
+	for _, r := range records {
+		if r.Op == "plan" {
+			plans[key{r.Run, r.Group}] = r
+		}
+	}
+
+	j, err := openJournal(path)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	for _, begin := range pending {
+		j.run = begin.Run
+		if action == "complete" {
+			// like for -resume-actions, finishing the moves of an original that is gone or changed since the plan was
+			// made could leave no copy behind
+			plan, ok := plans[key{begin.Run, begin.Group}]
+			name := plan.Original
+			err := errors.New("the journal has no plan with its original to check")
+			if ok {
+				err = checkPlannedOriginal(plan)
+			} else if len(begin.Moves) > 0 {
+				name = begin.Moves[0].Src
+			}
+			if err != nil {
+				fmt.Fprintf(out, "Skipping the group of %s, %s\n", name, err)
+				if err := j.rollback(begin.Group); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if action == "rollback" {
+			for i := len(begin.Moves) - 1; i >= 0; i-- {
+				op := begin.Moves[i]
+				if !exists(op.Dst) || exists(op.Src) {
+					continue
+				}
+				guardWrite("move", op.Dst)
+				if err := moveFile(op.Dst, op.Src, os.Rename); err != nil {
+					return err
+				}
+				fmt.Fprintf(out, "Restored %s\n", op.Src)
+			}
+			if err := j.write(journalRecord{Op: "rollback", Group: begin.Group}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, op := range begin.Moves {
+			if !exists(op.Src) || exists(op.Dst) {
+				continue
+			}
+			guardWrite("move", op.Src)
+			if err := moveFile(op.Src, op.Dst, os.Rename); err != nil {
+				return err
+			}
+			if err := j.moved(begin.Group, op); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Moved %s\n", op.Dst)
+		}
+		if err := j.commit(begin.Group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unfinishedPlans returns the plan records of groups that were never committed or rolled back, the groups -resume-actions
+// would carry out
+func unfinishedPlans(records []journalRecord) []journalRecord {
+	type key struct {
+		run   string
+		group int
+	}
+	done := make(map[key]bool)
+	for _, r := range records {
+		if r.Op == "commit" || r.Op == "rollback" {
+			done[key{r.Run, r.Group}] = true
+		}
+	}
+	var result []journalRecord
+	for _, r := range records {
+		if r.Op == "plan" && !done[key{r.Run, r.Group}] {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// unfinishedJournal returns how many groups an earlier run planned in the journal at path but didn't finish, a journal
+// that doesn't exist yet has none
+func unfinishedJournal(path string) (int, error) {
+	records, err := readJournal(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(unfinishedPlans(records)), nil
+}
+
+// confirmResume asks on in if the groups an earlier run left unfinished in the journal should be finished first. Only y
+// or yes is a yes. It reads one byte at a time so that the answers -interactive reads later are left on in.
+func confirmResume(in io.Reader, out io.Writer, path string, groups int) bool {
+	fmt.Fprintf(out, "\n%s has %s groups that an earlier run didn't finish moving. Finish them before scanning? [y/N]: ", path, thousands(groups))
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := in.Read(b)
+		if n == 1 && b[0] != '\n' {
+			line = append(line, b[0])
+			continue
+		}
+		if n == 1 || err != nil {
+			break
+		}
+	}
+	answer := strings.ToLower(strings.TrimSpace(string(line)))
+	return answer == "y" || answer == "yes"
+}
+
+// resumeJournal carries out the planned moves of every group that wasn't committed or rolled back, including groups
+// that never started. Moves that the journal records as done are skipped, as are moves whose source no longer exists.
+func resumeJournal(path string, out io.Writer) error {
+	records, err := readJournal(path)
+	if err != nil {
+		return err
+	}
+	type key struct {
+		run   string
+		group int
+	}
+	begun := make(map[key]bool)
+	completed := make(map[key]map[moveOp]bool)
+	for _, r := range records {
+		k := key{r.Run, r.Group}
+		switch r.Op {
+		case "begin":
+			begun[k] = true
+		case "move":
+			if completed[k] == nil {
+				completed[k] = make(map[moveOp]bool)
+			}
+			completed[k][moveOp{Src: r.Src, Dst: r.Dst}] = true
+		}
+	}
+
+	j, err := openJournal(path)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	plans := unfinishedPlans(records)
+	for _, plan := range plans {
+		k := key{plan.Run, plan.Group}
+		j.run = plan.Run
+		// moving the duplicates of an original that is gone since the plan was made would leave no copy behind
+		if err := checkPlannedOriginal(plan); err != nil {
+			fmt.Fprintf(out, "Skipping the group of %s, %s\n", plan.Original, err)
+			if err := j.rollback(plan.Group); err != nil {
+				return err
+			}
+			continue
+		}
+		if !begun[k] {
+			if err := j.begin(plan.Group, plan.Moves); err != nil {
+				return err
+			}
+		}
+		for _, op := range plan.Moves {
+			if completed[k][op] {
+				continue
+			}
+			if !exists(op.Src) {
+				fmt.Fprintf(out, "Skipping %s, it no longer exists\n", op.Src)
+				continue
+			}
+			if exists(op.Dst) {
+				fmt.Fprintf(out, "Skipping %s, %s already exists\n", op.Src, op.Dst)
+				continue
+			}
+			if dir := filepath.Dir(op.Dst); !exists(dir) {
+				guardWrite("create", dir)
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					return err
+				}
+			}
+			guardWrite("move", op.Src)
+			if err := moveFile(op.Src, op.Dst, os.Rename); err != nil {
+				return err
+			}
+			if err := j.moved(plan.Group, op); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Moved %s\n", op.Dst)
+		}
+		if err := j.commit(plan.Group); err != nil {
+			return err
+		}
+	}
+	if len(plans) == 0 {
+		fmt.Fprintln(out, "Nothing to resume, every planned group in the journal is complete")
+	}
+	return nil
+}
+
+// checkPlannedOriginal makes sure the original of a planned group is still the file that was hashed before its moves are
+// resumed, with checkOriginal and the -hash algorithm of the run that made the plan. Plans from journals that didn't
+// record the sum yet only get their original checked to still be a regular file.
+func checkPlannedOriginal(plan journalRecord) error {
+	if plan.Hash != "" {
+		if plan.HashAlg != "" && plan.HashAlg != hashAlgorithm {
+			if err := validateHashAlgorithm(plan.HashAlg); err != nil {
+				return err
+			}
+			defer func(name string) { hashAlgorithm = name }(hashAlgorithm)
+			hashAlgorithm = plan.HashAlg
+		}
+		return checkOriginal(plan.Original, plan.Size, Hash(plan.Hash))
+	}
+	info, err := os.Stat(plan.Original)
+	if err != nil {
+		return fmt.Errorf("the original is gone: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("the original %s isn't a regular file anymore", plan.Original)
+	}
+	return nil
+}
+
+// undoJournal moves every file that the journal records as moved back to where it came from, newest move first. Moves
+// whose destination no longer exists, or whose source is taken again, are skipped. Every group that had a move undone
+// gets a rollback record so that neither -recover nor -resume-actions touches it again.
+func undoJournal(path string, out io.Writer) error {
+	records, err := readJournal(path)
+	if err != nil {
+		return err
+	}
+
+	j, err := openJournal(path)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	type key struct {
+		run   string
+		group int
+	}
+	undone := make(map[key]bool)
+	var order []key
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Op != "move" {
+			continue
+		}
+		if !exists(r.Dst) {
+			fmt.Fprintf(out, "Skipping %s, it no longer exists\n", r.Dst)
+			continue
+		}
+		if exists(r.Src) {
+			fmt.Fprintf(out, "Skipping %s, %s exists again\n", r.Dst, r.Src)
+			continue
+		}
+		guardWrite("move", r.Dst)
+		if err := moveFile(r.Dst, r.Src, os.Rename); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Restored %s\n", r.Src)
+		k := key{r.Run, r.Group}
+		if !undone[k] {
+			undone[k] = true
+			order = append(order, k)
+		}
+	}
+	for _, k := range order {
+		j.run = k.run
+		if err := j.write(journalRecord{Op: "rollback", Group: k.group}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveResult is the outcome of one planned move, Error is empty if it succeeded
+type moveResult struct {
+	Src   string `json:"src"`
+	Dst   string `json:"dst"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// groupResult is written as one line of JSON to the -results file after the moves of a group were carried out
+type groupResult struct {
+	Group    int          `json:"group"`
+	Original string       `json:"original"`
+	Hash     string       `json:"hash"`
+	Results  []moveResult `json:"results"`
+}
+
+func writeGroupResult(w io.Writer, result groupResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResumeJournal_SkipsCompletedMoves(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"original.jpg", "a.jpg", "b.jpg"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rejected := filepath.Join(dir, defaultRejectFolder)
+	done := moveOp{Src: filepath.Join(dir, "done.jpg"), Dst: filepath.Join(rejected, "done_1.jpg")}
+	first := moveOp{Src: filepath.Join(dir, "a.jpg"), Dst: filepath.Join(rejected, "a_1.jpg")}
+	second := moveOp{Src: filepath.Join(dir, "b.jpg"), Dst: filepath.Join(rejected, "b_1.jpg")}
+
+	// the run was interrupted after the first move of group 1, group 2 never started
+	journalPath := filepath.Join(dir, "journal")
+	j, err := openJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := filepath.Join(dir, "original.jpg")
+	sum, err := fileSum(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.plan(1, original, 12, sum, []moveOp{done, first})
+	// a journal written before the plans had the sum of the original
+	j.plan(2, original, 0, "", []moveOp{second})
+	j.begin(1, []moveOp{done, first})
+	j.moved(1, done)
+	j.Close()
+
+	if err := resumeJournal(journalPath, ioutil.Discard); err != nil {
+		t.Fatalf("resumeJournal() returned error %s", err)
+	}
+	for _, op := range []moveOp{first, second} {
+		if exists(op.Src) || !exists(op.Dst) {
+			t.Errorf("%s was not moved to %s", op.Src, op.Dst)
+		}
+	}
+	records, err := readJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := incompleteGroups(records); len(pending) != 0 {
+		t.Errorf("incompleteGroups() after resuming = %d groups, want 0", len(pending))
+	}
+}
+
+func TestConfirmResume(t *testing.T) {
+	if confirmResume(strings.NewReader(""), ioutil.Discard, "journal", 1) {
+		t.Errorf("confirmResume() without any input = true, want false")
+	}
+	for input, want := range map[string]bool{"y\n": true, "Yes\n": true, "\n": false, "n\n": false} {
+		in := strings.NewReader(input + "1\n")
+		if got := confirmResume(in, ioutil.Discard, "journal", 1); got != want {
+			t.Errorf("confirmResume(%q) = %t, want %t", input, got, want)
+		}
+		// the answers to -interactive that come after it must still be there
+		if rest, _ := ioutil.ReadAll(in); string(rest) != "1\n" {
+			t.Errorf("confirmResume(%q) left %q to read, want the next line", input, rest)
+		}
+	}
+}
+
+func TestResumeJournal_SkipsGroupWithoutOriginal(t *testing.T) {
+	dir := t.TempDir()
+	duplicate := filepath.Join(dir, "copy.jpg")
+	if err := ioutil.WriteFile(duplicate, []byte("the last copy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	op := moveOp{Src: duplicate, Dst: filepath.Join(dir, defaultRejectFolder, "copy_1.jpg")}
+	journalPath := filepath.Join(dir, "journal")
+	j, err := openJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.plan(1, filepath.Join(dir, "deleted_since.jpg"), 13, "", []moveOp{op})
+	j.Close()
+
+	var out strings.Builder
+	if err := resumeJournal(journalPath, &out); err != nil {
+		t.Fatalf("resumeJournal() returned error %s", err)
+	}
+	if !exists(duplicate) || exists(op.Dst) {
+		t.Errorf("the duplicate of an original that is gone was moved: %s", out.String())
+	}
+	if !strings.Contains(out.String(), "the original is gone") {
+		t.Errorf("the skipped group wasn't reported: %s", out.String())
+	}
+	records, err := readJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plans := unfinishedPlans(records); len(plans) != 0 {
+		t.Errorf("unfinishedPlans() after skipping = %d groups, want 0 since it was rolled back", len(plans))
+	}
+}
+
+func TestResumeJournal_SkipsGroupWithChangedOriginal(t *testing.T) {
+	dir := t.TempDir()
+	original := filepath.Join(dir, "original.jpg")
+	duplicate := filepath.Join(dir, "copy.jpg")
+	for _, path := range []string{original, duplicate} {
+		if err := ioutil.WriteFile(path, []byte("the same photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum, err := fileSum(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := moveOp{Src: duplicate, Dst: filepath.Join(dir, defaultRejectFolder, "copy_1.jpg")}
+	journalPath := filepath.Join(dir, "journal")
+	j, err := openJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.plan(1, original, 14, sum, []moveOp{op})
+	j.Close()
+
+	// the original was edited since, it has the same size but isn't a copy of the duplicate anymore
+	if err := ioutil.WriteFile(original, []byte("an edit photo!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := resumeJournal(journalPath, &out); err != nil {
+		t.Fatalf("resumeJournal() returned error %s", err)
+	}
+	if !exists(duplicate) || !strings.Contains(out.String(), "changed since it was hashed") {
+		t.Errorf("the duplicate of an original that changed was moved: %s", out.String())
+	}
+	records, err := readJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plans := unfinishedPlans(records); len(plans) != 0 {
+		t.Errorf("unfinishedPlans() after skipping = %d groups, want 0 since it was rolled back", len(plans))
+	}
+}
+
+func TestRecoverJournal(t *testing.T) {
+	tests := []struct {
+		name     string
+		action   string
+		moved    int  // how many of the two moves happened before the crash
+		torn     bool // the crash happened while writing the first move record
+		gone     bool // the original was deleted since the plan was made
+		restored bool // the files end up where they started, else in the reject folder
+	}{
+		{"rollback a partial group", "rollback", 1, false, false, true},
+		{"complete a partial group", "complete", 1, false, false, false},
+		{"rollback between begin and move", "rollback", 0, true, false, true},
+		{"complete between begin and move", "complete", 0, true, false, false},
+		{"rollback without the original", "rollback", 1, false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			rejected := filepath.Join(dir, defaultRejectFolder)
+			if err := os.Mkdir(rejected, 0755); err != nil {
+				t.Fatal(err)
+			}
+			var ops []moveOp
+			for _, name := range []string{"a.jpg", "b.jpg"} {
+				op := moveOp{Src: filepath.Join(dir, name), Dst: filepath.Join(rejected, name)}
+				if err := ioutil.WriteFile(op.Src, []byte(name), 0644); err != nil {
+					t.Fatal(err)
+				}
+				ops = append(ops, op)
+			}
+			original := filepath.Join(dir, "original.jpg")
+			if err := ioutil.WriteFile(original, []byte("original"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			sum, err := fileSum(original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			journalPath := filepath.Join(dir, "journal")
+			j, err := openJournal(journalPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			j.plan(1, original, 8, sum, ops)
+			j.begin(1, ops)
+			for _, op := range ops[:tt.moved] {
+				if err := os.Rename(op.Src, op.Dst); err != nil {
+					t.Fatal(err)
+				}
+				j.moved(1, op)
+			}
+			if tt.torn {
+				j.file.WriteString(`{"op":"move","run":"` + j.run + `","gro`)
+			}
+			j.Close()
+			if tt.gone {
+				if err := os.Remove(original); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var out strings.Builder
+			if err := recoverJournal(journalPath, tt.action, &out); err != nil {
+				t.Fatalf("recoverJournal() returned error %s", err)
+			}
+			for _, op := range ops {
+				if tt.restored && (!exists(op.Src) || exists(op.Dst)) {
+					t.Errorf("%s wasn't restored from %s: %s", op.Src, op.Dst, out.String())
+				}
+				if !tt.restored && (exists(op.Src) || !exists(op.Dst)) {
+					t.Errorf("%s wasn't moved to %s: %s", op.Src, op.Dst, out.String())
+				}
+			}
+			records, err := readJournal(journalPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pending := incompleteGroups(records); len(pending) != 0 {
+				t.Errorf("incompleteGroups() after recovering = %d groups, want 0", len(pending))
+			}
+
+			// the group is closed, running it again finds nothing to do
+			out.Reset()
+			if err := recoverJournal(journalPath, tt.action, &out); err != nil {
+				t.Fatalf("recoverJournal() again returned error %s", err)
+			}
+			if !strings.Contains(out.String(), "Nothing to recover") {
+				t.Errorf("recoverJournal() again = %q, want nothing to recover", out.String())
+			}
+		})
+	}
+}
+
+func TestRecoverJournal_CompleteSkipsGroupWithoutOriginal(t *testing.T) {
+	dir := t.TempDir()
+	rejected := filepath.Join(dir, defaultRejectFolder)
+	if err := os.Mkdir(rejected, 0755); err != nil {
+		t.Fatal(err)
+	}
+	duplicate := filepath.Join(dir, "b.jpg")
+	if err := ioutil.WriteFile(duplicate, []byte("the last copy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	op := moveOp{Src: duplicate, Dst: filepath.Join(rejected, "b.jpg")}
+	tests := map[string]func(j *journal){
+		"original deleted": func(j *journal) {
+			j.plan(1, filepath.Join(dir, "a.jpg"), 13, "", []moveOp{op})
+			j.begin(1, []moveOp{op})
+		},
+		// a journal from before the plans were written has nothing to check the original with
+		"no plan": func(j *journal) {
+			j.begin(1, []moveOp{op})
+		},
+	}
+	for name, write := range tests {
+		t.Run(name, func(t *testing.T) {
+			journalPath := filepath.Join(t.TempDir(), "journal")
+			j, err := openJournal(journalPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			write(j)
+			j.Close()
+
+			var out strings.Builder
+			if err := recoverJournal(journalPath, "complete", &out); err != nil {
+				t.Fatalf("recoverJournal() returned error %s", err)
+			}
+			if !exists(duplicate) || exists(op.Dst) {
+				t.Fatalf("the only copy was moved into the reject folder: %s", out.String())
+			}
+			if !strings.Contains(out.String(), "Skipping the group") {
+				t.Errorf("the skipped group wasn't reported: %s", out.String())
+			}
+			records, err := readJournal(journalPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pending := incompleteGroups(records); len(pending) != 0 {
+				t.Errorf("incompleteGroups() after skipping = %d groups, want 0 since it was rolled back", len(pending))
+			}
+		})
+	}
+}
+
+func TestRecoverJournal_UnknownAction(t *testing.T) {
+	if err := recoverJournal(filepath.Join(t.TempDir(), "journal"), "redo", ioutil.Discard); err == nil {
+		t.Errorf("recoverJournal() with -recover-action redo = nil error, want it refused")
+	}
+}
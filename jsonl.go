@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// streamedGroup is a line of -format jsonl, the files of a group that the hash sweep confirmed to be identical. The
+// original isn't picked until the sweep is done, the -json-out report has the groups with their original.
+type streamedGroup struct {
+	Hash  string   `json:"hash"`
+	Size  int64    `json:"size"`
+	Files []string `json:"files"`
+}
+
+// groupStream writes each duplicate group as soon as it is final, which is once every candidate with the same size
+// and end blocks was hashed, since no later file can still join one of their groups
+type groupStream struct {
+	enc     *json.Encoder
+	display func(string) string
+	blockOf map[string]blockKey
+	pending map[blockKey]int    // candidates of each end block group that weren't hashed yet
+	keys    map[blockKey][]Hash // the group keys the hashed candidates of each end block group ended up under
+}
+
+func newGroupStream(w io.Writer, endBlocks map[blockKey][]string, candidates []string, display func(string) string) *groupStream {
+	s := &groupStream{
+		enc:     json.NewEncoder(w),
+		display: display,
+		blockOf: make(map[string]blockKey),
+		pending: make(map[blockKey]int),
+		keys:    make(map[blockKey][]Hash),
+	}
+	for key, paths := range endBlocks {
+		for _, path := range paths {
+			s.blockOf[path] = key
+		}
+	}
+	for _, path := range candidates {
+		s.pending[s.blockOf[path]]++
+	}
+	return s
+}
+
+// done records that the candidate path was hashed into groups under key, or couldn't be hashed when key is empty, and
+// writes the groups that are final with it
+func (s *groupStream) done(path string, key Hash, groups map[Hash][]string, sums map[string]Hash) error {
+	block := s.blockOf[path]
+	if key != "" && len(groups[key]) == 1 {
+		s.keys[block] = append(s.keys[block], key)
+	}
+	s.pending[block]--
+	if s.pending[block] > 0 {
+		return nil
+	}
+	for _, key := range s.keys[block] {
+		paths := groups[key]
+		if len(paths) < 2 {
+			continue
+		}
+		files := displayPaths(paths, s.display)
+		sort.Strings(files)
+		if err := s.enc.Encode(streamedGroup{Hash: string(sums[paths[0]]), Size: block.size, Files: files}); err != nil {
+			return err
+		}
+	}
+	delete(s.pending, block)
+	delete(s.keys, block)
+	return nil
+}
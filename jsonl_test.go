@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGroupStream(t *testing.T) {
+	a := blockKey{size: 5, sum: "a"}
+	b := blockKey{size: 7, sum: "b"}
+	endBlocks := map[blockKey][]string{a: {"a1", "a2", "a3"}, b: {"b1", "b2"}}
+	var out bytes.Buffer
+	stream := newGroupStream(&out, endBlocks, []string{"a1", "a2", "a3", "b1", "b2"}, func(p string) string { return p })
+
+	groups := make(map[Hash][]string)
+	sums := make(map[string]Hash)
+	hashed := func(path string, sum Hash) {
+		if sum != "" {
+			groups[sum] = append(groups[sum], path)
+			sums[path] = sum
+		}
+		if err := stream.done(path, sum, groups, sums); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hashed("a1", "sa")
+	hashed("b1", "sb")
+	hashed("a2", "sa")
+	if out.Len() != 0 {
+		t.Fatalf("a group was written before all of its end block candidates were hashed: %s", out.String())
+	}
+	// a3 couldn't be read, which still makes the group of a1 and a2 final
+	hashed("a3", "")
+	if want := `{"hash":"sa","size":5,"files":["a1","a2"]}` + "\n"; out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+	hashed("b2", "sc")
+	if strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("files that differ were written as a group: %s", out.String())
+	}
+}
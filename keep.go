@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/stojg/deduper/dedupe"
+)
+
+// keeper picks the original of a group of identical files, the one that is kept while the others are duplicates
+type keeper struct {
+	keep           string   // the -keep strategy
+	prefer         []string // the original is picked among the files in these directories if there are any
+	references     []string // files in these directories are always the original and never a duplicate
+	rescanRejected bool     // a file outside of the reject folders wins over the rejected ones
+	rejectFolder   string
+	tiers          deviceTiers // the storage ranks for -keep fastest-device
+}
+
+// group returns the identical files in paths as a Group with its original picked and the reason for -explain. A file
+// in a -reference directory wins over everything else and only the files outside of them are duplicates, then with
+// -rescan-rejected a copy outside of the reject folders wins over the rejected ones. Of those that are left the files
+// in a -prefer directory win, and -keep picks among them.
+func (k keeper) group(paths []string, size int64, hash Hash) (Group, error) {
+	pool := paths
+	var outsideRejected, preferred bool
+	refs, rest := splitReference(paths, k.references)
+	if len(refs) > 0 {
+		pool = refs
+	} else if k.rescanRejected {
+		if outside := outsideRejectFolders(paths, k.rejectFolder); len(outside) > 0 {
+			outsideRejected = len(outside) < len(paths)
+			pool = outside
+		}
+	}
+	if matched := preferredPaths(pool, k.prefer); len(matched) > 0 {
+		preferred = len(matched) < len(pool)
+		pool = matched
+	}
+
+	i, reason := dedupe.ShortestIdx(pool), "shortest path"
+	switch k.keep {
+	case "fastest-device":
+		i, reason = fastestDeviceIdx(pool, k.tiers)
+	case "oldest", "newest":
+		var err error
+		if i, reason, err = modTimeIdx(pool, k.keep == "newest"); err != nil {
+			return Group{}, err
+		}
+	case "exif":
+		var err error
+		if i, reason, err = exifIdx(pool); err != nil {
+			return Group{}, err
+		}
+	}
+
+	original := pool[i]
+	var reasons []string
+	if len(refs) > 0 {
+		reasons = append(reasons, "in -reference "+containingDir(original, k.references))
+	}
+	if outsideRejected {
+		reasons = append(reasons, "outside the "+k.rejectFolder+" folders")
+	}
+	if preferred {
+		reasons = append(reasons, "matches -prefer "+containingDir(original, k.prefer))
+	}
+	// -keep only had a say if more than one file was left to pick from
+	if len(pool) > 1 || len(reasons) == 0 {
+		reasons = append(reasons, reason)
+	}
+	duplicates := withoutPath(paths, original)
+	if len(refs) > 0 {
+		duplicates = rest
+	}
+	return Group{Original: original, Duplicates: duplicates, Size: size, Hash: string(hash), Reason: strings.Join(reasons, ", ")}, nil
+}
+
+// containingDir returns the first of dirs that path is inside, for the reasons of -explain
+func containingDir(path string, dirs []string) string {
+	for _, dir := range dirs {
+		if len(preferredPaths([]string{path}, []string{dir})) > 0 {
+			return dir
+		}
+	}
+	return ""
+}
+
+// groupDuplicates turns the paths of each set of identical files into a Group with its original picked by k, ordered by
+// the originals. A set whose original can't be picked, like when one of its files is gone since it was hashed, is
+// passed to skip and left out.
+func groupDuplicates(duplicates [][]string, k keeper, sizes map[string]int64, hashes map[string]Hash, skip func([]string, error)) []Group {
+	var groups []Group
+	for _, paths := range duplicates {
+		group, err := k.group(paths, sizes[paths[0]], hashes[paths[0]])
+		if err != nil {
+			skip(paths, err)
+			continue
+		}
+		groups = append(groups, group)
+	}
+	sort.Sort(ByOriginal(groups))
+	return groups
+}
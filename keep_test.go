@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeeperGroup(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	// the longest name is the oldest file and the shortest one the newest
+	files := map[string]time.Time{
+		"a.jpg":                          base.Add(2 * time.Hour),
+		filepath.Join("backup", "a.jpg"): base.Add(time.Hour),
+		filepath.Join("Originals", "2019", "a.jpg"): base,
+		filepath.Join(defaultRejectFolder, "r.jpg"): base.Add(-time.Hour),
+	}
+	for name, mtime := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("same"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	short := filepath.Join(dir, "a.jpg")
+	backup := filepath.Join(dir, "backup", "a.jpg")
+	old := filepath.Join(dir, "Originals", "2019", "a.jpg")
+	rejected := filepath.Join(dir, defaultRejectFolder, "r.jpg")
+	paths := []string{backup, old, short}
+
+	tests := []struct {
+		name       string
+		keeper     keeper
+		paths      []string
+		original   string
+		duplicates []string
+		reason     string
+	}{
+		{"shortest", keeper{keep: "shortest"}, paths, short, []string{backup, old}, "shortest path"},
+		{"oldest", keeper{keep: "oldest"}, paths, old, []string{backup, short}, "oldest modification time " + at(base)},
+		{"newest", keeper{keep: "newest"}, paths, short, []string{backup, old}, "newest modification time " + at(base.Add(2*time.Hour))},
+		{"exif", keeper{keep: "exif"}, paths, old, []string{backup, short}, "oldest modification time " + at(base) + ", no EXIF date"},
+		{"fastest device", keeper{keep: "fastest-device"}, paths, short, []string{backup, old}, "shortest path, none of the files is on a -tiers device"},
+		// with a single file left to pick from -keep had no say
+		{"prefer", keeper{keep: "shortest", prefer: []string{filepath.Join(dir, "backup")}}, paths, backup, []string{old, short}, "matches -prefer " + filepath.Join(dir, "backup")},
+		{"prefer and oldest", keeper{keep: "oldest", prefer: []string{filepath.Join(dir, "backup"), filepath.Join(dir, "Originals")}}, paths, old, []string{backup, short}, "matches -prefer " + filepath.Join(dir, "Originals") + ", oldest modification time " + at(base)},
+		{"prefer everything", keeper{keep: "shortest", prefer: []string{dir}}, paths, short, []string{backup, old}, "shortest path"},
+		// the other files in a -reference directory aren't duplicates either
+		{"reference", keeper{keep: "shortest", references: []string{filepath.Join(dir, "backup"), filepath.Join(dir, "Originals")}}, paths, backup, []string{short}, "in -reference " + filepath.Join(dir, "backup") + ", shortest path"},
+		{"oldest rejected", keeper{keep: "oldest", rejectFolder: defaultRejectFolder}, []string{rejected, short}, rejected, []string{short}, "oldest modification time " + at(base.Add(-time.Hour))},
+		{"rescan rejected", keeper{keep: "oldest", rescanRejected: true, rejectFolder: defaultRejectFolder}, []string{rejected, short}, short, []string{rejected}, "outside the " + defaultRejectFolder + " folders"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group, err := tt.keeper.group(tt.paths, 4, "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Group{Original: tt.original, Duplicates: tt.duplicates, Size: 4, Hash: "hash", Reason: tt.reason}
+			if !reflect.DeepEqual(group, want) {
+				t.Errorf("group() = %+v, want %+v", group, want)
+			}
+		})
+	}
+}
+
+// at formats t like the reasons of -explain show modification times, in the local time zone
+func at(t time.Time) string {
+	return t.Local().Format(reasonTimeLayout)
+}
+
+func TestGroupDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"a.jpg", "copy_of_a.jpg"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("same"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	gone := []string{filepath.Join(dir, "gone.jpg"), filepath.Join(dir, "gone_too.jpg")}
+	sizes := map[string]int64{paths[0]: 4, paths[1]: 4}
+	hashes := map[string]Hash{paths[0]: "abc", paths[1]: "abc"}
+
+	var skipped [][]string
+	groups := groupDuplicates([][]string{gone, {paths[1], paths[0]}}, keeper{keep: "oldest"}, sizes, hashes, func(paths []string, err error) {
+		skipped = append(skipped, paths)
+	})
+	info, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Group{{Original: paths[0], Duplicates: []string{paths[1]}, Size: 4, Hash: "abc", Reason: "oldest modification time " + at(info.ModTime())}}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("groupDuplicates() = %+v, want %+v", groups, want)
+	}
+	if !reflect.DeepEqual(skipped, [][]string{gone}) {
+		t.Errorf("groupDuplicates() skipped %q, want the group whose files are gone", skipped)
+	}
+}
+
+func TestGroupDuplicates_OrderedByOriginal(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	path := func(name string, mtime time.Time) string {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	// the shortest path of the first set is a.jpg, but the oldest file and so the original is z/old.jpg
+	first := []string{path("a.jpg", base.Add(time.Hour)), path("z/old.jpg", base)}
+	second := []string{path("b.jpg", base), path("y/b_copy.jpg", base.Add(time.Hour))}
+
+	groups := groupDuplicates([][]string{first, second}, keeper{keep: "oldest"}, nil, nil, func([]string, error) {
+		t.Fatal("no group should be skipped")
+	})
+	var got []string
+	for _, group := range groups {
+		got = append(got, group.Original)
+	}
+	if want := []string{second[0], first[1]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("groupDuplicates() ordered the originals as %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// linkSuffix is added to the temporary name a hardlink is created under before it replaces the duplicate
+const linkSuffix = ".deduper-link"
+
+// linkDuplicate replaces duplicate with a hardlink to original. The link is made under a temporary name next to the
+// duplicate first and then renamed over it, so the duplicate is only gone once the link exists and a failed link
+// leaves it untouched.
+func linkDuplicate(original, duplicate string, link func(oldname, newname string) error) error {
+	tmp := duplicate + linkSuffix
+	if err := link(original, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, duplicate); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// isCrossDevice reports if err is a link or rename that failed because both paths aren't on the same filesystem
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestLinkDuplicate_CrossDeviceKeepsDuplicate(t *testing.T) {
+	dir := t.TempDir()
+	original, duplicate := filepath.Join(dir, "a.jpg"), filepath.Join(dir, "b.jpg")
+	for _, path := range []string{original, duplicate} {
+		if err := ioutil.WriteFile(path, []byte("same"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	crossDevice := func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+
+	err := linkDuplicate(original, duplicate, crossDevice)
+	if !isCrossDevice(err) {
+		t.Fatalf("linkDuplicate() returned %v, want a cross-device error", err)
+	}
+	if content, err := ioutil.ReadFile(duplicate); err != nil || string(content) != "same" {
+		t.Errorf("the duplicate was changed by a failed link: %q, %v", content, err)
+	}
+	if exists(duplicate + linkSuffix) {
+		t.Errorf("the temporary link name was left behind")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isLivePhotoVideo reports if path is the video half of a live photo pair
+func isLivePhotoVideo(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".mov"
+}
+
+// livePhotoPair returns the other half of an iPhone live photo, the .mov next to a .heic with the same base name or
+// the other way around
+func livePhotoPair(path string) (string, bool) {
+	var exts []string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".heic":
+		exts = []string{".mov", ".MOV"}
+	case ".mov":
+		exts = []string{".heic", ".HEIC"}
+	default:
+		return "", false
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range exts {
+		pair := base + ext
+		if info, err := os.Stat(pair); err == nil && info.Mode().IsRegular() {
+			return pair, true
+		}
+	}
+	return "", false
+}
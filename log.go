@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+)
+
+// verbosity is the -v flag. -v can be repeated, each one logs more, or given a level like -v=2.
+type verbosity int
+
+func (v *verbosity) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+func (v *verbosity) Set(value string) error {
+	switch value {
+	case "true":
+		*v++
+		return nil
+	case "false":
+		*v = 0
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("verbosity must be a level like 1 or 2, not '%s'", value)
+	}
+	*v = verbosity(n)
+	return nil
+}
+
+// IsBoolFlag lets -v be given without a value
+func (v *verbosity) IsBoolFlag() bool {
+	return true
+}
+
+// newLogger returns the logger for the decisions of the scan. Level 1 logs the size and hash groups, level 2 also why
+// every single file was skipped or ruled out. Level 0 logs nothing, so the output stays as it is without -v.
+func newLogger(w io.Writer, v verbosity) *slog.Logger {
+	level := slog.LevelError + 1
+	switch {
+	case v == 1:
+		level = slog.LevelInfo
+	case v >= 2:
+		level = slog.LevelDebug
+	}
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level}))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestVerbosityFlag(t *testing.T) {
+	for args, want := range map[string]verbosity{"": 0, "-v": 1, "-v -v": 2, "-v=2": 2, "-v -v=0": 0} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		var v verbosity
+		fs.Var(&v, "v", "")
+		if err := fs.Parse(strings.Fields(args)); err != nil {
+			t.Fatalf("parsing %q: %v", args, err)
+		}
+		if v != want {
+			t.Errorf("%q gave verbosity %d, want %d", args, v, want)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	var v verbosity
+	fs.Var(&v, "v", "")
+	if err := fs.Parse([]string{"-v=loud"}); err == nil {
+		t.Error("-v=loud was accepted")
+	}
+}
+
+func TestNewLogger_Levels(t *testing.T) {
+	for v, want := range map[verbosity][]string{0: nil, 1: {"msg=group"}, 2: {"msg=group", "msg=skipped"}} {
+		var out bytes.Buffer
+		logger := newLogger(&out, v)
+		logger.Info("group")
+		logger.Debug("skipped")
+		if lines := strings.Count(out.String(), "\n"); lines != len(want) {
+			t.Errorf("verbosity %d logged %d lines, want %d: %s", v, lines, len(want), out.String())
+		}
+		for _, msg := range want {
+			if !strings.Contains(out.String(), msg) {
+				t.Errorf("verbosity %d didn't log %s: %s", v, msg, out.String())
+			}
+		}
+	}
+}
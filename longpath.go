@@ -0,0 +1,20 @@
+package main
+
+import "strings"
+
+// maxShortPath is the longest path, in bytes, that Windows handles without the \\?\ prefix. MAX_PATH is 260 but a
+// directory has to leave room for an 8.3 file name inside it, which is why the limit for a directory is 248.
+const maxShortPath = 247
+
+// windowsLongPath prefixes the absolute Windows path abs with \\?\ once it is too long for the normal path functions,
+// a UNC path \\server\share becomes \\?\UNC\server\share. The result is for the Windows API only, it can't be
+// relative and has no . or .. parts, which filepath.Abs already took care of.
+func windowsLongPath(abs string) string {
+	if len(abs) <= maxShortPath || strings.HasPrefix(abs, `\\?\`) {
+		return abs
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
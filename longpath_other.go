@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// longPath returns path as it is, only Windows limits the length of a path
+func longPath(path string) string {
+	return path
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWindowsLongPath(t *testing.T) {
+	deep := strings.Repeat(`\2019`, 60)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{`C:\photos\photo.jpg`, `C:\photos\photo.jpg`},
+		{`C:\photos` + deep, `\\?\C:\photos` + deep},
+		{`\\nas\photos` + deep, `\\?\UNC\nas\photos` + deep},
+		{`\\?\C:\photos` + deep, `\\?\C:\photos` + deep},
+	}
+	for _, tt := range tests {
+		if got := windowsLongPath(tt.path); got != tt.want {
+			t.Errorf("windowsLongPath(%.30s...) = %.30s..., want %.30s...", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "path/filepath"
+
+// longPath makes path usable when it is longer than MAX_PATH, which deep photo libraries easily are once a duplicate
+// is moved into a reject folder below its original
+func longPath(path string) string {
+	if len(path) <= maxShortPath {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return windowsLongPath(abs)
+}
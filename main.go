@@ -1,6 +1,6 @@
 /**
 This is a pretty simple photo/large file deduplication program. It compares files by first filesize and does a secondary sweep
-by comparing the SHA1 sum of the files. By default it will not do any actions unless the -dryrun flag is set to true.
+by comparing the SHA1 sum of the files. By default it will not do any actions unless the -apply flag is given.
 At that point it will move the duplicates into a _Rejected subfolder next to the original file (same pattern as for the
 https://www.fastrawviewer.com/ program. That folder can be cleaned either manually or by using find.
 
@@ -13,20 +13,44 @@ yyyy-mm-dd format with `exiftool` it doesnt that much which original I keep.
 package main
 
 import (
+	"bufio"
+	"context"
 	"crypto/sha1"
+	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/stojg/deduper/dedupe"
 )
 
-// Where duplicates will be moved
-const rejectFolder = "_Rejected"
+// Where duplicates will be moved, unless -reject names another folder
+const defaultRejectFolder = dedupe.DefaultRejectFolder
+
+// How duplicates are named inside the reject folder
+const defaultRejectTemplate = "{name}_{n}{ext}"
+
+// How many bytes from the start and the end of each file that are compared before doing a full hash
+const defaultEndBlockSize = 4096
+
+// How many bytes from the start of same sized files are compared before hashing them fully
+const defaultHeadBlockSize = 16 << 10
 
-// These are the only file suffixes that this program will check
+// version is reported in the machine readable outputs, release builds set it with -ldflags "-X main.version=..."
+var version = "dev"
+
+// These are the file suffixes that this program will check unless -ext changes them
 var validExt = []string{
 	".jpg",
 	".jpeg",
@@ -38,233 +62,2393 @@ var validExt = []string{
 	".tiff",
 	".heic",
 	".dng",
-	".raf",
-	".tiff",
-	".png",
-	".mp4",
 	".mkv",
 	".tgz",
 	".zip",
 	".rar",
 }
 
-type Hash [20]byte
+// How many duplicate groups the text output lists before it only prints how many more there are
+const defaultPreviewGroups = 50
+
+// Files with these names are structural placeholders and will never be moved
+const defaultProtectNames = ".gitkeep,.keep"
+
+// How many times a file is read again when fewer bytes than its size were hashed
+const shortReadRetries = 3
+
+// Exit code used when duplicates were found but not moved, so that a script can tell there is something to clean up
+const exitDuplicatesFound = 2
+
+// Exit code used when the scan stopped early because an -abort-after limit was reached
+const exitAborted = 3
+
+// Exit code used when the run completed but some files were skipped because of errors
+const exitSkippedFiles = 4
+
+// Exit code used when the run was stopped by SIGINT or SIGTERM
+const exitInterrupted = 130
+
+// How many bytes are read at a time when a file is hashed, -bufsize changes it
+const defaultBufferSize = 1 << 20
+
+var bufferSize = defaultBufferSize
+
+// When set, guardWrite stops the program before anything is written to the scanned tree
+var readOnly bool
+
+// blockKey identifies files that share the same size and end blocks
+type blockKey struct {
+	size int64
+	sum  Hash
+	dir  string // only set with -same-dir
+}
 
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run is the whole program with its flags in args, it writes to stdout and stderr and returns the exit code
+func run(args []string, stdout, stderr io.Writer) (code int) {
+	// the -output file is only put in place after a run that got to the end, whatever it found
+	var output *outputFile
+	defer func() {
+		if r := recover(); r != nil {
+			output.finish(false)
+			panic(r)
+		}
+		if err := output.finish(code == 0 || code == exitDuplicatesFound || code == exitSkippedFiles); err != nil {
+			fmt.Fprintf(stderr, "Error: '%s'\n", err)
+			code = 1
+		}
+	}()
+	defer recoverExit(stderr, &code)
+	// the flags below set package settings, put them back so one run doesn't leak into the next
+	defer func(hash string, size int, ro, atime bool, mmap int64) {
+		hashAlgorithm, bufferSize, readOnly, preserveAtime, mmapThreshold = hash, size, ro, atime, mmap
+	}(hashAlgorithm, bufferSize, readOnly, preserveAtime, mmapThreshold)
+
+	fs := flag.NewFlagSet("deduper", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var apply bool
 	var dryRun = true
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: %s path\n\n", os.Args[0])
-		flag.PrintDefaults()
+	var protectNames string
+	var jsonOut, csvOut string
+	var checkpointDir string
+	var gitRelative bool
+	var keep, tierSpec string
+	var reportArchives bool
+	var plainProgress bool
+	var progressMode string
+	var bloomPath string
+	var format string
+	var abortGroups int
+	var abortBytes int64
+	var endBlockSize, headBlockSize int64
+	var progressSocket string
+	var livePhotos bool
+	var thumbDir string
+	var rejectTemplate string
+	var dirDedup bool
+	var metricsURL string
+	var byMime bool
+	var multiHashOut string
+	var webhookURL string
+	var webhookRequired bool
+	var journalPath, recoverPath, recoverAction, resumePath string
+	var resultsPath string
+	var charOK, charDupe, charErr string
+	var includeSnapshots bool
+	var normalizeTemplate string
+	var top int
+	var sortOrder string
+	var hashIncludeSize bool
+	var sameDir bool
+	var dedupeByName bool
+	var list string
+	var useMmap bool
+	var recheckOriginal bool
+	var histogram bool
+	var rawJPEG bool
+	var rawJPEGKeep string
+	var outputPath string
+	var allGroups bool
+	var reportCaseVariants bool
+	var reportNearMiss bool
+	var maxMemory int64
+	var workerSpec string
+	var verify bool
+	var rejectFolder string
+	var extSpec string
+	var allExt bool
+	var interactive bool
+	var explain bool
+	var pruneEmpty bool
+	var deleteDups, yes bool
+	var minSizeSpec string
+	var undoPath string
+	var link bool
+	var quiet bool
+	var configPath string
+	var similar bool
+	var similarThreshold int
+	var timeWindow time.Duration
+	var bursts bool
+	var burstWindow time.Duration
+	var fromStdin bool
+	var preserveStructure bool
+	var useTrash bool
+	var bufSizeSpec string
+	var verifyRejected bool
+	var excludes stringList
+	var prefer stringList
+	var references stringList
+	var maxDepth int
+	var followSymlinks bool
+	var includeHidden bool
+	var walkThreads int
+	var diffPath string
+	var maxGroup int
+	var minCopies int
+	var rescanRejected bool
+	var includeEmpty bool
+	var profile profileFlag
+	var maxBytesSpec string
+	var verbose verbosity
+	var cachePath string
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s path [path ...]\n\n", os.Args[0])
+		fs.PrintDefaults()
+		fmt.Fprintf(stderr, "\nexit codes:\n")
+		fmt.Fprintf(stderr, "  0    no duplicates were found, or they were all moved with -apply\n")
+		fmt.Fprintf(stderr, "  1    a fatal error or invalid flags\n")
+		fmt.Fprintf(stderr, "  %d    duplicates were found and left in place since -apply wasn't given\n", exitDuplicatesFound)
+		fmt.Fprintf(stderr, "  %d    the scan stopped early because of an -abort-after limit\n", exitAborted)
+		fmt.Fprintf(stderr, "  %d    the run completed but some files were skipped because of errors\n", exitSkippedFiles)
+		fmt.Fprintf(stderr, "  %d  the run was interrupted by SIGINT or SIGTERM\n", exitInterrupted)
+	}
+	fs.BoolVar(&apply, "apply", false, "Move the duplicates, without it nothing is changed")
+	fs.BoolVar(&dryRun, "dryrun", true, "Deprecated, use -apply instead of -dryrun=false")
+	fs.StringVar(&protectNames, "protect-names", defaultProtectNames, "Comma separated list of file names or patterns that will never be moved")
+	fs.StringVar(&jsonOut, "json-out", "", "Write the duplicate groups as JSON to this file")
+	fs.StringVar(&csvOut, "csv-out", "", "Write the duplicate groups as CSV to this file")
+	fs.StringVar(&checkpointDir, "checkpoint-dir", "", "Save the hash state of very large files into this directory so an interrupted run can resume mid-file")
+	fs.BoolVar(&gitRelative, "git-relative", false, "Print paths relative to the root of the git repository that contains the first scanned path")
+	fs.StringVar(&keep, "keep", "shortest", "Which file to keep as the original: shortest, oldest, newest, exif for the earliest EXIF capture date, or fastest-device")
+	fs.StringVar(&tierSpec, "tiers", "", "Storage tiers for -keep fastest-device as path=rank pairs, lower is faster, e.g. /mnt/ssd=1,/mnt/hdd=2")
+	fs.BoolVar(&reportArchives, "archives", false, "Report zip and tgz archives whose contents already exist as loose files")
+	fs.StringVar(&configPath, "config", "", "Read defaults for the other flags from this YAML file, flags on the command line win over it. "+defaultConfigFile+" in the current directory is read when this isn't given")
+	fs.BoolVar(&plainProgress, "plain-progress", false, "Print a . for every processed file instead of marking duplicates and errors")
+	fs.StringVar(&progressMode, "progress", "dots", "How progress is shown: dots, or bar for a single line with the time left when stderr is a terminal")
+	fs.StringVar(&bloomPath, "bloom", "", "Remember files confirmed unique in this file and skip re-hashing them on later runs")
+	fs.StringVar(&list, "list", "", "Only print the paths of one kind of file from every group, one per line: originals for the kept files or duplicates for the ones that are moved")
+	fs.StringVar(&outputPath, "output", "", "Write the results to this file instead of stdout, it is only replaced once the run finished")
+	fs.StringVar(&format, "format", "text", "Output format for the duplicates: text, edges (one original<TAB>duplicate pair per line), json, jsonl (one JSON object per group written as soon as the hash sweep confirms it) or csv (one group_id,role,path,size_bytes,hash row per file)")
+	fs.IntVar(&abortGroups, "abort-after-groups", 0, "Stop the scan and exit with code 3 once more than this many duplicate groups are found, 0 disables")
+	fs.Int64Var(&abortBytes, "abort-after-bytes", 0, "Stop the scan and exit with code 3 once more than this many bytes are reclaimable, 0 disables")
+	fs.Int64Var(&endBlockSize, "endbytes", defaultEndBlockSize, "How many bytes from the end of same sized files to compare before hashing them fully")
+	fs.Int64Var(&headBlockSize, "headbytes", defaultHeadBlockSize, "How many bytes from the start of same sized files to compare before hashing them fully")
+	fs.StringVar(&progressSocket, "progress-socket", "", "Write progress as newline delimited JSON to this named pipe or unix socket")
+	fs.BoolVar(&livePhotos, "livephotos", false, "Treat a .heic and .mov with the same name as one live photo that is never separated")
+	fs.StringVar(&thumbDir, "thumb-dir", "", "Write a JPEG thumbnail of every image in each duplicate group into this directory")
+	fs.StringVar(&rejectTemplate, "reject-template", defaultRejectTemplate, "Name of moved duplicates, using the placeholders {name} or {base}, {n}, {ext}, {hash}, {dir}, {parent} and {date}")
+	fs.StringVar(&rejectTemplate, "rename-template", defaultRejectTemplate, "Same as -reject-template")
+	fs.BoolVar(&dirDedup, "dir-dedup", false, "Report directories where every file also exists in another directory")
+	fs.StringVar(&metricsURL, "metrics-url", "", "Push metrics about the run to this Prometheus pushgateway url when done")
+	fs.BoolVar(&readOnly, "read-only", false, "Refuse to write anything to the scanned tree, even if -apply is also given")
+	fs.BoolVar(&byMime, "by-mime", false, "Pick files by sniffing their content type instead of by extension")
+	fs.StringVar(&multiHashOut, "multi-hash", "", "Write a JSON manifest with the size, SHA1 and SHA256 of every scanned file to this file")
+	fs.StringVar(&webhookURL, "webhook", "", "POST the duplicate groups and a summary as JSON to this url when done")
+	fs.BoolVar(&webhookRequired, "webhook-required", false, "Fail the run if the -webhook can't be delivered")
+	fs.BoolVar(&useMmap, "mmap", false, fmt.Sprintf("Hash files of %s and more by mapping them into memory instead of reading them, which saves system calls on some filesystems", humanBytes(defaultMmapThreshold)))
+	fs.BoolVar(&preserveAtime, "preserve-atime", false, "Read files without changing their access time")
+	fs.StringVar(&journalPath, "journal", "", "Append every planned and completed move to this journal so an interrupted run can be recovered")
+	fs.StringVar(&recoverPath, "recover", "", "Recover the interrupted group in this journal instead of scanning")
+	fs.StringVar(&recoverAction, "recover-action", "rollback", "How -recover handles an interrupted group: rollback or complete")
+	fs.StringVar(&resumePath, "resume-actions", "", "Carry out the moves planned in this journal that an interrupted run didn't finish, instead of scanning")
+	fs.StringVar(&resultsPath, "results", "", "Write the outcome of every move as one line of JSON per group to this file")
+	fs.StringVar(&charOK, "char-ok", string(defaultProgressChars.OK), "Progress character printed for a processed file")
+	fs.StringVar(&charDupe, "char-dupe", string(defaultProgressChars.Dupe), "Progress character printed for a duplicate")
+	fs.StringVar(&charErr, "char-err", string(defaultProgressChars.Err), "Progress character printed for an error")
+	fs.BoolVar(&includeSnapshots, "include-snapshots", false, "Also scan filesystem snapshot directories like .snapshot, .zfs and @GMT-*")
+	fs.StringVar(&normalizeTemplate, "normalize-originals", "", "Rename originals that had duplicates, using the placeholders {date}, {seq}, {name} and {ext}")
+	fs.StringVar(&sortOrder, "sort", "path", "Order of the duplicate groups: path by the kept file, or waste for the groups that free the most space first with their size")
+	fs.IntVar(&top, "top", 0, "Only handle the N duplicate groups that waste the most space, 0 handles all of them")
+	fs.BoolVar(&sameDir, "same-dir", false, "Only group duplicates that are in the same directory, identical files in different directories are left alone")
+	fs.BoolVar(&dedupeByName, "dedupe-by-name", false, "Only compare same sized files whose names match once copy suffixes like \" (1)\" and \"_1\" are stripped, faster but misses renamed copies")
+	fs.BoolVar(&hashIncludeSize, "hash-include-size", false, "Also use the file size to group files, redundant for full SHA1 sums but harmless")
+	fs.BoolVar(&allGroups, "all-groups", false, fmt.Sprintf("List every duplicate group instead of only the first %d", defaultPreviewGroups))
+	fs.BoolVar(&reportCaseVariants, "report-case-variants", false, "Report identical files whose names only differ in case")
+	fs.BoolVar(&reportNearMiss, "report-nearmiss", false, "Report the groups of files that have the same size but turned out to differ")
+	fs.StringVar(&hashAlgorithm, "hash", "sha1", "Algorithm for the full file sums: sha1, sha256 or blake2b")
+	fs.Var(&verbose, "v", "Log why files were skipped or grouped to stderr, -v logs the size and hash groups and -v -v or -v=2 every file, -quiet leaves only the log")
+	fs.StringVar(&maxBytesSpec, "max-bytes", "", "Stop hashing once this many bytes, like 500G, were read and list the files that weren't checked yet")
+	fs.Var(&profile, "profile", "Print how long each phase took and how fast it went, -profile=path also writes a CPU profile for go tool pprof to path")
+	fs.BoolVar(&includeEmpty, "include-empty", false, "Also compare empty files, which are all identical to each other and otherwise only counted")
+	fs.BoolVar(&rescanRejected, "rescan-rejected", false, "Also scan the files inside reject folders, to find the ones that have no copy left outside of them. They are never moved again")
+	fs.IntVar(&minCopies, "min-copies", 2, "Only report and handle the duplicate groups with at least this many identical files, the original included")
+	fs.IntVar(&maxGroup, "max-group", 0, "Skip the sizes that more than this many files share instead of comparing them, 0 compares every size")
+	fs.Int64Var(&maxMemory, "max-memory", 0, "Keep the list of scanned files on disk once it uses more than about this many bytes, 0 disables")
+	fs.StringVar(&workerSpec, "workers", strconv.Itoa(runtime.NumCPU()), "How many files are hashed at the same time, or path=count pairs for the devices those paths are on with a plain count for all other devices, e.g. /mnt/ssd=8,/mnt/hdd=2,4")
+	fs.BoolVar(&recheckOriginal, "recheck-original", false, "Hash the original of each group again right before its duplicates are handled and leave the group alone if it changed, its size is always checked")
+	fs.BoolVar(&verify, "verify", false, "Compare every duplicate byte for byte with its original before treating them as identical")
+	fs.StringVar(&rejectFolder, "reject", defaultRejectFolder, "Name of the folder next to each original that its duplicates are moved into")
+	fs.BoolVar(&interactive, "interactive", false, "Ask on stdin which file of each group to keep, defaulting to the one -keep picks")
+	fs.BoolVar(&explain, "explain", false, "Show next to the original of each group why it was kept, like the -keep strategy or a -prefer directory")
+	fs.BoolVar(&allExt, "all-ext", false, "Scan every regular file no matter its extension, instead of only the -ext list")
+	fs.StringVar(&extSpec, "ext", "", "Comma separated file extensions to scan instead of the defaults, start with + to add them to the defaults")
+	fs.StringVar(&undoPath, "undo", "", "Move every file recorded in this journal back to where it came from, instead of scanning")
+	fs.IntVar(&walkThreads, "threads-walk", 1, "Read this many directories at the same time during the scan, more helps on network mounts")
+	fs.StringVar(&diffPath, "diff", "", "Compare the duplicate groups with a -json-out report of an earlier run and list the groups that are new or resolved since")
+	fs.BoolVar(&includeHidden, "include-hidden", false, "Also scan files and directories whose name starts with a dot, like .thumbnails")
+	fs.BoolVar(&followSymlinks, "follow-symlinks", false, "Walk symlinked directories and compare symlinked files at their target, every file and directory is only scanned once")
+	fs.IntVar(&maxDepth, "maxdepth", -1, "Don't descend more than this many directories below each root, 0 only scans the files in the roots and -1 has no limit")
+	fs.Var(&prefer, "prefer", "Keep the file inside this directory as the original when a group has one there, -keep picks between several, can be given more than once")
+	fs.Var(&references, "reference", "Also scan this directory as a read-only reference, its files always win as the original and are never moved or changed, can be given more than once")
+	fs.Var(&excludes, "exclude", "Skip files and directories whose name or path below the root matches this glob, can be given more than once. A "+ignoreFileName+" file in a scanned directory leaves out what its gitignore style patterns match below it")
+	fs.BoolVar(&deleteDups, "delete", false, "Delete the duplicates with -apply instead of moving them, asks for confirmation unless -yes is given")
+	fs.BoolVar(&yes, "yes", false, "Don't ask before -delete removes the duplicates, before -bursts -apply moves frames, or before -journal finishes the moves an interrupted run left in it")
+	fs.BoolVar(&pruneEmpty, "prune-empty", false, "Remove the directories that moving or deleting the duplicates left empty, up to but never including the scanned roots. Without -apply they are only listed")
+	fs.BoolVar(&link, "link", false, "Replace the duplicates with hardlinks to their original instead of moving them")
+	fs.StringVar(&minSizeSpec, "minsize", "", "Skip files smaller than this size, like 500k or 1M, and every empty file")
+	fs.BoolVar(&quiet, "quiet", false, "Don't print the progress or the banner of each phase, only the results and the summary")
+	fs.BoolVar(&histogram, "histogram", false, "Only print how many files of each extension fall into which size range after the walk, nothing is hashed or moved")
+	fs.BoolVar(&rawJPEG, "raw-jpeg", false, "Pair the JPEG a camera saved next to a RAW file of the same name instead of finding identical files, -apply moves the one -raw-jpeg-keep doesn't keep")
+	fs.StringVar(&rawJPEGKeep, "raw-jpeg-keep", "raw", "Which file of a -raw-jpeg pair to keep: raw or jpeg")
+	fs.BoolVar(&similar, "similar", false, "Report groups of visually similar jpg, png and gif images instead of identical files, nothing is moved")
+	fs.IntVar(&similarThreshold, "similar-threshold", defaultSimilarThreshold, "How many of the 64 bits of the perceptual hash two images may differ in for -similar")
+	fs.DurationVar(&timeWindow, "time-window", 0, "Only group -similar images taken at most this long apart by their EXIF date, or else modification time, so that the same view on another day isn't a match")
+	fs.BoolVar(&bursts, "bursts", false, "Report burst sequences, similar jpg, png and gif images in one directory taken within -burst-window of each other, with the sharpest frame first. -apply moves the other frames after asking")
+	fs.DurationVar(&burstWindow, "burst-window", defaultBurstWindow, "How long after the frame before it a frame can be taken to belong to the same -bursts sequence")
+	fs.BoolVar(&fromStdin, "stdin", false, "Read the files to compare from stdin, one path per line, instead of walking directories, a - root does the same")
+	fs.BoolVar(&useTrash, "trash", false, "Move the duplicates to the trash of the desktop instead of reject folders, where it isn't supported the reject folders are used")
+	fs.BoolVar(&preserveStructure, "preserve-structure", false, "Keep the path of each duplicate below its scanned root inside the reject folder, like _Rejected/a/b/photo.jpg")
+	fs.StringVar(&bufSizeSpec, "bufsize", "1M", "How much of a file is read at a time while hashing it, like 256k or 4M")
+	fs.BoolVar(&verifyRejected, "verify-rejected", false, "Check that every file in the reject folders still has an identical copy outside of them, instead of scanning for duplicates")
+	fs.StringVar(&cachePath, "cache", "", "Remember file sums in this file and reuse them for files whose size and modification time didn't change")
+	// invalid flags exit with 1 instead of the usual 2, which means that duplicates were found
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return 1
 	}
-	flag.BoolVar(&dryRun, "dryrun", true, "Will not move duplicate files if set to true (default)")
-	flag.Parse()
-	path := flag.Arg(0)
+	roots := fs.Args()
 
-	if path == "" {
-		flag.Usage()
-		os.Exit(1)
+	if configPath == "" && exists(defaultConfigFile) {
+		configPath = defaultConfigFile
+	}
+	if configPath != "" {
+		handleError(applyConfig(fs, configPath))
 	}
 
-	fmt.Printf("Scanning directory and comparing file sizes\n")
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "dryrun" {
+			return
+		}
+		fmt.Fprintln(stderr, "-dryrun is deprecated, use -apply to move duplicates")
+		if dryRun && apply {
+			fmt.Fprintln(stderr, "-apply can't be combined with -dryrun=true")
+			exit(1)
+		}
+		apply = apply || !dryRun
+	})
 
-	fileSizes := make(map[int64][]string)
-	printer := &ProgressPrinter{}
+	if outputPath != "" {
+		var err error
+		output, err = createOutput(outputPath)
+		handleError(err)
+		stdout = output
+	}
 
-	var permissionErrors []error
+	if recoverPath != "" {
+		handleError(recoverJournal(recoverPath, recoverAction, stdout))
+		return 0
+	}
+	if resumePath != "" {
+		handleError(resumeJournal(resumePath, stdout))
+		return 0
+	}
+	if undoPath != "" {
+		handleError(undoJournal(undoPath, stdout))
+		return 0
+	}
 
-	err := filepath.Walk(path, func(path string, info os.FileInfo, inErr error) error {
-		if inErr != nil {
-			permissionErrors = append(permissionErrors, inErr)
-			printer.Err()
-			return nil
+	// a - root reads the file list from stdin, which can be combined with walking the other roots
+	var walkRoots []string
+	for _, root := range roots {
+		if root == "-" {
+			fromStdin = true
+			continue
 		}
+		walkRoots = append(walkRoots, root)
+	}
+	if len(walkRoots) == 0 && !fromStdin {
+		fs.Usage()
+		return 1
+	}
+	walkRoots = append(walkRoots, references...)
+	roots, err := uniqueRoots(walkRoots, followSymlinks)
+	handleError(err)
 
-		if strings.Contains(path, rejectFolder) {
-			return nil
+	if verifyRejected {
+		handleError(validateRejectFolder(rejectFolder))
+		orphans, checked, errs := findOrphans(roots, rejectFolder, includeSnapshots, fileSum)
+		for _, orphan := range orphans {
+			fmt.Fprintln(stdout, orphan)
 		}
-
-		if !info.Mode().IsRegular() {
-			return nil
+		if len(errs) > 0 {
+			fmt.Fprint(stderr, "\n")
+			printErrorSummary(stderr, "The following files could not be read and were left out of the check", errs)
+		}
+		if len(orphans) > 0 {
+			fmt.Fprintf(stderr, "\n%d of %d rejected files have no copy left outside of the %s folders and should probably be restored\n", len(orphans), checked, rejectFolder)
+		} else {
+			fmt.Fprintf(stderr, "All %d rejected files still have an identical copy outside of the %s folders\n", checked, rejectFolder)
+		}
+		if len(errs) > 0 {
+			return exitSkippedFiles
 		}
+		return 0
+	}
 
-		for _, validExt := range validExt {
-			if strings.ToLower(filepath.Ext(path)) == validExt {
-				fileSizes[info.Size()] = append(fileSizes[info.Size()], path)
-				printer.Print(len(fileSizes[info.Size()]) > 1)
-				return nil
+	if readOnly {
+		conflicts := []struct {
+			flag string
+			set  bool
+		}{
+			{"-apply", apply},
+			{"-checkpoint-dir", checkpointDir != ""},
+			{"-bloom", bloomPath != ""},
+			{"-cache", cachePath != ""},
+			{"-thumb-dir", thumbDir != ""},
+			// where O_NOATIME isn't supported the access times are put back by writing them
+			{"-preserve-atime", preserveAtime},
+		}
+		for _, c := range conflicts {
+			if c.set {
+				fmt.Fprintf(stderr, "-read-only can't be combined with %s\n", c.flag)
+				return 1
 			}
 		}
-		return nil
-	})
+		// the reports can still be written, as long as they are outside of the scanned tree
+		outputs := []struct {
+			flag string
+			path string
+		}{
+			{"-output", outputPath},
+			{"-json-out", jsonOut},
+			{"-csv-out", csvOut},
+			{"-multi-hash", multiHashOut},
+			{"-results", resultsPath},
+		}
+		for _, out := range outputs {
+			if out.path == "" {
+				continue
+			}
+			path, err := canonicalPath(out.path)
+			handleError(err)
+			for _, root := range roots {
+				real, err := canonicalRoot(root, followSymlinks)
+				handleError(err)
+				if path == real || isInside(path, real) {
+					fmt.Fprintf(stderr, "-read-only can't write %s to %s inside the scanned %s\n", out.flag, out.path, root)
+					return 1
+				}
+			}
+		}
+	}
+
+	// identical files are duplicates no matter when they were taken
+	if timeWindow != 0 && !similar {
+		fmt.Fprintln(stderr, "-time-window only applies to -similar")
+		return 1
+	}
+	if timeWindow < 0 {
+		fmt.Fprintln(stderr, "-time-window can't be negative")
+		return 1
+	}
+	if similar && apply {
+		fmt.Fprintln(stderr, "-similar only reports similar images and can't be combined with -apply")
+		return 1
+	}
+	if rawJPEGKeep != "raw" && rawJPEGKeep != "jpeg" {
+		fmt.Fprintf(stderr, "unknown -raw-jpeg-keep '%s', use raw or jpeg\n", rawJPEGKeep)
+		return 1
+	}
+	if rawJPEG && (similar || histogram) {
+		fmt.Fprintln(stderr, "-raw-jpeg can't be combined with -similar or -histogram")
+		return 1
+	}
+	// the pairs are moved into reject folders without a journal, the other ways of handling a duplicate don't apply
+	if rawJPEG && apply && (journalPath != "" || useTrash || deleteDups || link) {
+		fmt.Fprintln(stderr, "-raw-jpeg can't be combined with -journal, -trash, -delete or -link")
+		return 1
+	}
+	if bursts && (similar || rawJPEG || histogram) {
+		fmt.Fprintln(stderr, "-bursts can't be combined with -similar, -raw-jpeg or -histogram")
+		return 1
+	}
+	// like the -raw-jpeg pairs the frames are only ever moved into reject folders
+	if bursts && apply && (journalPath != "" || useTrash || deleteDups || link) {
+		fmt.Fprintln(stderr, "-bursts can't be combined with -journal, -trash, -delete or -link")
+		return 1
+	}
+
+	if useTrash && (link || preserveStructure) {
+		fmt.Fprintln(stderr, "-trash can't be combined with -link or -preserve-structure")
+		return 1
+	}
+	if deleteDups && (link || useTrash) {
+		fmt.Fprintln(stderr, "-delete can't be combined with -link or -trash")
+		return 1
+	}
+	var bin trash
+	if useTrash {
+		var err error
+		if bin, err = newTrash(); err != nil {
+			fmt.Fprintf(stderr, "Warning: %s, using %s folders instead\n", err, rejectFolder)
+		}
+	}
+
+	handleError(validateRejectFolder(rejectFolder))
+	if walkThreads < 1 {
+		fmt.Fprintf(stderr, "-threads-walk must be at least 1\n")
+		return 1
+	}
+	if minCopies < 2 {
+		fmt.Fprintf(stderr, "-min-copies must be at least 2, a single file has no duplicates\n")
+		return 1
+	}
+	if maxGroup < 0 || maxGroup == 1 {
+		fmt.Fprintf(stderr, "-max-group must be 0 or at least 2, a group of one file has no duplicates to find\n")
+		return 1
+	}
+	if interactive && fromStdin {
+		fmt.Fprintln(stderr, "-interactive reads the answers from stdin and can't be combined with -stdin")
+		return 1
+	}
+	if allExt && (byMime || extSpec != "") {
+		fmt.Fprintln(stderr, "-all-ext can't be combined with -by-mime or -ext")
+		return 1
+	}
+	extensions := parseExtensions(extSpec, validExt)
+	if rawJPEG && (extSpec == "" || strings.HasPrefix(extSpec, "+")) {
+		// the defaults only have a few RAW formats
+		extensions = uniqueStrings(append(extensions, rawExtensions...))
+	}
+	matchingExt := newExtensionSet(extensions)
+	var minSize int64
+	if minSizeSpec != "" {
+		var err error
+		minSize, err = parseSize(minSizeSpec)
+		handleError(err)
+		// empty files are all identical and never worth a move, so any -minsize leaves them out
+		if minSize < 1 {
+			minSize = 1
+		}
+	}
+	var maxBytes int64
+	if maxBytesSpec != "" {
+		var err error
+		maxBytes, err = parseSize(maxBytesSpec)
+		handleError(err)
+	}
+	size, err := parseSize(bufSizeSpec)
+	handleError(err)
+	if size < 1 || size > 1<<30 {
+		handleError(fmt.Errorf("-bufsize must be between 1 byte and 1G, not %s", bufSizeSpec))
+	}
+	bufferSize = int(size)
+	if useMmap {
+		mmapThreshold = defaultMmapThreshold
+	}
+	handleError(validateRejectTemplate(rejectTemplate))
+	handleError(validateHashAlgorithm(hashAlgorithm))
+	if normalizeTemplate != "" {
+		handleError(validateNormalizeTemplate(normalizeTemplate))
+	}
+
+	var chars ProgressChars
+	for _, c := range []struct {
+		flag, value string
+		char        *rune
+	}{{"char-ok", charOK, &chars.OK}, {"char-dupe", charDupe, &chars.Dupe}, {"char-err", charErr, &chars.Err}} {
+		r, err := parseProgressChar(c.flag, c.value)
+		handleError(err)
+		*c.char = r
+	}
+
+	if endBlockSize < 1 {
+		fmt.Fprintf(stderr, "-endbytes must be at least 1\n")
+		return 1
+	}
+	if headBlockSize < 1 {
+		fmt.Fprintf(stderr, "-headbytes must be at least 1\n")
+		return 1
+	}
+
+	if sortOrder != "path" && sortOrder != "waste" {
+		fmt.Fprintf(stderr, "unknown -sort '%s', use path or waste\n", sortOrder)
+		return 1
+	}
 
+	workers, err := parseWorkers(workerSpec, runtime.NumCPU())
 	handleError(err)
-	fmt.Printf("\n\n")
 
-	if len(permissionErrors) > 0 {
-		fmt.Print("The following errors were encountered during the scan:\n\n")
-		for _, err := range permissionErrors {
-			fmt.Printf(" - '%s'\n", err)
+	var tiers deviceTiers
+	switch keep {
+	case "shortest", "oldest", "newest", "exif":
+	case "fastest-device":
+		var err error
+		tiers, err = parseTiers(tierSpec)
+		handleError(err)
+	default:
+		fmt.Fprintf(stderr, "unknown -keep strategy '%s'\n", keep)
+		return 1
+	}
+
+	// status is where banners and progress goes, stdout only gets the results so that it can be redirected to a file
+	var status io.Writer = stderr
+	switch format {
+	case "text", "human":
+		format = "text"
+	case "edges", "json", "jsonl", "csv":
+	default:
+		fmt.Fprintf(stderr, "unknown -format '%s'\n", format)
+		return 1
+	}
+
+	switch list {
+	case "", "originals", "duplicates":
+	default:
+		fmt.Fprintf(stderr, "unknown -list '%s', use originals or duplicates\n", list)
+		return 1
+	}
+	if list != "" && format != "text" {
+		fmt.Fprintln(stderr, "-list can't be combined with -format")
+		return 1
+	}
+	// text is the listing of every group with its original, which -list replaces with one kind of path
+	text := format == "text" && list == ""
+	if explain && !text {
+		fmt.Fprintln(stderr, "-explain shows the reasons in the text output and can't be combined with -format or -list")
+		return 1
+	}
+
+	var snapshot Report
+	if diffPath != "" {
+		if format != "text" || similar {
+			fmt.Fprintln(stderr, "-diff prints to the text output and can't be combined with -format or -similar")
+			return 1
+		}
+		var err error
+		snapshot, err = readJSONReport(diffPath)
+		handleError(err)
+		if snapshot.Scan.Hash != "" && snapshot.Scan.Hash != hashAlgorithm {
+			handleError(fmt.Errorf("%s was made with -hash %s, its groups can't be compared with %s sums", diffPath, snapshot.Scan.Hash, hashAlgorithm))
+		}
+	}
+
+	display := func(p string) string { return p }
+	if gitRelative {
+		first := "."
+		if len(roots) > 0 {
+			first = roots[0]
+		}
+		if root, ok := findGitRoot(first); ok {
+			display = func(p string) string { return relativePath(root, p) }
 		}
-		fmt.Print("\n")
 	}
 
-	candidates := duplicatesInt64(fileSizes)
+	var events *eventSink
+	if progressSocket != "" {
+		var err error
+		events, err = openEventSink(progressSocket)
+		handleError(err)
+		defer events.Close()
+	}
 
-	fmt.Printf("Comparing %d out of %d files in more detail\n", len(candidates), len(fileSizes))
+	// progress gets the progress characters and the banner of each phase, -quiet drops them but keeps the results
+	progress := status
+	if quiet {
+		progress = ioutil.Discard
+	}
+	bar, err := useProgressBar(progressMode, progress)
+	handleError(err)
+	logger := newLogger(stderr, verbose)
+	skipped := func(path, reason string) {
+		logger.Debug("skipped", "path", display(path), "reason", reason)
+	}
 
-	fileHashes := make(map[Hash][]string)
-	printer = &ProgressPrinter{Total: len(candidates)}
-	for _, filePath := range candidates {
-		sum, err := fileSHA1Sum(filePath)
+	ctx, cancel := runContext()
+	defer cancel()
+	// stopIfInterrupted exits with a summary of what was done once a signal cancelled ctx. It is only called between
+	// files and groups, never while a move is in progress.
+	stopIfInterrupted := func(progress string) {
+		if ctx.Err() == nil {
+			return
+		}
+		fmt.Fprintf(status, "\n\nInterrupted after %s\n", progress)
+		exit(exitInterrupted)
+	}
+
+	// the moves an interrupted run left in the journal are finished before scanning, a scan of the half moved tree
+	// would plan them all over again with different names
+	if journalPath != "" && apply {
+		pending, err := unfinishedJournal(journalPath)
 		handleError(err)
-		fileHashes[sum] = append(fileHashes[sum], filePath)
-		printer.Print(len(fileHashes[sum]) > 1)
+		switch {
+		case pending == 0:
+		case yes || (!fromStdin && confirmResume(os.Stdin, stderr, journalPath, pending)):
+			handleError(resumeJournal(journalPath, stderr))
+		default:
+			fmt.Fprintf(stderr, "\nLeaving the %s unfinished groups in %s, finish them with -resume-actions or undo them with -recover\n", thousands(pending), journalPath)
+		}
 	}
-	fmt.Printf("\n\n")
 
-	if dryRun {
-		fmt.Println("Showing duplicates")
-	} else {
-		fmt.Printf("Moving duplicates into %s folders\n", rejectFolder)
+	if profile.cpuPath != "" {
+		stop, err := startCPUProfile(profile.cpuPath)
+		handleError(err)
+		defer stop()
+	}
+	// the phases that -profile times, each ends where the next starts
+	var phases []phaseTiming
+	phaseStart := time.Now()
+	endPhase := func(name string, files int, bytes int64) {
+		now := time.Now()
+		phases = append(phases, phaseTiming{Name: name, Duration: now.Sub(phaseStart), Files: files, Bytes: bytes})
+		phaseStart = now
 	}
 
-	duplicates := duplicatesSHA1(fileHashes)
-	sort.Sort(ByShortest(duplicates))
+	start := time.Now()
+	fmt.Fprintf(progress, "Scanning directory and comparing file sizes\n")
 
-	for _, paths := range duplicates {
-		i := shortestIdx(paths)
-		original := paths[i]
-		paths = append(paths[:i], paths[i+1:]...)
+	index := newSpillingSizeIndex(maxMemory)
+	width := terminalWidth(progress)
+	newPrinter := func(total int, phase string) *ProgressPrinter {
+		return &ProgressPrinter{Total: total, Plain: plainProgress, Chars: chars, Out: progress, Events: events, Phase: phase, Bar: bar, Width: width}
+	}
+	printer := newPrinter(0, "scan")
 
-		rejectedDir := filepath.Join(filepath.Dir(original), rejectFolder)
-		if _, err := os.Stat(rejectedDir); !dryRun && os.IsNotExist(err) {
-			err := os.Mkdir(rejectedDir, 0755)
-			handleError(err)
+	var scanErrors []error
+	var seenFiles, fileCount int
+	var totalBytes int64
+	// hardlinks to a file that was already scanned take no space of their own, so only the first path is kept
+	inodes := make(map[inode]bool)
+	var skippedLinks int
+	var emptyFiles int
+	addFile := func(path string, info os.FileInfo) {
+		// empty files are all the same, grouping them together frees no space and is hardly ever what is wanted
+		if info.Size() == 0 && !includeEmpty {
+			emptyFiles++
+			skipped(path, "empty")
+			return
 		}
+		id, ok := linkedInode(info)
+		if followSymlinks {
+			// a symlinked file is the same file as its target, which may be scanned under its own path too
+			id, ok = fileInode(info)
+		}
+		if ok {
+			if inodes[id] {
+				skippedLinks++
+				skipped(path, "hardlink of a file that was already scanned")
+				return
+			}
+			inodes[id] = true
+		}
+		fileCount++
+		totalBytes += info.Size()
+		count, err := index.add(info.Size(), path)
+		handleError(err)
+		printer.Print(count > 1)
+	}
 
-		fmt.Printf("\n%s\n", original)
-		for i, f := range paths {
-			if dryRun {
-				fmt.Println(f)
-				continue
+	// addMatching adds a file found by the walk or read from the -stdin list if it is one of the files to compare
+	addMatching := func(path string, info os.FileInfo) {
+		if !rescanRejected && dedupe.InRejectFolder(path, rejectFolder) {
+			skipped(path, "in a reject folder")
+			return
+		}
+
+		if !info.Mode().IsRegular() {
+			if !info.IsDir() {
+				skipped(path, "not a regular file")
 			}
-			newLocation := copyPath(original, rejectedDir, i+1)
-			fmt.Println(newLocation)
-			err := os.Rename(f, newLocation)
-			handleError(err)
+			return
+		}
+		seenFiles++
+		if info.Size() < minSize {
+			skipped(path, "smaller than -minsize")
+			return
+		}
+
+		if allExt {
+			addFile(path, info)
+			return
+		}
+
+		if byMime {
+			contentType, err := sniffType(path)
+			if err != nil {
+				scanErrors = append(scanErrors, err)
+				printer.Err()
+				return
+			}
+			if isMediaType(contentType) {
+				addFile(path, info)
+			} else {
+				skipped(path, "not an image or video, it is "+contentType)
+			}
+			return
+		}
+
+		if matchingExt.matches(path) {
+			addFile(path, info)
+			return
 		}
+		skipped(path, "wrong extension")
 	}
-}
 
-func handleError(err error) {
-	if err == nil {
-		return
+	var skippedSnapshots, skippedDirLinks int
+	// with -follow-symlinks every directory walked is remembered by its real path, so that a link back up the tree or
+	// into a directory that is walked anyway is only walked once
+	visitedDirs := make(map[string]bool)
+	ignores := make(ignoreFiles)
+	walkTree := filepath.Walk
+	if walkThreads > 1 {
+		walkTree = func(dir string, fn filepath.WalkFunc) error { return parallelWalk(dir, walkThreads, fn) }
 	}
-	fmt.Printf("Error: '%s'\n", err)
-	os.Exit(1)
-}
+	// walk walks dir, which is root or a symlinked directory below it
+	var walk func(root, dir string) error
+	walk = func(root, dir string) error {
+		return walkTree(dir, func(path string, info os.FileInfo, inErr error) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if inErr != nil {
+				scanErrors = append(scanErrors, inErr)
+				printer.Err()
+				return nil
+			}
 
-type ByShortest [][]string
+			linkedDir := false
+			if followSymlinks && info.Mode()&os.ModeSymlink != 0 {
+				target, err := os.Stat(path)
+				if err != nil {
+					scanErrors = append(scanErrors, err)
+					printer.Err()
+					return nil
+				}
+				info, linkedDir = target, target.IsDir()
+			}
 
-func (s ByShortest) Len() int { return len(s) }
+			if info.IsDir() && maxDepth >= 0 && depthBelow(root, path) > maxDepth {
+				skipped(path, "deeper than -maxdepth")
+				return filepath.SkipDir
+			}
 
-func (s ByShortest) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+			if info.IsDir() && path != root && !includeSnapshots && isSnapshotDir(info.Name()) {
+				skippedSnapshots++
+				skipped(path, "snapshot directory")
+				return filepath.SkipDir
+			}
 
-func (s ByShortest) Less(i, j int) bool {
-	a := shortestIdx(s[i])
-	b := shortestIdx(s[j])
-	return strings.ToLower(s[i][a]) < strings.ToLower(s[j][b])
-}
+			// snapshot directories are often dot directories too, -include-snapshots is enough to scan those
+			if path != root && !includeHidden && isHidden(info.Name()) && !(includeSnapshots && info.IsDir() && isSnapshotDir(info.Name())) {
+				skipped(path, "hidden")
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			reason := ""
+			if path != root && isExcluded(excludes, root, path) {
+				reason = "matches -exclude"
+			} else if path != root && ignores.ignored(root, path, info.IsDir()) {
+				reason = "matches " + ignoreFileName
+			}
+			if reason != "" {
+				skipped(path, reason)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if followSymlinks && info.IsDir() {
+				if linkedDir {
+					// filepath.Walk doesn't descend into a symlink, with a trailing separator it walks the target
+					return walk(root, path+string(filepath.Separator))
+				}
+				real, err := filepath.EvalSymlinks(path)
+				if err != nil {
+					scanErrors = append(scanErrors, err)
+					printer.Err()
+					return filepath.SkipDir
+				}
+				if visitedDirs[real] {
+					skippedDirLinks++
+					skipped(path, "directory that was already walked")
+					return filepath.SkipDir
+				}
+				visitedDirs[real] = true
+			}
 
-func shortestIdx(a []string) int {
-	idx := 0
-	for i, path := range a {
-		if len(path) < len(a[idx]) {
-			idx = i
+			if info.IsDir() {
+				if err := ignores.load(path); err != nil {
+					scanErrors = append(scanErrors, err)
+					printer.Err()
+				}
+			}
+			addMatching(path, info)
+			return nil
+		})
+	}
+	// readList adds the files listed in r, one path per line as printed by find, without walking any directories.
+	// Files inside a walked root are already scanned and are skipped.
+	listed := make(map[string]bool)
+	var walkedRoots, realRoots []string
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		handleError(err)
+		walkedRoots = append(walkedRoots, abs)
+		real, err := canonicalRoot(root, followSymlinks)
+		handleError(err)
+		realRoots = append(realRoots, real)
+	}
+	readList := func(r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			path := strings.TrimSuffix(scanner.Text(), "\r")
+			if path == "" {
+				continue
+			}
+			// the same file can be listed under another path, through a symlinked directory or with ../ in it
+			real, err := canonicalPath(path)
+			if err != nil {
+				return err
+			}
+			if listed[real] || walked(realRoots, real) {
+				continue
+			}
+			listed[real] = true
+			info, err := os.Lstat(path)
+			if err != nil {
+				scanErrors = append(scanErrors, err)
+				printer.Err()
+				continue
+			}
+			if isExcluded(excludes, ".", path) {
+				skipped(path, "matches -exclude")
+				continue
+			}
+			addMatching(path, info)
 		}
+		return scanner.Err()
+	}
+	for _, root := range roots {
+		if err := walk(root, root); ctx.Err() == nil {
+			handleError(err)
+		}
+	}
+	if fromStdin {
+		if err := readList(os.Stdin); ctx.Err() == nil {
+			handleError(err)
+		}
+	}
+	printer.Done()
+	stopIfInterrupted(fmt.Sprintf("finding %s files, nothing was hashed or moved", thousands(fileCount)))
+	if skippedSnapshots > 0 {
+		fmt.Fprintf(status, "\n\nSkipped %d snapshot directories, use -include-snapshots to scan them", skippedSnapshots)
+	}
+	if skippedLinks > 0 {
+		kind := "hardlinks"
+		if followSymlinks {
+			kind = "hardlinks or symlinks"
+		}
+		fmt.Fprintf(status, "\n\nSkipped %d paths that are %s to an already scanned file", skippedLinks, kind)
+	}
+	if skippedDirLinks > 0 {
+		fmt.Fprintf(status, "\n\nSkipped %d directories that were already scanned through a symlink or under their own path", skippedDirLinks)
+	}
+	if emptyFiles > 0 {
+		fmt.Fprintf(status, "\n\nSkipped %d empty files, use -include-empty to compare them", emptyFiles)
 	}
-	return idx
-}
 
-func copyPath(filePath, dest string, number int) string {
-	ext := filepath.Ext(filePath)
-	name := filePath[0 : len(filePath)-len(ext)]
-	copyName := fmt.Sprintf("%s_%d%s", filepath.Base(name), number, ext)
-	return filepath.Join(dest, copyName)
-}
+	// only the reports that look at every file need the sizes that no other file shares
+	if index.spilled() {
+		fmt.Fprintf(status, "\n\nThe file list went over -max-memory and was kept on disk during the scan")
+	}
+	fileSizes, err := index.bySize(reportArchives || dirDedup || multiHashOut != "" || similar || histogram || rawJPEG || bursts)
+	handleError(err)
+	endPhase("walk", fileCount, totalBytes)
+	fmt.Fprintf(progress, "\n\n")
 
-func fileSHA1Sum(filePath string) (Hash, error) {
-	hasher := sha1.New()
-	var hashInBytes Hash
+	printErrorSummary(status, "The following errors were encountered during the scan", scanErrors)
 
-	file, err := os.Open(filePath)
-	if err != nil {
-		return hashInBytes, err
+	if histogram {
+		printHistogram(stdout, sizeHistogram(fileSizes))
+		return 0
 	}
-	defer file.Close()
-
-	defer hasher.Reset()
-	if _, err := io.Copy(hasher, file); err != nil {
-		return hashInBytes, err
+	if rawJPEG {
+		pairs := rawJPEGPairs(fileSizes)
+		errs := handleRawJPEGPairs(pairs, rawJPEGKeep == "raw", apply, rejectFolder, rejectTemplate, references, strings.Split(protectNames, ","), stdout, status, display)
+		fmt.Fprintf(status, "\nFound %s RAW+JPEG pairs among %s files\n", thousands(len(pairs)), thousands(fileCount))
+		if len(errs) > 0 {
+			return exitSkippedFiles
+		}
+		if len(pairs) > 0 && !apply {
+			return exitDuplicatesFound
+		}
+		return 0
+	}
+	if similar {
+		reportSimilarImages(fileSizes, similarThreshold, timeWindow, newPrinter, stdout, progress, status, display)
+		return 0
+	}
+	if bursts {
+		found, errs := reportBursts(fileSizes, similarThreshold, burstWindow, newPrinter, stdout, progress, status, display)
+		if apply {
+			confirm := func(frames int) bool { return yes || confirmBurstMoves(os.Stdin, stderr, frames, rejectFolder) }
+			errs = append(errs, moveBurstFrames(found, rejectFolder, rejectTemplate, references, strings.Split(protectNames, ","), confirm, status, display)...)
+		}
+		if len(errs) > 0 {
+			return exitSkippedFiles
+		}
+		return 0
 	}
 
-	copy(hashInBytes[:], hasher.Sum(nil))
-	return hashInBytes, nil
-}
+	// sums that are already known, so the full hash sweep doesn't have to read those files again
+	knownSums := make(map[string]Hash)
+	if multiHashOut != "" {
+		var allFiles []string
+		walkedSizes := make(map[string]int64)
+		for size, paths := range fileSizes {
+			allFiles = append(allFiles, paths...)
+			for _, path := range paths {
+				walkedSizes[path] = size
+			}
+		}
+		sort.Strings(allFiles)
 
-func duplicatesInt64(f map[int64][]string) []string {
-	var result []string
-	for _, paths := range f {
-		if len(paths) < 2 {
-			continue
+		fmt.Fprintf(progress, "Hashing all %d files for the manifest\n", len(allFiles))
+		manifest := Manifest{Files: []ManifestEntry{}}
+		printer = newPrinter(len(allFiles), "manifest")
+		var manifestErrors []error
+		for _, filePath := range allFiles {
+			entry, err := multiHashSum(filePath, walkedSizes[filePath])
+			if err != nil {
+				manifestErrors = append(manifestErrors, err)
+				printer.Err()
+				continue
+			}
+			switch hashAlgorithm {
+			case "sha1":
+				knownSums[filePath] = Hash(entry.SHA1)
+			case "sha256":
+				knownSums[filePath] = Hash(entry.SHA256)
+			}
+			entry.Path = display(filePath)
+			manifest.Files = append(manifest.Files, entry)
+			printer.Print(false)
 		}
-		result = append(result, paths...)
+		fmt.Fprintf(progress, "\n\n")
+		handleError(writeManifest(multiHashOut, manifest))
+		// the scan errors were already summarized, so these get their own list before they count for the exit code
+		printErrorSummary(status, "The following files could not be read and were left out of the manifest", manifestErrors)
+		scanErrors = append(scanErrors, manifestErrors...)
 	}
-	return result
-}
 
-func duplicatesSHA1(f map[Hash][]string) [][]string {
-	var result [][]string
-	for _, paths := range f {
+	blockSizes := fileSizes
+	if sameDir {
+		blockSizes = sameDirSizes(fileSizes)
+	}
+	if dedupeByName {
+		before := len(dedupe.DuplicatesInt64(blockSizes))
+		blockSizes = sameNameSizes(blockSizes)
+		fmt.Fprintf(progress, "The name comparison left out %d of %d same sized files\n\n", before-len(dedupe.DuplicatesInt64(blockSizes)), before)
+	}
+	if maxGroup > 0 {
+		var skipped []sizeBucket
+		blockSizes, skipped = capSizeBuckets(blockSizes, maxGroup)
+		for _, bucket := range skipped {
+			fmt.Fprintf(status, "Skipped %s files of %s, too many collisions for -max-group %d\n", thousands(bucket.Files), humanBytes(bucket.Size), maxGroup)
+		}
+	}
+	sizeCandidates := dedupe.DuplicatesInt64(blockSizes)
+	if logger.Enabled(ctx, slog.LevelInfo) {
+		for _, size := range sortedSizes(blockSizes) {
+			if n := len(blockSizes[size]); n > 1 {
+				logger.Info("size group", "size", size, "files", n)
+			}
+		}
+	}
+
+	fmt.Fprintf(progress, "Comparing the first %d and last %d bytes of %d out of %d files\n", headBlockSize, endBlockSize, len(sizeCandidates), fileCount)
+
+	// files that can't be read after the walk are left out of the comparison instead of ending the run
+	var readErrors []error
+	endBlocks := make(map[blockKey][]string)
+	sizes := make(map[string]int64)
+	printer = newPrinter(len(sizeCandidates), "endblocks")
+	var compared int
+	var blockBytes int64
+	phaseStart = time.Now()
+endBlockLoop:
+	for size, paths := range blockSizes {
 		if len(paths) < 2 {
 			continue
 		}
-		result = append(result, paths)
+		for _, filePath := range paths {
+			if ctx.Err() != nil {
+				break endBlockLoop
+			}
+			compared++
+			sum, err := endBlocksSHA1Sum(filePath, size, headBlockSize, endBlockSize)
+			if err != nil {
+				readErrors = append(readErrors, err)
+				printer.Err()
+				continue
+			}
+			key := blockKey{size: size, sum: sum}
+			if sameDir {
+				key.dir = filepath.Dir(filePath)
+			}
+			endBlocks[key] = append(endBlocks[key], filePath)
+			sizes[filePath] = size
+			if read := headBlockSize + endBlockSize; read < size {
+				blockBytes += read
+			} else {
+				blockBytes += size
+			}
+			printer.Print(len(endBlocks[key]) > 1)
+		}
 	}
-	return result
-}
+	stopIfInterrupted(fmt.Sprintf("comparing the end blocks of %s of %s files, nothing was moved", thousands(compared), thousands(len(sizeCandidates))))
+	fmt.Fprintf(progress, "\n\n")
 
-// ProgressPrinter will print a progress counter and if Total is set a percentage of how far the along the work has gone
-type ProgressPrinter struct {
-	Total int // the total number of entries that will be printed, zero if unknown
+	endPhase("end blocks", compared, blockBytes)
+	candidates := duplicatesBlock(endBlocks)
+	for _, paths := range endBlocks {
+		if len(paths) == 1 {
+			logger.Debug("ruled out", "path", display(paths[0]), "reason", "first and last bytes differ from every file of its size")
+		}
+	}
+	fmt.Fprintf(progress, "The end block comparison ruled out %d of %d files\n\n", len(sizeCandidates)-len(candidates), len(sizeCandidates))
 
-	current   int
-	lineCount int
-}
+	var bloom *bloomFilter
+	var bloomKeys map[string][]byte
+	if bloomPath != "" {
+		var err error
+		var skipped int
+		var errs []error
+		bloom, err = loadBloomFilter(bloomPath)
+		handleError(err)
+		candidates, bloomKeys, skipped, errs = skipKnownUnique(endBlocks, bloom)
+		readErrors = append(readErrors, errs...)
+		fmt.Fprintf(status, "Skipped %d files that were unique in an earlier run\n\n", skipped)
+	}
 
-func (p *ProgressPrinter) Err() {
-	p.inc()
-	fmt.Print("e")
-}
+	// hashing in a fixed order keeps the order of the paths in each group, and with it the output, the same between runs
+	sort.Strings(candidates)
+	fmt.Fprintf(progress, "Comparing %d files in more detail\n", len(candidates))
 
-func (p *ProgressPrinter) Print(dupe bool) {
-	p.inc()
-	if dupe {
-		fmt.Print("d")
-	} else {
-		fmt.Print(".")
+	fileHashes := make(map[Hash][]string)
+	hashes := make(map[string]Hash)
+	var groupCount int
+	var reclaimable int64
+	printer = newPrinter(len(candidates), "hash")
+	var hashedBytes int64
+	phaseStart = time.Now()
+	if checkpointDir != "" {
+		handleError(os.MkdirAll(checkpointDir, 0755))
 	}
-}
-
-func (p *ProgressPrinter) inc() {
-	if p.lineCount == 77 || p.lineCount == 0 {
-		if p.Total == 0 {
-			fmt.Printf("\n   ")
-		} else {
-			fmt.Printf("\n%2.0f%% ", float32(p.current)/float32(p.Total)*100)
+	budget := newHashBudget(maxBytes)
+	readSum := func(filePath string) (Hash, error) {
+		// sums from the cache or a -multi-hash manifest don't read the file, so only the real reads count
+		if !budget.take(sizes[filePath]) {
+			return "", errBudgetUsed
 		}
-		p.lineCount = 0
+		if checkpointDir != "" {
+			return fileSumCheckpointed(filePath, checkpointDir)
+		}
+		return fileSum(filePath)
+	}
+	var cache *hashCache
+	if cachePath != "" {
+		var err error
+		cache, err = loadHashCache(cachePath, hashAlgorithm)
+		handleError(err)
 	}
-	p.current++
-	p.lineCount++
+	sumFile := func(filePath string) (Hash, error) {
+		if known, ok := knownSums[filePath]; ok {
+			return known, nil
+		}
+		if cache != nil {
+			return cache.sum(filePath, readSum)
+		}
+		return readSum(filePath)
+	}
+	var unchecked []string
+	var stream *groupStream
+	if format == "jsonl" {
+		stream = newGroupStream(stdout, endBlocks, candidates, display)
+	}
+	// the -abort-groups and -abort-bytes limits stop reading the results early, which has to stop the workers too
+	hashCtx, stopHashing := context.WithCancel(ctx)
+	defer stopHashing()
+	for result := range hashFiles(hashCtx, candidates, workers, sumFile) {
+		if result.err != nil && stream != nil {
+			handleError(stream.done(result.path, "", fileHashes, hashes))
+		}
+		if result.err == errBudgetUsed {
+			unchecked = append(unchecked, result.path)
+			printer.Print(false)
+			continue
+		}
+		if result.err != nil {
+			readErrors = append(readErrors, result.err)
+			printer.Err()
+			continue
+		}
+		filePath, sum := result.path, result.sum
+		key := sum
+		if hashIncludeSize {
+			key = sizedKey(sum, sizes[filePath])
+		}
+		if sameDir {
+			key = dirKey(key, filePath)
+		}
+		fileHashes[key] = append(fileHashes[key], filePath)
+		hashes[filePath] = sum
+		if stream != nil {
+			handleError(stream.done(filePath, key, fileHashes, hashes))
+		}
+		hashedBytes += sizes[filePath]
+		printer.Print(len(fileHashes[key]) > 1)
+
+		if len(fileHashes[key]) == 2 {
+			groupCount++
+		}
+		if len(fileHashes[key]) > 1 {
+			reclaimable += sizes[filePath]
+		}
+		if abortGroups > 0 && groupCount > abortGroups {
+			fmt.Fprintf(status, "\n\nAborting: found %d duplicate groups which is more than the limit of %d\n", groupCount, abortGroups)
+			return exitAborted
+		}
+		if abortBytes > 0 && reclaimable > abortBytes {
+			fmt.Fprintf(status, "\n\nAborting: found %d reclaimable bytes which is more than the limit of %d\n", reclaimable, abortBytes)
+			return exitAborted
+		}
+	}
+	fmt.Fprintf(progress, "\n\n")
+
+	if cache != nil {
+		fmt.Fprintf(status, "Reused %d cached sums and hashed %d files\n\n", cache.hits, cache.misses)
+		handleError(cache.save(cachePath))
+	}
+	endPhase("full hash", len(hashes), hashedBytes)
+	printErrorSummary(status, "The following files could not be read and were left out of the comparison", readErrors)
+	if len(unchecked) > 0 {
+		fmt.Fprintf(status, "The -max-bytes budget of %s was used up, %s files were not checked yet:\n\n", humanBytes(maxBytes), thousands(len(unchecked)))
+		for _, path := range unchecked {
+			fmt.Fprintf(status, " - '%s'\n", display(path))
+		}
+		fmt.Fprint(status, "\n")
+	}
+	scanErrors = append(scanErrors, readErrors...)
+
+	// the sums so far are in the cache, but files not hashed yet could still be duplicates of the ones that were
+	stopIfInterrupted(fmt.Sprintf("hashing %s of %s files and finding %d duplicate groups, nothing was moved", thousands(len(hashes)), thousands(len(candidates)), groupCount))
+
+	if bloom != nil {
+		for _, paths := range fileHashes {
+			if len(paths) == 1 {
+				bloom.add(bloomKeys[paths[0]])
+			}
+		}
+		handleError(bloom.save(bloomPath))
+	}
+
+	if !apply {
+		fmt.Fprintln(progress, "Showing duplicates")
+	} else if deleteDups {
+		fmt.Fprintln(progress, "Deleting duplicates")
+	} else if bin != nil {
+		fmt.Fprintln(progress, "Moving duplicates to the trash")
+	} else {
+		fmt.Fprintf(progress, "Moving duplicates into %s folders\n", rejectFolder)
+	}
+
+	duplicates := dedupe.DuplicatesSHA1(fileHashes)
+	if logger.Enabled(ctx, slog.LevelInfo) {
+		for _, paths := range duplicates {
+			logger.Info("hash group", "hash", string(hashes[paths[0]]), "files", len(paths))
+		}
+		for _, paths := range fileHashes {
+			if len(paths) == 1 {
+				logger.Debug("ruled out", "path", display(paths[0]), "reason", "no other file has its hash")
+			}
+		}
+	}
+	events.emit(event{Event: "done", Groups: len(duplicates)})
+
+	report := Report{
+		Scan: ScanParameters{
+			Version:         version,
+			Roots:           displayPaths(roots, display),
+			ByMime:          byMime,
+			MinSize:         minSize,
+			Exclude:         excludes,
+			Keep:            keep,
+			Prefer:          displayPaths(prefer, display),
+			Reference:       displayPaths(references, display),
+			Hash:            hashAlgorithm,
+			HashIncludeSize: hashIncludeSize,
+			SameDir:         sameDir,
+			HeadBytes:       headBlockSize,
+			EndBytes:        endBlockSize,
+			DryRun:          !apply,
+		},
+		Errors: []ReportError{},
+	}
+	if !byMime && !allExt {
+		report.Scan.Extensions = extensions
+	}
+	for _, err := range scanErrors {
+		report.Errors = append(report.Errors, newReportError(err))
+	}
+	protected := strings.Split(protectNames, ",")
+	// skipGroup leaves a group alone when one of its files went missing or can't be read since it was hashed
+	skipGroup := func(paths []string, err error) {
+		fmt.Fprintf(status, "Skipping the group of %s, %s\n", display(paths[0]), err)
+		scanErrors = append(scanErrors, err)
+		report.Errors = append(report.Errors, newReportError(err))
+	}
+	// from here on an I/O error about a single file, like one that disappeared or was busy since it was hashed, leaves
+	// that file alone and is listed at the end, the other files are still handled. handleError is left for the errors
+	// that make going on pointless, like a reject folder or journal that can't be written.
+	var skippedFiles []error
+	skipFile := func(err error) {
+		skippedFiles = append(skippedFiles, err)
+		report.Errors = append(report.Errors, newReportError(err))
+	}
+	var groups []Group
+	var alreadyShared int
+	// the originals inside a -reference directory, which are never moved or renamed
+	referenceFiles := make(map[string]bool)
+	// rejectedDirOf returns the reject folder next to the original of group, or next to its first duplicate when the
+	// original is in a -reference directory that must stay untouched
+	rejectedDirOf := func(group Group) string {
+		if referenceFiles[group.Original] && len(group.Duplicates) > 0 {
+			return filepath.Join(filepath.Dir(group.Duplicates[0]), rejectFolder)
+		}
+		return filepath.Join(filepath.Dir(group.Original), rejectFolder)
+	}
+	var fewCopies int
+	var enoughCopies [][]string
+	for _, paths := range duplicates {
+		if len(paths) < minCopies {
+			fewCopies++
+			continue
+		}
+		enoughCopies = append(enoughCopies, paths)
+	}
+	picker := keeper{keep: keep, prefer: prefer, references: references, rescanRejected: rescanRejected, rejectFolder: rejectFolder, tiers: tiers}
+	for _, group := range groupDuplicates(enoughCopies, picker, sizes, hashes, skipGroup) {
+		original, paths := group.Original, group.Duplicates
+		if len(preferredPaths([]string{original}, references)) > 0 {
+			referenceFiles[original] = true
+		}
+		// verifying here, before -top, -interactive and the report, means every group that is listed or moved was
+		// compared byte for byte, which is why it isn't overlapped with the moves that only start after all of those
+		if verify {
+			var collisions []string
+			var err error
+			paths, collisions, err = verifiedDuplicates(original, paths, filesEqual)
+			if err != nil {
+				skipGroup([]string{original}, err)
+				continue
+			}
+			for _, path := range collisions {
+				fmt.Fprintf(status, "Suspected hash collision, %s has the same hash as %s but different content\n", display(path), display(original))
+				report.Errors = append(report.Errors, ReportError{Path: display(path), Message: "suspected hash collision with " + display(original)})
+			}
+		}
+		paths = withoutProtected(paths, protected)
+		paths, shared := withoutSharedExtents(original, paths)
+		alreadyShared += shared
+		if len(paths) == 0 {
+			continue
+		}
+		group.Duplicates = paths
+		groups = append(groups, group)
+	}
+
+	if fewCopies > 0 {
+		fmt.Fprintf(status, "Left out %s duplicate groups with fewer than %d copies\n", thousands(fewCopies), minCopies)
+	}
+
+	if sortOrder == "waste" || top > 0 {
+		sort.Stable(ByWaste(groups))
+	}
+	if top > 0 {
+		if len(groups) > top {
+			groups = groups[:top]
+		}
+		var topReclaimable int64
+		for _, group := range groups {
+			topReclaimable += group.wasted()
+		}
+		fmt.Fprintf(status, "The %d biggest duplicate groups reclaim %s\n", len(groups), humanBytes(topReclaimable))
+	}
+	if interactive {
+		groups = chooseOriginals(groups, os.Stdin, stderr, display)
+	}
+
+	for _, group := range groups {
+		report.Groups = append(report.Groups, Group{
+			Original:   display(group.Original),
+			Duplicates: displayPaths(group.Duplicates, display),
+			Size:       group.Size,
+			Hash:       group.Hash,
+		})
+	}
+
+	if alreadyShared > 0 {
+		fmt.Fprintf(status, "Skipped %d duplicates that already share their storage with the original\n", alreadyShared)
+	}
+
+	if thumbDir != "" {
+		handleError(os.MkdirAll(thumbDir, 0755))
+		for i, group := range groups {
+			if err := writeGroupThumbnails(thumbDir, i+1, group); err != nil {
+				fmt.Fprintf(status, "Could not write thumbnails: %s\n", err)
+			}
+		}
+	}
+
+	// with -livephotos the two halves of a live photo are always moved together, or not at all
+	originals := make(map[string]bool)
+	moving := make(map[string]bool)
+	for _, group := range groups {
+		originals[group.Original] = true
+		for _, f := range group.Duplicates {
+			moving[f] = true
+		}
+	}
+	moved := make(map[string]bool)
+	// names given to originals by -normalize-originals, which can share a directory across groups
+	normalizedNames := make(map[string]bool)
+
+	var resultsFile *os.File
+	if resultsPath != "" && apply {
+		var err error
+		resultsFile, err = os.Create(resultsPath)
+		handleError(err)
+		defer resultsFile.Close()
+	}
+
+	var moveJournal *journal
+	if journalPath != "" && apply {
+		var err error
+		moveJournal, err = openJournal(journalPath)
+		handleError(err)
+		defer moveJournal.Close()
+	}
+
+	// every move is planned before the first rename, so that the journal holds the whole plan and an interrupted
+	// run can be resumed with -resume-actions
+	plans := make([]movePlan, len(groups))
+	// originals in the same directory share a reject folder, so the planned names are tracked across all groups
+	taken := make(map[string]bool)
+	// the planned locations inside the trash, moved there by the trash so that it can be restored from it
+	trashed := make(map[string]bool)
+	for groupID, group := range groups {
+		original, paths := group.Original, group.Duplicates
+		plan := &plans[groupID]
+		rejectedDir := rejectedDirOf(group)
+
+		for i, f := range paths {
+			if rescanRejected && dedupe.InRejectFolder(f, rejectFolder) {
+				plan.notes = append(plan.notes, fmt.Sprintf("Leaving %s where it is since it is already in a %s folder", display(f), rejectFolder))
+				continue
+			}
+			// -interactive can make a reference file one of the duplicates
+			if referenceFiles[f] {
+				plan.notes = append(plan.notes, fmt.Sprintf("Leaving %s where it is since it is in a -reference directory", display(f)))
+				continue
+			}
+			// a hardlink keeps every path in place, so there is no live photo pair that could be separated
+			if link {
+				plan.links = append(plan.links, moveOp{Src: original, Dst: f})
+				continue
+			}
+
+			pair, hasPair := "", false
+			if livePhotos {
+				pair, hasPair = livePhotoPair(f)
+			}
+			if hasPair && isLivePhotoVideo(f) {
+				if !moving[pair] {
+					plan.notes = append(plan.notes, fmt.Sprintf("Keeping %s since its live photo %s is kept", display(f), display(pair)))
+				}
+				// otherwise it is moved together with its photo
+				continue
+			}
+			if hasPair && originals[pair] {
+				plan.notes = append(plan.notes, fmt.Sprintf("Keeping %s since its live photo video %s is kept", display(f), display(pair)))
+				continue
+			}
+			if moved[f] {
+				continue
+			}
+
+			fields := rejectFields{original: original, duplicate: f, hash: group.Hash, number: i + 1}
+			if strings.Contains(rejectTemplate, "{date}") {
+				var err error
+				if fields.date, err = captureDate(f); err != nil {
+					skipFile(err)
+					continue
+				}
+			}
+			var pairExts []string
+			if hasPair && !moved[pair] {
+				pairExts = append(pairExts, filepath.Ext(pair))
+			}
+			if deleteDups {
+				plan.deletes = append(plan.deletes, moveOp{Src: original, Dst: f})
+				moved[f] = true
+				if hasPair && !moved[pair] {
+					plan.deletes = append(plan.deletes, moveOp{Src: original, Dst: pair})
+					moved[pair] = true
+				}
+				continue
+			}
+
+			var newLocation string
+			trashDir, err := "", errTrashUnsupported
+			if bin != nil {
+				if trashDir, err = bin.filesDir(f); err != nil {
+					plan.notes = append(plan.notes, fmt.Sprintf("Moving %s into %s since it can't go to the trash: %s", display(f), rejectFolder, err))
+				}
+			}
+			if err == nil {
+				newLocation = structuredCopyPath(rejectTemplate, fields, trashDir, filepath.Base(f), taken, pairExts...)
+				trashed[newLocation] = true
+			} else if preserveStructure {
+				newLocation = structuredCopyPath(rejectTemplate, fields, rejectedDir, relativeToRoots(walkedRoots, f), taken, pairExts...)
+			} else {
+				newLocation = freeCopyPath(rejectTemplate, fields, rejectedDir, taken, pairExts...)
+			}
+			plan.moves = append(plan.moves, moveOp{Src: f, Dst: newLocation})
+			moved[f] = true
+
+			if hasPair && !moved[pair] {
+				pairLocation := withExt(newLocation, filepath.Ext(pair))
+				plan.moves = append(plan.moves, moveOp{Src: pair, Dst: pairLocation})
+				trashed[pairLocation] = trashed[newLocation]
+				moved[pair] = true
+			}
+		}
+
+		if normalizeTemplate != "" && !referenceFiles[original] && len(plan.moves)+len(plan.links)+len(plan.deletes) > 0 {
+			date, err := captureDate(original)
+			if err != nil {
+				skipFile(err)
+			} else if normalized := normalizedPath(normalizeTemplate, original, date, normalizedNames); normalized != original {
+				plan.renames = append(plan.renames, moveOp{Src: original, Dst: normalized})
+				if pair, ok := livePhotoPair(original); livePhotos && ok && !moving[pair] {
+					pairLocation := withExt(normalized, filepath.Ext(pair))
+					normalizedNames[pairLocation] = true
+					plan.renames = append(plan.renames, moveOp{Src: pair, Dst: pairLocation})
+				}
+			}
+		}
+
+		if ops := plan.ops(); len(ops) > 0 && apply {
+			handleError(moveJournal.plan(groupID+1, group.Original, group.Size, Hash(group.Hash), ops))
+		}
+	}
+
+	if deleteDups && apply {
+		var files int
+		var size int64
+		for groupID, plan := range plans {
+			files += len(plan.deletes)
+			size += groups[groupID].Size * int64(len(plan.deletes))
+		}
+		if files > 0 && !yes && !confirmDelete(os.Stdin, stderr, files, size) {
+			fmt.Fprintln(status, "Nothing was deleted")
+			return exitDuplicatesFound
+		}
+	}
+
+	var handled int
+	var handledFiles int
+	var handledBytes int64
+	// the directories that files are moved out of for -prune-empty, and for a dry run the files that would leave and
+	// the directories they would arrive in
+	var vacated []string
+	leaving := make(map[string]bool)
+	arriving := make(map[string]bool)
+	phaseStart = time.Now()
+	for groupID, group := range groups {
+		if ctx.Err() != nil {
+			break
+		}
+		handled++
+		handledFiles += len(group.Duplicates)
+		handledBytes += group.wasted()
+		original, paths, plan := group.Original, group.Duplicates, plans[groupID]
+
+		rejectedDir := rejectedDirOf(group)
+		rejects := false
+		for _, op := range plan.moves {
+			rejects = rejects || !trashed[op.Dst]
+		}
+		if _, err := os.Stat(rejectedDir); apply && rejects && os.IsNotExist(err) {
+			guardWrite("create", rejectedDir)
+			err := os.Mkdir(longPath(rejectedDir), 0755)
+			handleError(err)
+		}
+
+		// the text output only lists the first groups unless -all-groups is set, the moves happen for all of them
+		shown := !text || allGroups || groupID < defaultPreviewGroups
+		switch list {
+		case "originals":
+			fmt.Fprintln(stdout, display(original))
+		case "duplicates":
+			for _, op := range plan.moves {
+				fmt.Fprintln(stdout, display(op.Src))
+			}
+			for _, op := range plan.links {
+				fmt.Fprintln(stdout, display(op.Dst))
+			}
+			for _, op := range plan.deletes {
+				fmt.Fprintln(stdout, display(op.Dst))
+			}
+		}
+		if format == "edges" {
+			edges := append([]string(nil), paths...)
+			sort.Strings(edges)
+			for _, f := range edges {
+				fmt.Fprintf(stdout, "%s\t%s\n", display(original), display(f))
+			}
+		} else if text && shown {
+			line := display(original)
+			if sortOrder == "waste" {
+				line += fmt.Sprintf(" (%s reclaimable)", humanBytes(group.wasted()))
+			}
+			if explain {
+				line += fmt.Sprintf("  kept (%s)", group.Reason)
+			}
+			fmt.Fprintf(stdout, "\n%s\n", line)
+		}
+		if shown {
+			for _, note := range plan.notes {
+				fmt.Fprintln(status, note)
+			}
+		}
+
+		if pruneEmpty {
+			for _, op := range plan.ops() {
+				vacated = append(vacated, filepath.Dir(op.Src), filepath.Join(filepath.Dir(op.Src), rejectFolder))
+				leaving[op.Src] = true
+				withParents(arriving, filepath.Dir(op.Dst))
+			}
+			for _, op := range plan.deletes {
+				vacated = append(vacated, filepath.Dir(op.Dst), filepath.Join(filepath.Dir(op.Dst), rejectFolder))
+				leaving[op.Dst] = true
+			}
+		}
+
+		if !apply {
+			if text && shown {
+				for _, op := range plan.moves {
+					fmt.Fprintln(stdout, display(op.Src))
+				}
+				for _, op := range plan.links {
+					fmt.Fprintln(stdout, display(op.Dst))
+				}
+				for _, op := range plan.deletes {
+					fmt.Fprintln(stdout, display(op.Dst))
+				}
+			}
+			if shown {
+				for _, op := range plan.renames {
+					fmt.Fprintf(status, "Would rename %s to %s\n", display(op.Src), display(op.Dst))
+				}
+			}
+			continue
+		}
+
+		result := groupResult{Group: groupID + 1, Original: display(original), Hash: group.Hash}
+		// the original is looked at again right before its duplicates are handled, the scan could be a while ago
+		recheckSum := Hash("")
+		if recheckOriginal {
+			recheckSum = Hash(group.Hash)
+		}
+		if err := checkOriginal(original, group.Size, recheckSum); err != nil {
+			fmt.Fprintf(status, "Skipping the group of %s, %s\n", display(original), err)
+			skipFile(err)
+			// the group was planned in the journal, it has to be closed or a later run would resume it
+			if len(plan.ops()) > 0 {
+				handleError(moveJournal.rollback(groupID + 1))
+			}
+			continue
+		}
+		// links are done before the original is renamed, and a duplicate that can't be linked is simply kept
+		for _, op := range plan.links {
+			if text && shown {
+				fmt.Fprintln(stdout, display(op.Dst))
+			}
+			guardWrite("link", op.Dst)
+			if err := linkDuplicate(longPath(op.Src), longPath(op.Dst), os.Link); err != nil {
+				if isCrossDevice(err) {
+					fmt.Fprintf(status, "Keeping %s since it is on another filesystem than %s\n", display(op.Dst), display(op.Src))
+				} else {
+					skipFile(err)
+				}
+				result.Results = append(result.Results, moveResult{Src: display(op.Src), Dst: display(op.Dst), Error: err.Error()})
+				continue
+			}
+			result.Results = append(result.Results, moveResult{Src: display(op.Src), Dst: display(op.Dst), OK: true})
+		}
+		// deletes are done before the original is renamed too, each right after checking that the original is there
+		for _, op := range plan.deletes {
+			if text && shown {
+				fmt.Fprintln(stdout, display(op.Dst))
+			}
+			guardWrite("delete", op.Dst)
+			if err := deleteDuplicate(op.Src, op.Dst); err != nil {
+				fmt.Fprintf(status, "Not deleting %s, %s\n", display(op.Dst), err)
+				result.Results = append(result.Results, moveResult{Src: display(op.Src), Dst: display(op.Dst), Error: err.Error()})
+				skipFile(err)
+				continue
+			}
+			fmt.Fprintf(status, "Deleted %s\n", display(op.Dst))
+			result.Results = append(result.Results, moveResult{Src: display(op.Src), Dst: display(op.Dst), OK: true})
+		}
+
+		ops := plan.ops()
+		if len(ops) == 0 {
+			if resultsFile != nil && len(result.Results) > 0 {
+				handleError(writeGroupResult(resultsFile, result))
+			}
+			continue
+		}
+		handleError(moveJournal.begin(groupID+1, ops))
+		// a file that can't be moved stays where it is, which is safe for every op, so the group is finished without it
+		for _, op := range ops {
+			if text && shown {
+				fmt.Fprintln(stdout, display(op.Dst))
+			}
+			if dir := filepath.Dir(op.Dst); preserveStructure && !exists(dir) {
+				guardWrite("create", dir)
+				handleError(os.MkdirAll(longPath(dir), 0755))
+			}
+			guardWrite("move", op.Src)
+			move := renameNoClobber
+			if trashed[op.Dst] {
+				move = bin.put
+			}
+			if err := move(longPath(op.Src), longPath(op.Dst)); err != nil {
+				result.Results = append(result.Results, moveResult{Src: display(op.Src), Dst: display(op.Dst), Error: err.Error()})
+				skipFile(err)
+				continue
+			}
+			result.Results = append(result.Results, moveResult{Src: display(op.Src), Dst: display(op.Dst), OK: true})
+			handleError(moveJournal.moved(groupID+1, op))
+		}
+		handleError(moveJournal.commit(groupID + 1))
+		if resultsFile != nil {
+			handleError(writeGroupResult(resultsFile, result))
+		}
+	}
+	endPhase("move", handledFiles, handledBytes)
+	stopIfInterrupted(fmt.Sprintf("handling %d of %d duplicate groups, the others were left as they are", handled, len(groups)))
+	if pruneEmpty {
+		verb := "Would remove"
+		var remove func(string) error
+		if apply {
+			// the moves are done, what is left on disk is all that counts
+			verb, leaving, arriving = "Removed", nil, nil
+			remove = func(dir string) error {
+				guardWrite("remove", dir)
+				return os.Remove(longPath(dir))
+			}
+		}
+		pruned, errs := pruneEmptyDirs(vacated, roots, leaving, arriving, remove)
+		for _, dir := range pruned {
+			fmt.Fprintf(status, "%s the empty directory %s\n", verb, display(dir))
+		}
+		for _, err := range errs {
+			skipFile(err)
+		}
+	}
+	if hidden := len(groups) - defaultPreviewGroups; text && !allGroups && hidden > 0 {
+		fmt.Fprintf(stdout, "\n... and %s more groups, use -all-groups or -json-out to see everything\n", thousands(hidden))
+	}
+	switch format {
+	case "json":
+		handleError(writeJSONGroups(stdout, report.Groups))
+	case "csv":
+		handleError(writeCSVGroups(stdout, report.Groups))
+	}
+	if dirDedup {
+		fmt.Fprint(stdout, "\nDirectories where every file exists in another directory\n\n")
+		for _, match := range duplicateDirs(fileSizes, duplicates) {
+			if match.Both {
+				fmt.Fprintf(stdout, "%s and %s are identical (%d files)\n", display(match.Dir), display(match.Other), match.Files)
+			} else {
+				fmt.Fprintf(stdout, "%s is contained in %s (%d files)\n", display(match.Dir), display(match.Other), match.Files)
+			}
+		}
+	}
+
+	if reportNearMiss {
+		hashed := make(map[string]bool)
+		for _, path := range candidates {
+			hashed[path] = true
+		}
+		// a file ruled out by its end blocks, or known to be unique from -bloom, has content of its own
+		content := func(path string) (string, bool) {
+			if _, ok := sizes[path]; !ok {
+				return "", false
+			}
+			if sum, ok := hashes[path]; ok {
+				return string(sum), true
+			}
+			return "\x00" + path, !hashed[path]
+		}
+		misses := nearMisses(blockSizes, sameDir, content)
+		fmt.Fprintf(stdout, "\n%s groups of same sized files that aren't all identical\n\n", thousands(len(misses)))
+		for _, miss := range misses {
+			fmt.Fprintf(stdout, "%s, %d different: %s\n", humanBytes(miss.Size), miss.Distinct, strings.Join(displayPaths(miss.Paths, display), ", "))
+		}
+	}
+
+	if reportCaseVariants {
+		fmt.Fprint(stdout, "\nIdentical files that only differ in case and would collide on a case-insensitive filesystem\n\n")
+		for _, variants := range caseVariants(duplicates) {
+			fmt.Fprintln(stdout, strings.Join(displayPaths(variants, display), " and "))
+		}
+	}
+
+	if reportArchives {
+		fmt.Fprint(stdout, "\nArchives with contents that already exist as loose files\n\n")
+		idx := &looseFileIndex{fileSizes: fileSizes, hashes: hashes}
+		for _, paths := range fileSizes {
+			for _, archive := range paths {
+				if !isArchive(archive) {
+					continue
+				}
+				entries, err := archiveEntries(archive)
+				if err != nil {
+					fmt.Fprintf(stdout, " - '%s': %s\n", display(archive), err)
+					continue
+				}
+				found := 0
+				for _, entry := range entries {
+					ok, err := idx.contains(archive, entry)
+					if err != nil {
+						skipFile(err)
+					}
+					if ok {
+						found++
+					}
+				}
+				if found == 0 {
+					continue
+				}
+				if found == len(entries) {
+					fmt.Fprintf(stdout, "%s: all %d files exist elsewhere, the archive looks redundant\n", display(archive), found)
+				} else {
+					fmt.Fprintf(stdout, "%s: %d of %d files exist elsewhere\n", display(archive), found, len(entries))
+				}
+			}
+		}
+	}
+
+	if diffPath != "" {
+		added, resolved := diffGroups(snapshot.Groups, report.Groups)
+		printGroupDiff(stdout, diffPath, added, resolved)
+	}
+
+	if len(skippedFiles) > 0 {
+		fmt.Fprint(status, "\n")
+		printErrorSummary(status, "The following files could not be handled and were left as they are", skippedFiles)
+		scanErrors = append(scanErrors, skippedFiles...)
+	}
+
+	reclaimBytes, reclaimFiles := reclaimableSpace(groups)
+	printSummary(status, runSummary{
+		Found:       seenFiles,
+		Matching:    fileCount,
+		Candidates:  len(sizeCandidates),
+		Groups:      len(groups),
+		Duplicates:  reclaimFiles,
+		Reclaimable: reclaimBytes,
+		ByExtension: reclaimableByExtension(groups),
+	})
+
+	if profile.enabled {
+		printProfile(status, phases)
+	}
+
+	elapsed := time.Since(start).Round(time.Second)
+	if len(groups) == 0 {
+		fmt.Fprintf(status, "\nNo duplicates found among %s files, %s scanned in %s\n", thousands(fileCount), humanBytes(totalBytes), elapsed)
+	} else {
+		fmt.Fprintf(status, "\nFound %s duplicate groups among %s files, %s scanned in %s\n", thousands(len(groups)), thousands(fileCount), humanBytes(totalBytes), elapsed)
+	}
+
+	if metricsURL != "" {
+		m := runMetrics{
+			FilesScanned:    fileCount,
+			BytesScanned:    totalBytes,
+			DuplicateGroups: len(groups),
+			Errors:          len(scanErrors),
+			Duration:        time.Since(start),
+		}
+		for _, group := range groups {
+			m.DuplicateFiles += len(group.Duplicates)
+			m.ReclaimableBytes += group.Size * int64(len(group.Duplicates))
+		}
+		if err := pushMetrics(metricsURL, m); err != nil {
+			fmt.Fprintf(status, "Could not push metrics: %s\n", err)
+		}
+	}
+
+	if jsonOut != "" {
+		handleError(writeJSONReport(jsonOut, report))
+	}
+	if csvOut != "" {
+		handleError(writeCSVReport(csvOut, report))
+	}
+
+	if webhookURL != "" {
+		payload := webhookPayload{FilesScanned: fileCount, DuplicateGroups: len(report.Groups), Groups: report.Groups}
+		for _, group := range groups {
+			payload.DuplicateFiles += len(group.Duplicates)
+			payload.ReclaimableBytes += group.Size * int64(len(group.Duplicates))
+		}
+		if err := postWebhook(webhookURL, payload); err != nil {
+			if webhookRequired {
+				handleError(err)
+			}
+			fmt.Fprintf(status, "Could not deliver the webhook: %s\n", err)
+		}
+	}
+
+	if len(scanErrors) > 0 {
+		return exitSkippedFiles
+	}
+	if len(groups) > 0 && !apply {
+		return exitDuplicatesFound
+	}
+	return 0
+}
+
+// guardWrite exits before a file is changed if the program runs with -read-only
+func guardWrite(op, path string) {
+	if readOnly {
+		handleError(fmt.Errorf("refusing to %s '%s' in read-only mode", op, path))
+	}
+}
+
+// fatalError and exitCode are panicked to end run from anywhere inside it, recoverExit turns them into its exit code
+type fatalError struct{ err error }
+
+type exitCode int
+
+// handleError ends run with exit code 1 if err isn't nil
+func handleError(err error) {
+	if err == nil {
+		return
+	}
+	panic(fatalError{err})
+}
+
+// exit ends run with code, for the places that can't return from it directly
+func exit(code int) {
+	panic(exitCode(code))
+}
+
+// recoverExit is deferred by run to print a fatal error and set its exit code
+func recoverExit(stderr io.Writer, code *int) {
+	switch r := recover().(type) {
+	case nil:
+	case fatalError:
+		fmt.Fprintf(stderr, "Error: '%s'\n", r.err)
+		*code = 1
+	case exitCode:
+		*code = int(r)
+	default:
+		panic(r)
+	}
+}
+
+// walked reports if the absolute path is one of the absolute roots or inside one of them
+func walked(roots []string, path string) bool {
+	for _, root := range roots {
+		if path == root || isInside(path, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// isInside reports if the absolute path is below the absolute directory dir
+func isInside(path, dir string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// uniqueRoots drops the roots that are the same as or inside another root, so that no file is walked twice and
+// mistaken for its own duplicate. Roots are compared at their real path, a root reached through a symlinked directory
+// is the same as the directory itself.
+func uniqueRoots(roots []string, followSymlinks bool) ([]string, error) {
+	var abs []string
+	for _, root := range roots {
+		a, err := canonicalRoot(root, followSymlinks)
+		if err != nil {
+			return nil, err
+		}
+		abs = append(abs, a)
+	}
+	var result []string
+	for i, root := range abs {
+		nested := false
+		for j, other := range abs {
+			if i == j {
+				continue
+			}
+			if isInside(root, other) || (root == other && j < i) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			result = append(result, roots[i])
+		}
+	}
+	return result, nil
+}
+
+// canonicalRoot returns the real path of the directory that walking root scans. A root that is itself a symlink is only
+// walked into with -follow-symlinks or a trailing separator, otherwise just the directories above it are resolved.
+func canonicalRoot(root string, followSymlinks bool) (string, error) {
+	if !followSymlinks && !strings.HasSuffix(root, string(filepath.Separator)) {
+		return canonicalPath(root)
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real, nil
+	}
+	return abs, nil
+}
+
+// canonicalPath returns the absolute path with the symlinks in the directories above it resolved, which is the same
+// for every path a file can be reached by without following a symlink to the file itself. Parts that don't exist are
+// left as they are.
+func canonicalPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(abs))
+	if err != nil {
+		return abs, nil
+	}
+	return filepath.Join(dir, filepath.Base(abs)), nil
+}
+
+// isSnapshotDir reports if a directory name is one that NAS, ZFS, Btrfs and Time Machine use for read-only point in
+// time copies, which should never be deduplicated against the live files
+func isSnapshotDir(name string) bool {
+	switch name {
+	case ".snapshot", ".snapshots", ".zfs", "#snapshot", "Backups.backupdb":
+		return true
+	}
+	return strings.HasPrefix(name, "@GMT-")
+}
+
+// withoutPath returns paths without the first occurrence of path
+func withoutPath(paths []string, path string) []string {
+	for i, p := range paths {
+		if p == path {
+			return append(append([]string(nil), paths[:i]...), paths[i+1:]...)
+		}
+	}
+	return paths
+}
+
+// withoutProtected returns the paths whose base name doesn't match any of the protected names or patterns
+func withoutProtected(paths []string, protected []string) []string {
+	var result []string
+	for _, path := range paths {
+		if !isProtected(path, protected) {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+func isProtected(path string, protected []string) bool {
+	name := filepath.Base(path)
+	for _, pattern := range protected {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutSharedExtents drops the paths that are reflinked copies of original and returns how many were dropped
+func withoutSharedExtents(original string, paths []string) ([]string, int) {
+	var result []string
+	for _, path := range paths {
+		if sharesExtents(original, path) {
+			continue
+		}
+		result = append(result, path)
+	}
+	return result, len(paths) - len(result)
+}
+
+// findGitRoot walks up from path until it finds a directory containing .git
+func findGitRoot(path string) (string, bool) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// relativePath returns path relative to root, or path unchanged if that isn't possible
+func relativePath(root, path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+func displayPaths(paths []string, display func(string) string) []string {
+	result := make([]string, len(paths))
+	for i, path := range paths {
+		result[i] = display(path)
+	}
+	return result
+}
+
+// rejectFields are the values that can be used in a -reject-template
+type rejectFields struct {
+	original  string    // path of the kept original, {name}, {base} and {ext} come from it and {parent} is its directory
+	duplicate string    // path of the duplicate being moved, {dir} is the name of its directory
+	hash      string    // {hash}
+	number    int       // {n}
+	date      time.Time // {date}, when the photo was taken, only set when the template uses it
+}
+
+// copyPath returns where a duplicate is moved to inside dest by expanding the template
+func copyPath(template string, fields rejectFields, dest string) string {
+	base := filepath.Base(fields.original)
+	ext := filepath.Ext(base)
+	copyName := strings.NewReplacer(
+		"{name}", base[:len(base)-len(ext)],
+		"{base}", base[:len(base)-len(ext)],
+		"{ext}", ext,
+		"{parent}", dirName(fields.original),
+		"{date}", fields.date.Format(normalizeDateLayout),
+		"{n}", strconv.Itoa(fields.number),
+		"{hash}", fields.hash,
+		"{dir}", dirName(fields.duplicate),
+	).Replace(template)
+	return filepath.Join(dest, copyName)
+}
+
+// dirName returns the name of the directory path is in, which is empty at the top of a volume so that the name the
+// template is expanded to never contains a separator
+func dirName(path string) string {
+	name := filepath.Base(filepath.Dir(path))
+	if strings.ContainsAny(name, `/\`) || name == "." {
+		return ""
+	}
+	return name
+}
+
+// freeCopyPath works like copyPath but increases the number until it finds a name that isn't already used on disk or
+// in taken, so that a rename never overwrites an existing file. The same name with each of pairExts, for the other
+// half of a live photo, has to be free as well. The returned names are added to taken.
+func freeCopyPath(template string, fields rejectFields, dest string, taken map[string]bool, pairExts ...string) string {
+	isFree := func(location string) bool {
+		_, err := os.Lstat(location)
+		return os.IsNotExist(err) && !taken[location]
+	}
+	for {
+		location := copyPath(template, fields, dest)
+		free := isFree(location)
+		for _, ext := range pairExts {
+			free = free && isFree(withExt(location, ext))
+		}
+		if free {
+			taken[location] = true
+			for _, ext := range pairExts {
+				taken[withExt(location, ext)] = true
+			}
+			return location
+		}
+		fields.number++
+	}
+}
+
+// structuredCopyPath returns where a duplicate goes with -preserve-structure, at its path rel below the scanned root
+// inside dest. The duplicate keeps its name unless that is taken, then the template numbers it like freeCopyPath.
+func structuredCopyPath(template string, fields rejectFields, dest, rel string, taken map[string]bool, pairExts ...string) string {
+	dir := filepath.Join(dest, filepath.Dir(rel))
+	location := filepath.Join(dir, filepath.Base(rel))
+	free := !exists(location) && !taken[location]
+	for _, ext := range pairExts {
+		free = free && !exists(withExt(location, ext)) && !taken[withExt(location, ext)]
+	}
+	if !free {
+		// {name} and {ext} come from the duplicate here since it is the name being kept
+		fields.original = fields.duplicate
+		return freeCopyPath(template, fields, dir, taken, pairExts...)
+	}
+	taken[location] = true
+	for _, ext := range pairExts {
+		taken[withExt(location, ext)] = true
+	}
+	return location
+}
+
+// relativeToRoots returns path relative to the absolute root it is inside of. A path outside every root, like one
+// from -stdin, is relative to the working directory if it is below it and to the top of its volume otherwise.
+func relativeToRoots(roots []string, path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	bases := append([]string(nil), roots...)
+	if wd, err := os.Getwd(); err == nil {
+		bases = append(bases, wd)
+	}
+	for _, root := range bases {
+		if isInside(abs, root) {
+			if rel, err := filepath.Rel(root, abs); err == nil {
+				return rel
+			}
+		}
+	}
+	return strings.TrimLeft(abs[len(filepath.VolumeName(abs)):], string(filepath.Separator))
+}
+
+// withExt replaces the extension of path with ext
+func withExt(path, ext string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ext
+}
+
+// renameNoClobber renames src to dst unless something already exists at dst, which os.Rename would silently replace
+func renameNoClobber(src, dst string) error {
+	if _, err := os.Lstat(dst); !os.IsNotExist(err) {
+		if err == nil {
+			err = os.ErrExist
+		}
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+	}
+	return moveFile(src, dst, os.Rename)
+}
+
+// parseExtensions returns the extensions given to -ext in lower case with a leading dot and without repeats. A spec
+// starting with + adds to the defaults, an empty spec keeps them.
+func parseExtensions(spec string, defaults []string) []string {
+	var result []string
+	if spec == "" || strings.HasPrefix(spec, "+") {
+		result = append(result, defaults...)
+	}
+	return dedupe.NormalizeExtensions(append(result, strings.Split(strings.TrimPrefix(spec, "+"), ",")...))
+}
+
+// extensionSet holds the lower case extensions that are scanned, so that checking a file is a single lookup
+type extensionSet map[string]struct{}
+
+func newExtensionSet(extensions []string) extensionSet {
+	set := make(extensionSet, len(extensions))
+	for _, ext := range extensions {
+		set[strings.ToLower(ext)] = struct{}{}
+	}
+	return set
+}
+
+// matches reports if the extension of path is in the set, whatever its case
+func (s extensionSet) matches(path string) bool {
+	_, ok := s[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// validateRejectFolder makes sure the reject folder is a plain name, so that duplicates always end up next to their
+// original and the scan can recognise and skip them
+func validateRejectFolder(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("reject folder '%s' must be a folder name", name)
+	}
+	if filepath.IsAbs(name) || strings.ContainsRune(name, '/') || strings.ContainsRune(name, filepath.Separator) {
+		return fmt.Errorf("reject folder '%s' can't be a path", name)
+	}
+	return nil
+}
+
+// validateRejectTemplate makes sure the template only uses known placeholders, contains {n} so that names can be made
+// unique and can't produce a path outside the reject folder
+func validateRejectTemplate(template string) error {
+	if !strings.Contains(template, "{n}") {
+		return fmt.Errorf("reject template '%s' must contain {n}", template)
+	}
+	rest := template
+	for _, placeholder := range []string{"{name}", "{base}", "{ext}", "{n}", "{hash}", "{dir}", "{parent}", "{date}"} {
+		rest = strings.Replace(rest, placeholder, "", -1)
+	}
+	if strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("reject template '%s' contains an unknown placeholder", template)
+	}
+	if strings.ContainsRune(template, '/') || strings.ContainsRune(template, filepath.Separator) {
+		return fmt.Errorf("reject template '%s' can't contain path separators", template)
+	}
+	return nil
+}
+
+// fileSum returns the -hash sum of a file. If fewer bytes than the file size could be read, as can happen on some
+// network filesystems, the file is read again a few times before giving up.
+func fileSum(filePath string) (Hash, error) {
+	var sum Hash
+	var err error
+	for attempt := 0; attempt < shortReadRetries; attempt++ {
+		sum, err = fileSumOnce(filePath)
+		if !errors.Is(err, dedupe.ErrShortRead) {
+			break
+		}
+	}
+	return sum, err
+}
+
+func fileSumOnce(filePath string) (Hash, error) {
+	file, err := openFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// a file that can't be mapped is read like any other, the mapping doesn't move the read offset
+	if mmapThreshold > 0 && info.Size() >= mmapThreshold {
+		if sum, err := mmapSum(file.File, info.Size()); err == nil {
+			return sum, nil
+		}
+	}
+
+	buf := readBuffer()
+	defer readBuffers.Put(buf)
+	sum, err := dedupe.ReaderSumBuffer(file, info.Size(), newHash(), *buf)
+	if err != nil {
+		return sum, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return sum, nil
+}
+
+// readBuffers holds the -bufsize buffers of the hash workers between files, so that each file doesn't allocate one
+var readBuffers sync.Pool
+
+// readBuffer returns a buffer of bufferSize bytes from readBuffers, or a new one
+func readBuffer() *[]byte {
+	if buf, ok := readBuffers.Get().(*[]byte); ok && len(*buf) == bufferSize {
+		return buf
+	}
+	buf := make([]byte, bufferSize)
+	return &buf
+}
+
+// sizedKey combines a content hash with the file size, so that two files can only be grouped when both their content
+// hash and their length match. With full file sums this can't change the result, the extra key only matters when the
+// hash doesn't cover every byte of the file.
+func sizedKey(sum Hash, size int64) Hash {
+	hasher := sha1.New()
+	binary.Write(hasher, binary.BigEndian, size)
+	hasher.Write([]byte(sum))
+	return sumOf(hasher)
+}
+
+// endBlocksSHA1Sum returns the SHA1 sum of the first head and last tail bytes of a file, or of the whole file if it
+// isn't larger than both together. Reading both ends separates files that share a long header, like many video
+// formats, with two small reads.
+func endBlocksSHA1Sum(filePath string, size, head, tail int64) (Hash, error) {
+	hasher := sha1.New()
+
+	file, err := openFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.CopyN(hasher, file, head); err != nil && err != io.EOF {
+		return "", err
+	}
+	if size > head {
+		// the blocks never overlap, a file that fits in both is hashed in full
+		offset := size - tail
+		if offset < head {
+			offset = head
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return "", err
+		}
+		if _, err := io.CopyN(hasher, file, size-offset); err != nil && err != io.EOF {
+			return "", err
+		}
+	}
+
+	return sumOf(hasher), nil
+}
+
+func duplicatesBlock(f map[blockKey][]string) []string {
+	var result []string
+	for _, paths := range f {
+		if len(paths) < 2 {
+			continue
+		}
+		result = append(result, paths...)
+	}
+	return result
 }
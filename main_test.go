@@ -1,57 +1,525 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
 
-func TestFileHashes_AddDuplicates(t *testing.T) {
+// videoLikeFiles creates files that share a long identical header but differ at the end, like video clips from the
+// same camera
+func videoLikeFiles(b testing.TB, count int, size int) []string {
+	dir := b.TempDir()
+	header := bytes.Repeat([]byte{0x42}, size/2)
+	var paths []string
+	for i := 0; i < count; i++ {
+		data := append(append([]byte(nil), header...), bytes.Repeat([]byte{byte(i)}, size-len(header))...)
+		path := filepath.Join(dir, fmt.Sprintf("clip_%d.mov", i))
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			b.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func BenchmarkEndBlocksSHA1Sum(b *testing.B) {
+	const size = 8 << 20
+	paths := videoLikeFiles(b, 8, size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, path := range paths {
+			if _, err := endBlocksSHA1Sum(path, size, defaultHeadBlockSize, defaultEndBlockSize); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkFullSum(b *testing.B) {
+	const size = 8 << 20
+	paths := videoLikeFiles(b, 8, size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, path := range paths {
+			if _, err := fileSum(path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestSizedKey_SeparatesLengths(t *testing.T) {
+	sum := Hash("010203")
+	if sizedKey(sum, 100) != sizedKey(sum, 100) {
+		t.Errorf("sizedKey() differs for the same sum and size")
+	}
+	if sizedKey(sum, 100) == sizedKey(sum, 101) {
+		t.Errorf("sizedKey() is the same for different sizes")
+	}
+}
+
+func TestFileSum_Algorithms(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("deduper"), 10000)
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(previous string) { hashAlgorithm = previous }(hashAlgorithm)
+	lengths := map[string]int{"sha1": 40, "sha256": 64, "blake2b": 64}
+	for name, length := range lengths {
+		hashAlgorithm = name
+		a, err := fileSum(filepath.Join(dir, "a.jpg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := fileSum(filepath.Join(dir, "b.jpg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a != b {
+			t.Errorf("fileSum() with %s = %s and %s for identical files", name, a, b)
+		}
+		if len(a) != length {
+			t.Errorf("fileSum() with %s = %d hex digits, want %d", name, len(a), length)
+		}
+	}
+}
 
-	type args struct {
-		h [20]byte
-		p string
+func benchmarkHashFiles(b *testing.B, workers int) {
+	paths := videoLikeFiles(b, 32, 1<<20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for result := range hashFiles(context.Background(), paths, workerCounts{others: workers}, fileSum) {
+			if result.err != nil {
+				b.Fatal(result.err)
+			}
+		}
+	}
+}
+
+func BenchmarkHashFiles_Serial(b *testing.B) { benchmarkHashFiles(b, 1) }
+
+func BenchmarkHashFiles_Parallel(b *testing.B) { benchmarkHashFiles(b, runtime.NumCPU()) }
+
+func TestHashFiles_KeepsOrder(t *testing.T) {
+	var paths []string
+	for i := 0; i < 100; i++ {
+		paths = append(paths, fmt.Sprintf("file_%d.jpg", i))
+	}
+	// later files finish first, the results must still arrive in the order of paths
+	sum := func(path string) (Hash, error) {
+		var i int
+		fmt.Sscanf(path, "file_%d.jpg", &i)
+		time.Sleep(time.Duration(100-i) * time.Microsecond)
+		return Hash(path), nil
+	}
+	i := 0
+	for result := range hashFiles(context.Background(), paths, workerCounts{others: 8}, sum) {
+		if result.path != paths[i] || result.sum != Hash(paths[i]) {
+			t.Errorf("result %d = %s, want %s", i, result.path, paths[i])
+		}
+		i++
+	}
+	if i != len(paths) {
+		t.Errorf("hashFiles() returned %d results, want %d", i, len(paths))
+	}
+}
+
+func TestHashFiles_StopsWhenCancelled(t *testing.T) {
+	var paths []string
+	for i := 0; i < 100; i++ {
+		paths = append(paths, fmt.Sprintf("file_%d.jpg", i))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sum := func(path string) (Hash, error) {
+		time.Sleep(100 * time.Microsecond)
+		return Hash(path), nil
+	}
+
+	i := 0
+	for result := range hashFiles(ctx, paths, workerCounts{others: 4}, sum) {
+		if result.path != paths[i] || result.err != nil {
+			t.Fatalf("result %d = %s, %v, want %s", i, result.path, result.err, paths[i])
+		}
+		i++
+		if i == 10 {
+			cancel()
+		}
+	}
+	// results that were already done may still arrive, but the rest of the files must not be waited for
+	if i < 10 || i == len(paths) {
+		t.Errorf("hashFiles() returned %d results after being cancelled at 10", i)
+	}
+}
+
+func TestHashFiles_CancelWithoutReading(t *testing.T) {
+	var paths []string
+	for i := 0; i < 100; i++ {
+		paths = append(paths, fmt.Sprintf("file_%d.jpg", i))
+	}
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	results := hashFiles(ctx, paths, workerCounts{others: 4}, func(path string) (Hash, error) { return Hash(path), nil })
+	<-results
+	// like the run stopping for -abort-groups the rest of the results are never read, by now the next one is done and
+	// waiting to be delivered
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines are still running after cancelling hashFiles(), want %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHashFiles_WorkersPerDevice(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i < 40; i++ {
+		// every other file is on the device of dir, the rest don't exist and are hashed by the other workers
+		path := filepath.Join(dir, fmt.Sprintf("file_%d.jpg", i))
+		if i%2 == 1 {
+			path = fmt.Sprintf("missing_%d.jpg", i)
+		} else if err := ioutil.WriteFile(path, []byte("photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	workers, err := parseWorkers(dir+"=1,8", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var running, most int
+	sum := func(path string) (Hash, error) {
+		if strings.HasPrefix(path, "missing_") {
+			return Hash(path), nil
+		}
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+		time.Sleep(200 * time.Microsecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return Hash(path), nil
+	}
+	i := 0
+	for result := range hashFiles(context.Background(), paths, workers, sum) {
+		if result.path != paths[i] {
+			t.Errorf("result %d = %s, want %s", i, result.path, paths[i])
+		}
+		i++
+	}
+	if i != len(paths) {
+		t.Errorf("hashFiles() returned %d results, want %d", i, len(paths))
+	}
+	if most != 1 {
+		t.Errorf("%d files of the device with 1 worker were hashed at the same time", most)
+	}
+}
+
+func TestParseWorkers(t *testing.T) {
+	dir := t.TempDir()
+	dev, ok := deviceID(dir)
+	if !ok {
+		t.Skip("devices can't be detected here")
 	}
 	tests := []struct {
-		name     string
-		existing string
-		toAdd    string
-		want     int
+		spec   string
+		others int
+		own    int
 	}{
-		{
-			name:     "simple_add",
-			existing: "",
-			toAdd:    "aaaaaaaaaaaaaaaaaaaa",
-			want:     0,
-		},
-		{
-			name:     "dupe_add",
-			existing: "aaaaaaaaaaaaaaaaaaaa",
-			toAdd:    "aaaaaaaaaaaaaaaaaaaa",
-			want:     1,
-		},
-
-		{
-			name:     "nondupe_add",
-			existing: "aaaaaaaaaaaaaaaaaaaa",
-			toAdd:    "bbbbbbbbbbbbbbbbbbbb",
-			want:     0,
-		},
+		{"", 6, 0},
+		{"3", 3, 0},
+		{dir + "=2", 6, 2},
+		{dir + "=2, 12", 12, 2},
+	}
+	for _, test := range tests {
+		workers, err := parseWorkers(test.spec, 6)
+		if err != nil {
+			t.Errorf("parseWorkers(%q) returned %v", test.spec, err)
+			continue
+		}
+		if workers.others != test.others || workers.devices[dev] != test.own {
+			t.Errorf("parseWorkers(%q) = %d others and %d on the device, want %d and %d", test.spec, workers.others, workers.devices[dev], test.others, test.own)
+		}
+	}
+	for _, spec := range []string{"0", "fast", dir + "=", "=4", filepath.Join(dir, "missing") + "=4"} {
+		if _, err := parseWorkers(spec, 6); err == nil {
+			t.Errorf("parseWorkers(%q) didn't return an error", spec)
+		}
+	}
+}
+
+func TestEndBlocksSHA1Sum_SmallAndOverlappingFiles(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	path := filepath.Join(dir, "small.jpg")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := Hash(fmt.Sprintf("%x", sha1.Sum(data)))
+
+	// smaller than the head, and covered by head and tail together without overlapping
+	for _, blocks := range [][2]int64{{4096, 4096}, {600, 400}, {600, 900}} {
+		got, err := endBlocksSHA1Sum(path, int64(len(data)), blocks[0], blocks[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("endBlocksSHA1Sum() with head %d and tail %d = %s, want the full file sum %s", blocks[0], blocks[1], got, want)
+		}
+	}
+}
+
+func TestParseExtensions(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"", "[.jpg .png]"},
+		{"cr2,.WEBP", "[.cr2 .webp]"},
+		{"+cr2, jpg", "[.jpg .png .cr2]"},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			f := make(map[[20]byte][]string)
-			if tt.existing != "" {
-				var b [20]byte
-				copy(b[:], tt.existing)
-				f[b] = []string{tt.existing}
+		if got := fmt.Sprint(parseExtensions(tt.spec, []string{".jpg", ".png"})); got != tt.want {
+			t.Errorf("parseExtensions(%q) = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestExtensionSet(t *testing.T) {
+	extensions := parseExtensions("+jpg,raw", validExt)
+	set := newExtensionSet(extensions)
+	for _, path := range []string{"a.jpg", "b.JPG", "c.Jpeg", "dir.mov/d.raw", "e.tar.tgz", "f.", "g", ".jpg", "h.jpg.txt", "i.jp", "j.jpgx", "k.rAw"} {
+		want := false
+		for _, ext := range extensions {
+			if strings.ToLower(filepath.Ext(path)) == ext {
+				want = true
 			}
+		}
+		if got := set.matches(path); got != want {
+			t.Errorf("matches(%q) = %t, the extension list gives %t", path, got, want)
+		}
+	}
+}
+
+// syntheticPaths returns n file names with a mix of scanned and skipped extensions in varying case
+func syntheticPaths(n int) []string {
+	exts := []string{".jpg", ".JPG", ".txt", ".heic", ".xmp", ".mov", ".json", ".rar", ""}
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = filepath.Join("photos", strconv.Itoa(i%1000), "IMG_"+strconv.Itoa(i)+exts[i%len(exts)])
+	}
+	return paths
+}
 
-			var x [20]byte
-			copy(x[:], tt.toAdd)
-			f[x] = append(f[x], tt.toAdd)
-			dups := duplicatesSHA1(f)
-			if tt.want != len(dups) {
-				t.Errorf("Duplicates() size = %v, want %v", len(dups), tt.want)
-				t.Errorf("%+v\n", f)
-				t.Errorf("%+v\n", dups)
+func BenchmarkExtensionMatch_Slice(b *testing.B) {
+	paths := syntheticPaths(100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, path := range paths {
+			for _, ext := range validExt {
+				if strings.ToLower(filepath.Ext(path)) == ext {
+					break
+				}
 			}
-		})
+		}
+	}
+}
+
+func BenchmarkExtensionMatch_Map(b *testing.B) {
+	paths := syntheticPaths(100000)
+	set := newExtensionSet(validExt)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, path := range paths {
+			set.matches(path)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		spec string
+		want int64
+	}{
+		{"0", 0},
+		{"1500", 1500},
+		{"500k", 500 << 10},
+		{"1M", 1 << 20},
+		{"2GiB", 2 << 30},
+		{" 3 mb ", 3 << 20},
+	}
+	for _, tt := range tests {
+		if got, err := parseSize(tt.spec); err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.spec, got, err, tt.want)
+		}
+	}
+	for _, spec := range []string{"", "k", "-1", "1X", "1.5M"} {
+		if _, err := parseSize(spec); err == nil {
+			t.Errorf("parseSize(%q) accepted an invalid size", spec)
+		}
+	}
+}
+
+func TestFreeCopyPath_SkipsUsedNames(t *testing.T) {
+	dest := t.TempDir()
+	for _, name := range []string{"1.jpg", "2.mov"} {
+		if err := ioutil.WriteFile(filepath.Join(dest, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	taken := make(map[string]bool)
+	fields := rejectFields{original: "/photos/a.jpg", duplicate: "/photos/copy/a.jpg", number: 1}
+
+	// 1.jpg is on disk and 2.mov would clobber the video of a live photo
+	if got := freeCopyPath("{n}{ext}", fields, dest, taken, ".mov"); got != filepath.Join(dest, "3.jpg") {
+		t.Errorf("freeCopyPath() = %s, want 3.jpg", got)
+	}
+	// another group planning the same name before anything is moved gets the next one
+	fields.original = "/photos/b.jpg"
+	if got := freeCopyPath("{n}{ext}", fields, dest, taken); got != filepath.Join(dest, "2.jpg") {
+		t.Errorf("freeCopyPath() = %s, want 2.jpg", got)
+	}
+	if !taken[filepath.Join(dest, "3.mov")] {
+		t.Errorf("the name of the live photo video wasn't reserved")
+	}
+}
+
+func TestFreeCopyPath_TemplateCollision(t *testing.T) {
+	dest := t.TempDir()
+	taken := make(map[string]bool)
+	// the originals of both groups are in the same directory and the template only keeps their parent and number
+	a := rejectFields{original: "/photos/2019/a.jpg", duplicate: "/backup/a.jpg", number: 1}
+	b := rejectFields{original: "/photos/2019/b.jpg", duplicate: "/backup/b.jpg", number: 1}
+	first := freeCopyPath("{parent}_{n}{ext}", a, dest, taken)
+	second := freeCopyPath("{parent}_{n}{ext}", b, dest, taken)
+	if first != filepath.Join(dest, "2019_1.jpg") || second != filepath.Join(dest, "2019_2.jpg") {
+		t.Errorf("freeCopyPath() = %s and %s, want 2019_1.jpg and 2019_2.jpg", first, second)
+	}
+}
+
+func TestValidateRejectTemplate(t *testing.T) {
+	for template, ok := range map[string]bool{
+		defaultRejectTemplate:              true,
+		"{parent}-{base}_{n}{ext}":         true,
+		"{date}_{n}{ext}":                  true,
+		"{parent}{ext}":                    false,
+		"{n}{extension}":                   false,
+		"{parent}/{n}{ext}":                false,
+		"{n}" + string(filepath.Separator): false,
+	} {
+		if err := validateRejectTemplate(template); (err == nil) != ok {
+			t.Errorf("validateRejectTemplate(%q) = %v, want ok %t", template, err, ok)
+		}
+	}
+}
+
+func TestFileSum_SameForEveryBufferSize(t *testing.T) {
+	defer func(size int) { bufferSize = size }(bufferSize)
+	path := videoLikeFiles(t, 1, 3<<20+123)[0]
+
+	bufferSize = defaultBufferSize
+	want, err := fileSum(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{4093, 64 << 10, 8 << 20} {
+		bufferSize = size
+		if got, err := fileSum(path); err != nil || got != want {
+			t.Errorf("fileSum() with a %d byte buffer = %s, %v, want %s", size, got, err, want)
+		}
+	}
+}
+
+func benchmarkFileSumBuffer(b *testing.B, size int) {
+	defer func(size int) { bufferSize = size }(bufferSize)
+	bufferSize = size
+	path := videoLikeFiles(b, 1, 64<<20)[0]
+	b.SetBytes(64 << 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fileSum(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFileSum_Buffer32K(b *testing.B) { benchmarkFileSumBuffer(b, 32<<10) }
+
+func BenchmarkFileSum_Buffer1M(b *testing.B) { benchmarkFileSumBuffer(b, 1<<20) }
+
+func TestCopyPath(t *testing.T) {
+	dest := filepath.FromSlash("/photos/_Rejected")
+	tests := []struct {
+		template string
+		original string
+		number   int
+		want     string
+	}{
+		{defaultRejectTemplate, "/photos/beach.jpg", 1, "beach_1.jpg"},
+		{defaultRejectTemplate, "/photos/beach", 2, "beach_2"},
+		{defaultRejectTemplate, "/photos/beach.edit.final.jpg", 1, "beach.edit.final_1.jpg"},
+		{defaultRejectTemplate, "/photos/BEACH.JPG", 3, "BEACH_3.JPG"},
+		{defaultRejectTemplate, "/photos/.hidden", 1, "_1.hidden"},
+		{"{dir}-{name}{ext}", "/photos/beach.jpg", 1, "backup-beach.jpg"},
+		{"{hash}{ext}", "/photos/beach.tar.gz", 1, "abc123.gz"},
+		{"{parent}-{base}_{n}{ext}", "/photos/beach.jpg", 2, "photos-beach_2.jpg"},
+		{"{parent}-{base}_{n}{ext}", "/beach.jpg", 1, "-beach_1.jpg"},
+		{"{base}_{date}_{n}{ext}", "/photos/beach.jpg", 1, "beach_20190704_183000_1.jpg"},
+	}
+	for _, tt := range tests {
+		fields := rejectFields{
+			original:  filepath.FromSlash(tt.original),
+			duplicate: filepath.FromSlash("/photos/backup/beach.jpg"),
+			hash:      "abc123",
+			number:    tt.number,
+			date:      time.Date(2019, 7, 4, 18, 30, 0, 0, time.UTC),
+		}
+		if got, want := copyPath(tt.template, fields, dest), filepath.Join(dest, tt.want); got != want {
+			t.Errorf("copyPath(%s, %s, %d) = %s, want %s", tt.template, tt.original, tt.number, got, want)
+		}
+	}
+}
+
+func TestByOriginal(t *testing.T) {
+	groups := []Group{
+		{Original: "/photos/zebra.jpg", Duplicates: []string{"/z.jpg"}},
+		{Original: "/photos/Beach.jpg", Duplicates: []string{"/a/beach.jpg"}},
+		{Original: "/photos/apple.jpg", Duplicates: []string{"/b/apple.jpg"}},
+		{Original: "/photos/beach.jpg", Duplicates: []string{"/c/beach.jpg"}},
+	}
+	sort.Sort(ByOriginal(groups))
+	var got []string
+	for _, group := range groups {
+		got = append(got, group.Original)
+	}
+	// the kept file orders the groups, ignoring case, not the shortest path among the duplicates
+	want := []string{"/photos/apple.jpg", "/photos/Beach.jpg", "/photos/beach.jpg", "/photos/zebra.jpg"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("sort.Sort(ByOriginal) ordered the groups as %q, want %q", got, want)
 	}
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/stojg/deduper/dedupe"
+)
+
+// ManifestEntry records several hashes of one file so it can be checked against catalogs made by other tools
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest lists every scanned file with all of its hashes
+type Manifest struct {
+	Files []ManifestEntry `json:"files"`
+}
+
+// multiHashSum reads the file once and feeds it into every hasher at the same time. Like fileSum it reads the file again
+// when fewer than size bytes could be read, so a file that was still being written isn't recorded with a partial sum.
+func multiHashSum(filePath string, size int64) (ManifestEntry, error) {
+	var entry ManifestEntry
+	var err error
+	for attempt := 0; attempt < shortReadRetries; attempt++ {
+		entry, err = multiHashSumOnce(filePath, size)
+		if !errors.Is(err, dedupe.ErrShortRead) {
+			break
+		}
+	}
+	return entry, err
+}
+
+func multiHashSumOnce(filePath string, size int64) (ManifestEntry, error) {
+	file, err := openFile(filePath)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	defer file.Close()
+
+	sha1Hasher, sha256Hasher := sha1.New(), sha256.New()
+	n, err := io.Copy(io.MultiWriter(sha1Hasher, sha256Hasher), file)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	if n != size {
+		return ManifestEntry{}, fmt.Errorf("%s: %w: hashed %d of %d bytes", filePath, dedupe.ErrShortRead, n, size)
+	}
+	return ManifestEntry{
+		Path:   filePath,
+		Size:   n,
+		SHA1:   string(sumOf(sha1Hasher)),
+		SHA256: string(sumOf(sha256Hasher)),
+	}, nil
+}
+
+func writeManifest(filePath string, manifest Manifest) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
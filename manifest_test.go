@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stojg/deduper/dedupe"
+)
+
+func TestMultiHashSum_ShortRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := ioutil.WriteFile(path, []byte("the same photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := multiHashSum(path, 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Size != 14 || entry.SHA1 == "" || entry.SHA256 == "" {
+		t.Errorf("multiHashSum() = %+v, want the size and both sums", entry)
+	}
+
+	// the walk saw a longer file than can be read now
+	entry, err = multiHashSum(path, 20)
+	if !errors.Is(err, dedupe.ErrShortRead) {
+		t.Fatalf("multiHashSum() with a shorter file returned error %v, want %v", err, dedupe.ErrShortRead)
+	}
+	if entry != (ManifestEntry{}) {
+		t.Errorf("multiHashSum() with a shorter file returned the entry %+v", entry)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// runMetrics are the numbers from a run that are pushed to a Prometheus pushgateway
+type runMetrics struct {
+	FilesScanned     int
+	BytesScanned     int64
+	DuplicateGroups  int
+	DuplicateFiles   int
+	ReclaimableBytes int64
+	Errors           int
+	Duration         time.Duration
+}
+
+// pushMetrics sends the metrics in the Prometheus text format to a pushgateway url, like
+// http://localhost:9091/metrics/job/deduper
+func pushMetrics(url string, m runMetrics) error {
+	var body bytes.Buffer
+	write := func(name, help string, value interface{}) {
+		fmt.Fprintf(&body, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+	write("deduper_files_scanned", "Number of files scanned.", m.FilesScanned)
+	write("deduper_bytes_scanned", "Total size of the scanned files.", m.BytesScanned)
+	write("deduper_duplicate_groups", "Number of groups of identical files.", m.DuplicateGroups)
+	write("deduper_duplicate_files", "Number of files that are duplicates of a kept original.", m.DuplicateFiles)
+	write("deduper_reclaimable_bytes", "Bytes that removing the duplicates would free.", m.ReclaimableBytes)
+	write("deduper_errors", "Number of errors encountered during the scan.", m.Errors)
+	write("deduper_duration_seconds", "How long the run took.", m.Duration.Seconds())
+
+	req, err := http.NewRequest(http.MethodPut, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("pushing metrics to %s failed with %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Only this many bytes at the start of a file are read when sniffing its type
+const sniffLen = 512
+
+// sniffType detects the content type of a file from its first bytes. The formats that http.DetectContentType doesn't
+// know about but that are common in photo libraries, like TIFF based raw files and HEIC, are checked for as well.
+func sniffType(path string) (string, error) {
+	file, err := openFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return "image/tiff", nil // also nef and dng
+	case bytes.HasPrefix(head, []byte("FUJIFILMCCD-RAW")):
+		return "image/x-fuji-raf", nil
+	case bytes.HasPrefix(head, []byte("\x1a\x45\xdf\xa3")):
+		return "video/x-matroska", nil
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		switch string(head[8:12]) {
+		case "heic", "heix", "mif1", "msf1":
+			return "image/heic", nil
+		case "qt  ":
+			return "video/quicktime", nil
+		}
+	}
+	return http.DetectContentType(head), nil
+}
+
+// isMediaType reports if a sniffed content type is one that this program deduplicates
+func isMediaType(contentType string) bool {
+	if strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/") {
+		return true
+	}
+	switch contentType {
+	case "application/zip", "application/x-gzip", "application/x-rar-compressed":
+		return true
+	}
+	return false
+}
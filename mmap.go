@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+)
+
+// defaultMmapThreshold is the size from which -mmap maps files into memory instead of reading them, below it the
+// streaming reads are just as fast
+const defaultMmapThreshold = 64 << 20
+
+// mmapThreshold is the smallest file that is hashed through a memory mapping, 0 reads every file
+var mmapThreshold int64
+
+// errMmapUnsupported is returned by mapFile on platforms that can't map files into memory
+var errMmapUnsupported = errors.New("memory mapping files isn't supported on this platform")
+
+// mmapSum returns the -hash sum of the first size bytes of file by mapping them into memory and hashing the mapping in
+// one go. The mapping is always unmapped again. A file that shrinks while it is hashed makes reading the mapping fault,
+// which is turned into an error instead of crashing the program.
+func mmapSum(file *os.File, size int64) (sum Hash, err error) {
+	if int64(int(size)) != size {
+		return "", fmt.Errorf("%s is too big to be mapped into memory", file.Name())
+	}
+	data, unmap, err := mapFile(file, int(size))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if unmapErr := unmap(); err == nil && unmapErr != nil {
+			err = unmapErr
+		}
+	}()
+
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			sum, err = "", fmt.Errorf("%s: reading the memory mapping failed: %v", file.Name(), r)
+		}
+	}()
+	h := newHash()
+	h.Write(data)
+	return sumOf(h), nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package main
+
+import "os"
+
+func mapFile(file *os.File, size int) ([]byte, func() error, error) {
+	return nil, nil, errMmapUnsupported
+}
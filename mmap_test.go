@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestMmapSumMatchesStreaming(t *testing.T) {
+	defer func(threshold int64, algorithm string) { mmapThreshold, hashAlgorithm = threshold, algorithm }(mmapThreshold, hashAlgorithm)
+	data := make([]byte, 3<<20+17)
+	rand.New(rand.NewSource(1)).Read(data)
+	path := filepath.Join(t.TempDir(), "clip.mov")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for algorithm := range hashAlgorithms {
+		hashAlgorithm = algorithm
+		mmapThreshold = 0
+		streamed, err := fileSum(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := openFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mapped, err := mmapSum(file.File, int64(len(data)))
+		file.Close()
+		if err == errMmapUnsupported {
+			t.Skip(err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mapped != streamed {
+			t.Errorf("%s: the mapped sum %s differs from the streamed %s", algorithm, mapped, streamed)
+		}
+		mmapThreshold = 1
+		if sum, err := fileSum(path); err != nil || sum != streamed {
+			t.Errorf("%s: fileSum() with -mmap = %s, %v, want %s", algorithm, sum, err, streamed)
+		}
+	}
+}
+
+func benchmarkFileSumMmap(b *testing.B, threshold int64) {
+	defer func(threshold int64) { mmapThreshold = threshold }(mmapThreshold)
+	mmapThreshold = threshold
+	path := videoLikeFiles(b, 1, 256<<20)[0]
+	b.SetBytes(256 << 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fileSum(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFileSum_Streaming(b *testing.B) { benchmarkFileSumMmap(b, 0) }
+
+func BenchmarkFileSum_Mmap(b *testing.B) { benchmarkFileSumMmap(b, defaultMmapThreshold) }
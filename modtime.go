@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/stojg/deduper/dedupe"
+)
+
+// reasonTimeLayout is how the times that decided between the files are shown by -explain
+const reasonTimeLayout = "2006-01-02 15:04:05"
+
+// modTimeIdx returns the index of the oldest path, or the newest if newest is set, and the reason for -explain. Paths
+// with the same modification time fall back to the shortest path, like dedupe.ShortestIdx, so the choice doesn't
+// depend on the order of paths.
+func modTimeIdx(paths []string, newest bool) (int, string, error) {
+	times := make([]time.Time, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, "", err
+		}
+		times[i] = info.ModTime()
+	}
+
+	idx := 0
+	for i := 1; i < len(paths); i++ {
+		better := times[i].Before(times[idx])
+		if newest {
+			better = times[i].After(times[idx])
+		}
+		if better || (times[i].Equal(times[idx]) && dedupe.Shorter(paths[i], paths[idx])) {
+			idx = i
+		}
+	}
+	which := "oldest"
+	if newest {
+		which = "newest"
+	}
+	return idx, fmt.Sprintf("%s modification time %s", which, times[idx].Format(reasonTimeLayout)), nil
+}
+
+// exifIdx returns the index of the photo that was taken first according to its EXIF DateTimeOriginal, for -keep exif.
+// Copying a file changes its modification time but not its EXIF data. Photos with a date win over those without one,
+// and photos with the same date, or no date at all, fall back to the oldest modification time and then the shortest
+// path. The reason for -explain is the EXIF date, or the modification time if the kept photo has none.
+func exifIdx(paths []string) (int, string, error) {
+	type when struct {
+		taken  time.Time
+		exif   bool
+		modded time.Time
+	}
+	times := make([]when, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, "", err
+		}
+		times[i].modded = info.ModTime()
+		// a photo that can't be read here still has its modification time to go by
+		if f, err := openFile(path); err == nil {
+			times[i].taken, times[i].exif = exifDate(f)
+			f.Close()
+		}
+	}
+
+	idx := 0
+	for i := 1; i < len(paths); i++ {
+		a, b := times[i], times[idx]
+		var better bool
+		switch {
+		case a.exif != b.exif:
+			better = a.exif
+		case a.exif && !a.taken.Equal(b.taken):
+			better = a.taken.Before(b.taken)
+		case !a.modded.Equal(b.modded):
+			better = a.modded.Before(b.modded)
+		default:
+			better = dedupe.Shorter(paths[i], paths[idx])
+		}
+		if better {
+			idx = i
+		}
+	}
+	if !times[idx].exif {
+		return idx, fmt.Sprintf("oldest modification time %s, no EXIF date", times[idx].modded.Format(reasonTimeLayout)), nil
+	}
+	return idx, fmt.Sprintf("earliest EXIF date %s", times[idx].taken.Format(reasonTimeLayout)), nil
+}
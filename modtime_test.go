@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestModTimeIdx(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	mtimes := map[string]time.Time{
+		"a_long_name.jpg":  base,
+		"b.jpg":            base.Add(time.Hour),
+		"c_newest.jpg":     base.Add(2 * time.Hour),
+		"d_newest_too.jpg": base.Add(2 * time.Hour),
+		"e_oldest_too.jpg": base,
+	}
+	var paths []string
+	for name, mtime := range mtimes {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("same"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	for newest, want := range map[bool]string{false: "a_long_name.jpg", true: "c_newest.jpg"} {
+		i, _, err := modTimeIdx(paths, newest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := filepath.Base(paths[i]); got != want {
+			t.Errorf("modTimeIdx(newest=%t) kept %s, want %s", newest, got, want)
+		}
+	}
+}
+
+// exifJPEG returns a minimal JPEG with an EXIF DateTimeOriginal of date, in the EXIF "2006:01:02 15:04:05" layout
+func exifJPEG(date string) []byte {
+	le := binary.LittleEndian
+	tiff := make([]byte, 44, 64)
+	copy(tiff, "II*\x00")
+	le.PutUint32(tiff[4:], 8)
+	// IFD0 only points to the EXIF IFD at 26, which holds the date at 44
+	le.PutUint16(tiff[8:], 1)
+	le.PutUint16(tiff[10:], 0x8769)
+	le.PutUint16(tiff[12:], 4)
+	le.PutUint32(tiff[14:], 1)
+	le.PutUint32(tiff[18:], 26)
+	le.PutUint16(tiff[26:], 1)
+	le.PutUint16(tiff[28:], 0x9003)
+	le.PutUint16(tiff[30:], 2)
+	le.PutUint32(tiff[32:], 20)
+	le.PutUint32(tiff[36:], 44)
+	tiff = append(append(tiff, date...), 0)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(jpeg[4:], uint16(len(segment)+2))
+	return append(append(jpeg, segment...), 0xFF, 0xD9)
+}
+
+func TestExifIdx(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	files := []struct {
+		name  string
+		date  string // empty for a file without EXIF
+		mtime time.Time
+	}{
+		{"a.jpg", "2019:06:01 12:00:00", base},
+		{"b_taken_first.jpg", "2018:03:04 09:30:00", base.Add(time.Hour)},
+		{"c.jpg", "2020:01:01 00:00:00", base.Add(-time.Hour)},
+		{"d_no_exif.jpg", "", base.Add(-2 * time.Hour)},
+	}
+	var paths []string
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		content := []byte("no exif here")
+		if f.date != "" {
+			content = exifJPEG(f.date)
+		}
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, f.mtime, f.mtime); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	tests := []struct {
+		paths  []int
+		want   string
+		reason string
+	}{
+		{[]int{0, 1, 2, 3}, "b_taken_first.jpg", "earliest EXIF date 2018-03-04 09:30:00"},
+		{[]int{0, 2, 3}, "a.jpg", "earliest EXIF date 2019-06-01 12:00:00"},
+		// without any EXIF date the oldest modification time wins
+		{[]int{3}, "d_no_exif.jpg", "oldest modification time " + at(base.Add(-2*time.Hour)) + ", no EXIF date"},
+	}
+	for _, tt := range tests {
+		var group []string
+		for _, i := range tt.paths {
+			group = append(group, paths[i])
+		}
+		i, reason, err := exifIdx(group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := filepath.Base(group[i]); got != tt.want {
+			t.Errorf("exifIdx(%v) kept %s, want %s", tt.paths, got, tt.want)
+		}
+		if reason != tt.reason {
+			t.Errorf("exifIdx(%v) gave the reason %q, want %q", tt.paths, reason, tt.reason)
+		}
+	}
+}
+
+func TestExifIdx_SameDateFallsBackToModTime(t *testing.T) {
+	dir := t.TempDir()
+	older := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	var paths []string
+	for name, mtime := range map[string]time.Time{"a.jpg": older.Add(time.Hour), "b_copied_first.jpg": older} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, exifJPEG("2019:06:01 12:00:00"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	i, _, err := exifIdx(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := filepath.Base(paths[i]); got != "b_copied_first.jpg" {
+		t.Errorf("exifIdx() kept %s, want the one with the oldest modification time", got)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "os"
+
+// moveFile renames src to dst with rename. Where that fails because dst is on another filesystem, like a reject
+// folder that is a symlink to another mount, the file is copied with its mode and modification time instead and src
+// is only removed once the copy is complete, so a failed copy leaves src as it was.
+func moveFile(src, dst string, rename func(oldpath, newpath string) error) error {
+	err := rename(src, dst)
+	if !isCrossDevice(err) {
+		return err
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies src to dst, which must not exist yet, and gives it the mode and modification time of src. The holes
+// of a sparse src stay holes where the platform can find them.
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+	if err = copyContents(out, in, info.Size()); err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	// the umask may have dropped bits from the mode the file was created with
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+	atime := info.ModTime()
+	if t, ok := accessTime(info); ok {
+		atime = t
+	}
+	return os.Chtimes(dst, atime, info.ModTime())
+}
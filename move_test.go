@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// crossDeviceRename fails like os.Rename does when both paths aren't on the same filesystem
+func crossDeviceRename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+}
+
+func TestMoveFile_CrossDeviceCopies(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "photo.jpg"), filepath.Join(dir, "rejected.jpg")
+	if err := ioutil.WriteFile(src, []byte("the same photo"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := moveFile(src, dst, crossDeviceRename); err != nil {
+		t.Fatalf("moveFile() returned %v", err)
+	}
+	if exists(src) {
+		t.Errorf("%s is still there after the move", src)
+	}
+	if content, err := ioutil.ReadFile(dst); err != nil || string(content) != "the same photo" {
+		t.Errorf("the copy has %q, %v", content, err)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("the copy was modified at %s, want %s", info.ModTime(), mtime)
+	}
+	if info.Mode().Perm() != 0640 && runtime.GOOS != "windows" {
+		t.Errorf("the copy has mode %s, want %s", info.Mode().Perm(), os.FileMode(0640))
+	}
+}
+
+func TestMoveFile_CopyKeepsExistingDestination(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "photo.jpg"), filepath.Join(dir, "rejected.jpg")
+	for path, content := range map[string]string{src: "the same photo", dst: "another photo"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := moveFile(src, dst, crossDeviceRename); !os.IsExist(err) {
+		t.Fatalf("moveFile() returned %v, want an exists error", err)
+	}
+	for path, want := range map[string]string{src: "the same photo", dst: "another photo"} {
+		if content, err := ioutil.ReadFile(path); err != nil || string(content) != want {
+			t.Errorf("%s has %q, %v, want %q", path, content, err, want)
+		}
+	}
+}
+
+func TestMoveFile_OtherErrorsAreReturned(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photo.jpg")
+	if err := ioutil.WriteFile(src, []byte("the same photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	denied := &os.LinkError{Op: "rename", Old: src, New: "dst", Err: syscall.EACCES}
+
+	err := moveFile(src, filepath.Join(dir, "dst"), func(string, string) error { return denied })
+	if !errors.Is(err, syscall.EACCES) {
+		t.Fatalf("moveFile() returned %v, want the rename error", err)
+	}
+	if !exists(src) || exists(filepath.Join(dir, "dst")) {
+		t.Errorf("a failed rename was followed by a copy")
+	}
+}
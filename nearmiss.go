@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// nearMiss is a set of files that share their size but not all their content
+type nearMiss struct {
+	Size     int64
+	Paths    []string
+	Distinct int // how many different contents the files have
+}
+
+// nearMisses returns the size groups in sizeGroups that turned out to hold files that aren't all identical, for
+// -report-nearmiss. content returns what a file's content is known as after the comparison, or false for a file that
+// couldn't be read, which is left out. With sameDir the files of a size are only compared within their directory.
+func nearMisses(sizeGroups map[int64][]string, sameDir bool, content func(path string) (string, bool)) []nearMiss {
+	var result []nearMiss
+	for size, paths := range sizeGroups {
+		groups := [][]string{paths}
+		if sameDir {
+			byDir := make(map[string][]string)
+			for _, path := range paths {
+				byDir[filepath.Dir(path)] = append(byDir[filepath.Dir(path)], path)
+			}
+			groups = groups[:0]
+			for _, dirPaths := range byDir {
+				groups = append(groups, dirPaths)
+			}
+		}
+		for _, group := range groups {
+			var read []string
+			distinct := make(map[string]bool)
+			for _, path := range group {
+				if key, ok := content(path); ok {
+					read = append(read, path)
+					distinct[key] = true
+				}
+			}
+			if len(read) > 1 && len(distinct) > 1 {
+				sort.Strings(read)
+				result = append(result, nearMiss{Size: size, Paths: read, Distinct: len(distinct)})
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Size != result[j].Size {
+			return result[i].Size > result[j].Size
+		}
+		return result[i].Paths[0] < result[j].Paths[0]
+	})
+	return result
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNearMisses(t *testing.T) {
+	sizeGroups := map[int64][]string{
+		10: {"a/same1", "a/same2"},
+		20: {"a/dupe", "a/copy", "b/other"},
+		30: {"a/broken", "b/lonely"},
+	}
+	content := map[string]string{"a/same1": "x", "a/same2": "x", "a/dupe": "y", "a/copy": "y", "b/other": "z", "b/lonely": "w"}
+	lookup := func(path string) (string, bool) {
+		key, ok := content[path]
+		return key, ok
+	}
+
+	want := []nearMiss{{Size: 20, Paths: []string{"a/copy", "a/dupe", "b/other"}, Distinct: 2}}
+	if got := nearMisses(sizeGroups, false, lookup); !reflect.DeepEqual(got, want) {
+		t.Errorf("nearMisses() = %v, want %v", got, want)
+	}
+	// within each directory the files of size 20 are either identical or alone
+	if got := nearMisses(sizeGroups, true, lookup); len(got) != 0 {
+		t.Errorf("nearMisses() with sameDir = %v, want none", got)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exifDateLayout is how EXIF stores DateTimeOriginal and DateTime
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// normalizeDateLayout is what {date} expands to in a -normalize-originals template
+const normalizeDateLayout = "20060102_150405"
+
+// validateNormalizeTemplate makes sure the template only uses known placeholders, contains {seq} so that names taken
+// on the same date can be told apart and keeps the original in its own directory
+func validateNormalizeTemplate(template string) error {
+	if !strings.Contains(template, "{seq}") {
+		return fmt.Errorf("normalize template '%s' must contain {seq}", template)
+	}
+	rest := template
+	for _, placeholder := range []string{"{date}", "{seq}", "{name}", "{ext}"} {
+		rest = strings.Replace(rest, placeholder, "", -1)
+	}
+	if strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("normalize template '%s' contains an unknown placeholder", template)
+	}
+	if strings.ContainsRune(template, '/') || strings.ContainsRune(template, filepath.Separator) {
+		return fmt.Errorf("normalize template '%s' can't contain path separators", template)
+	}
+	return nil
+}
+
+// normalizedPath returns the new name of a kept original in its own directory. The sequence number is increased until
+// the name isn't used on disk or in taken, the returned name is added to taken. If the original already has the name
+// it would get, its own path is returned.
+func normalizedPath(template, original string, date time.Time, taken map[string]bool) string {
+	ext := filepath.Ext(original)
+	name := strings.TrimSuffix(filepath.Base(original), ext)
+	for seq := 1; ; seq++ {
+		location := filepath.Join(filepath.Dir(original), strings.NewReplacer(
+			"{date}", date.Format(normalizeDateLayout),
+			"{seq}", strconv.Itoa(seq),
+			"{name}", name,
+			"{ext}", strings.ToLower(ext),
+		).Replace(template))
+		if location == original {
+			return location
+		}
+		if _, err := os.Lstat(location); os.IsNotExist(err) && !taken[location] {
+			taken[location] = true
+			return location
+		}
+	}
+}
+
+// captureDate returns when a photo was taken according to its EXIF data, falling back on the modification time for
+// files without it
+func captureDate(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	f, err := openFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	if date, ok := exifDate(f); ok {
+		return date, nil
+	}
+	return info.ModTime(), nil
+}
+
+// exifDate looks for the DateTimeOriginal, or else DateTime, tag in the EXIF segment of a JPEG
+func exifDate(r io.Reader) (time.Time, bool) {
+	tiff, ok := jpegExif(r)
+	if !ok || len(tiff) < 8 {
+		return time.Time{}, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, false
+	}
+	ifd0 := order.Uint32(tiff[4:8])
+	if ptr, ok := ifdEntry(tiff, order, ifd0, 0x8769); ok {
+		if date, ok := ifdDate(tiff, order, order.Uint32(ptr), 0x9003); ok {
+			return date, true
+		}
+	}
+	return ifdDate(tiff, order, ifd0, 0x0132)
+}
+
+// jpegExif returns the TIFF structure from the APP1 Exif segment of a JPEG
+func jpegExif(r io.Reader) ([]byte, bool) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, false
+	}
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return nil, false
+		}
+		// start of scan, the metadata segments always come before the image data
+		if marker[1] == 0xDA {
+			return nil, false
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return nil, false
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, false
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], true
+		}
+	}
+}
+
+// ifdEntry returns the four value bytes of a tag in the image file directory at offset
+func ifdEntry(tiff []byte, order binary.ByteOrder, offset uint32, tag uint16) ([]byte, bool) {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil, false
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := uint64(offset) + 2 + uint64(i)*12
+		if entry+12 > uint64(len(tiff)) {
+			return nil, false
+		}
+		if order.Uint16(tiff[entry:]) == tag {
+			return tiff[entry+8 : entry+12], true
+		}
+	}
+	return nil, false
+}
+
+// ifdDate parses an ASCII date tag in the image file directory at offset
+func ifdDate(tiff []byte, order binary.ByteOrder, offset uint32, tag uint16) (time.Time, bool) {
+	value, ok := ifdEntry(tiff, order, offset, tag)
+	if !ok {
+		return time.Time{}, false
+	}
+	start := uint64(order.Uint32(value))
+	end := start + uint64(len(exifDateLayout))
+	if end > uint64(len(tiff)) {
+		return time.Time{}, false
+	}
+	date, err := time.ParseInLocation(exifDateLayout, string(tiff[start:end]), time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
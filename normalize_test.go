@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNormalizedPath_Collisions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "normalize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "20210501_102030_1.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Date(2021, 5, 1, 10, 20, 30, 0, time.Local)
+	taken := make(map[string]bool)
+	first := normalizedPath("{date}_{seq}{ext}", filepath.Join(dir, "IMG_1.JPG"), date, taken)
+	second := normalizedPath("{date}_{seq}{ext}", filepath.Join(dir, "IMG_2.JPG"), date, taken)
+
+	if want := filepath.Join(dir, "20210501_102030_2.jpg"); first != want {
+		t.Errorf("first original = %s, want %s", first, want)
+	}
+	if want := filepath.Join(dir, "20210501_102030_3.jpg"); second != want {
+		t.Errorf("second original = %s, want %s", second, want)
+	}
+}
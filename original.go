@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkOriginal makes sure original is still the file that was hashed before its duplicates are moved, so that a file
+// that was moved, deleted or changed since the scan doesn't leave only the moved copies behind. It has to be a regular
+// file of size bytes, and with a sum it is hashed again and has to still have it.
+func checkOriginal(original string, size int64, sum Hash) error {
+	info, err := os.Stat(original)
+	if err != nil {
+		return fmt.Errorf("the original is gone: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("the original %s isn't a regular file anymore", original)
+	}
+	if info.Size() != size {
+		return fmt.Errorf("the original %s changed from %s to %s since it was hashed", original, humanBytes(size), humanBytes(info.Size()))
+	}
+	if sum == "" {
+		return nil
+	}
+	now, err := fileSum(original)
+	if err != nil {
+		return fmt.Errorf("the original can't be hashed again: %w", err)
+	}
+	if now != sum {
+		return fmt.Errorf("the content of the original %s changed since it was hashed", original)
+	}
+	return nil
+}
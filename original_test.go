@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckOriginal(t *testing.T) {
+	defer func(algorithm string) { hashAlgorithm = algorithm }(hashAlgorithm)
+	hashAlgorithm = "sha1"
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := ioutil.WriteFile(path, []byte("the same photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := fileSum(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkOriginal(path, 14, sum); err != nil {
+		t.Errorf("an unchanged original was rejected: %s", err)
+	}
+	if err := checkOriginal(path, 15, ""); err == nil {
+		t.Error("an original with another size was accepted")
+	}
+	if err := ioutil.WriteFile(path, []byte("another photo!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkOriginal(path, 14, ""); err != nil {
+		t.Errorf("without a sum only the size should be checked: %s", err)
+	}
+	if err := checkOriginal(path, 14, sum); err == nil {
+		t.Error("an original with changed content was accepted")
+	}
+	if err := checkOriginal(path+".gone", 14, ""); err == nil {
+		t.Error("a missing original was accepted")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+)
+
+// outputFile is where -output sends what would go to stdout. It is written to path.tmp and only renamed over path once
+// the run finished, so a run that fails halfway leaves an existing file as it was.
+type outputFile struct {
+	*os.File
+	path string
+}
+
+func createOutput(path string) (*outputFile, error) {
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &outputFile{File: file, path: path}, nil
+}
+
+// finish closes the file and puts it in place when ok is set, or removes it otherwise. It does nothing without
+// -output.
+func (o *outputFile) finish(ok bool) error {
+	if o == nil {
+		return nil
+	}
+	err := o.File.Close()
+	if !ok || err != nil {
+		os.Remove(o.File.Name())
+		return err
+	}
+	return os.Rename(o.File.Name(), o.path)
+}
@@ -0,0 +1,23 @@
+package main
+
+import "path/filepath"
+
+// preferredPaths returns the paths inside one of the -prefer directories, prefixes and paths are compared as absolute
+// paths so that either can be given relative to the working directory
+func preferredPaths(paths, prefixes []string) []string {
+	var result []string
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		for _, prefix := range prefixes {
+			dir, err := filepath.Abs(prefix)
+			if err == nil && (abs == dir || isInside(abs, dir)) {
+				result = append(result, path)
+				break
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stojg/deduper/dedupe"
+)
+
+func TestPreferredPaths(t *testing.T) {
+	originals := filepath.FromSlash("/home/me/Photos/Originals")
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{
+			name:  "match",
+			paths: []string{"/tmp/a.jpg", "/home/me/Photos/Originals/2020/long_name.jpg"},
+			want:  []string{"/home/me/Photos/Originals/2020/long_name.jpg"},
+		},
+		{
+			name:  "no match",
+			paths: []string{"/tmp/a.jpg", "/home/me/Photos/OriginalsOld/a.jpg"},
+		},
+		{
+			name:  "multiple matches",
+			paths: []string{"/home/me/Photos/Originals/2020/beach.jpg", "/tmp/a.jpg", "/home/me/Photos/Originals/a.jpg"},
+			want:  []string{"/home/me/Photos/Originals/2020/beach.jpg", "/home/me/Photos/Originals/a.jpg"},
+		},
+	}
+	for _, tt := range tests {
+		var paths, want []string
+		for _, path := range tt.paths {
+			paths = append(paths, filepath.FromSlash(path))
+		}
+		for _, path := range tt.want {
+			want = append(want, filepath.FromSlash(path))
+		}
+		got := preferredPaths(paths, []string{originals})
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: preferredPaths() = %q, want %q", tt.name, got, want)
+		}
+		// several matches fall back to the shortest of them like the paths without -prefer
+		if len(got) > 1 && got[dedupe.ShortestIdx(got)] != filepath.FromSlash("/home/me/Photos/Originals/a.jpg") {
+			t.Errorf("%s: the shortest preferred path wasn't picked from %q", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime/pprof"
+	"text/tabwriter"
+	"time"
+)
+
+// profileFlag is the -profile flag. Given on its own it prints how long each phase took, given a path like
+// -profile=cpu.out it also writes a CPU profile there for go tool pprof.
+type profileFlag struct {
+	enabled bool
+	cpuPath string
+}
+
+func (p *profileFlag) String() string {
+	if p == nil || !p.enabled {
+		return "false"
+	}
+	if p.cpuPath != "" {
+		return p.cpuPath
+	}
+	return "true"
+}
+
+func (p *profileFlag) Set(value string) error {
+	switch value {
+	case "true":
+		p.enabled, p.cpuPath = true, ""
+	case "false":
+		p.enabled, p.cpuPath = false, ""
+	default:
+		p.enabled, p.cpuPath = true, value
+	}
+	return nil
+}
+
+// IsBoolFlag lets -profile be given without a path
+func (p *profileFlag) IsBoolFlag() bool {
+	return true
+}
+
+// startCPUProfile starts writing a CPU profile to path, the returned function stops it and closes the file
+func startCPUProfile(path string) (func() error, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() error {
+		pprof.StopCPUProfile()
+		return f.Close()
+	}, nil
+}
+
+// phaseTiming is how long one phase of a run took and how much it got through
+type phaseTiming struct {
+	Name     string
+	Duration time.Duration
+	Files    int
+	Bytes    int64 // the size of the files the walk found, read from disk while comparing, or moved
+}
+
+// printProfile writes the timings of the phases as an aligned block for -profile
+func printProfile(w io.Writer, phases []phaseTiming) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "\nProfile\n")
+	for _, phase := range phases {
+		filesRate, bytesRate := "-", "-"
+		if seconds := phase.Duration.Seconds(); seconds > 0 {
+			filesRate = fmt.Sprintf("%.0f files/s", float64(phase.Files)/seconds)
+			bytesRate = humanBytes(int64(float64(phase.Bytes)/seconds)) + "/s"
+		}
+		took := phase.Duration.Round(time.Millisecond)
+		if phase.Duration < time.Second {
+			took = phase.Duration.Round(time.Microsecond)
+		}
+		fmt.Fprintf(tw, "  %s:\t%s\t%s files\t%s\t%s\t%s\n", phase.Name, took, thousands(phase.Files), humanBytes(phase.Bytes), filesRate, bytesRate)
+	}
+	tw.Flush()
+}
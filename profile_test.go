@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfileFlag(t *testing.T) {
+	for args, want := range map[string]profileFlag{"": {}, "-profile": {enabled: true}, "-profile=cpu.out": {enabled: true, cpuPath: "cpu.out"}} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		var p profileFlag
+		fs.Var(&p, "profile", "")
+		if err := fs.Parse(strings.Fields(args)); err != nil {
+			t.Fatalf("parsing %q: %v", args, err)
+		}
+		if p != want {
+			t.Errorf("%q gave %+v, want %+v", args, p, want)
+		}
+	}
+}
+
+func TestPrintProfile(t *testing.T) {
+	var out bytes.Buffer
+	printProfile(&out, []phaseTiming{
+		{Name: "walk", Duration: 2 * time.Second, Files: 1000, Bytes: 4 << 20},
+		{Name: "move", Files: 0},
+	})
+	for _, want := range []string{"Profile\n", "walk:", "2s", "1,000 files", "500 files/s", "2.0 MiB/s", "move:"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("profile doesn't have %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// ProgressChars are the characters the ProgressPrinter prints for an entry
+type ProgressChars struct {
+	OK   rune
+	Dupe rune
+	Err  rune
+}
+
+var defaultProgressChars = ProgressChars{OK: '.', Dupe: 'd', Err: 'e'}
+
+// parseProgressChar returns the single character in s
+func parseProgressChar(flagName, s string) (rune, error) {
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("-%s must be a single character, got '%s'", flagName, s)
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return r, nil
+}
+
+// ProgressPrinter will print a progress counter and if Total is set a percentage of how far the along the work has gone.
+// It is safe to use from several goroutines.
+type ProgressPrinter struct {
+	Total int           // the total number of entries that will be printed, zero if unknown
+	Plain bool          // print the OK character for every entry, even for duplicates and errors
+	Chars ProgressChars // characters to print, defaults to defaultProgressChars
+	Out   io.Writer     // where the progress is written, defaults to stderr
+
+	Events *eventSink // optional sink that also receives every update as an event
+	Phase  string     // name of the phase reported in events
+
+	Bar   bool // redraw a single line with a bar and the time left instead of printing a character per entry
+	Width int  // columns of the terminal the dots wrap in, zero if unknown
+
+	mu        sync.Mutex
+	current   int
+	lineCount int
+	started   time.Time
+	drawn     time.Time
+}
+
+func (p *ProgressPrinter) Err() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inc()
+	p.Events.emit(event{Event: "progress", Phase: p.Phase, Current: p.current, Total: p.Total, Error: true})
+	if p.Bar {
+		p.drawBar()
+		return
+	}
+	chars := p.chars()
+	if p.Plain {
+		fmt.Fprint(p.out(), string(chars.OK))
+		return
+	}
+	fmt.Fprint(p.out(), string(chars.Err))
+}
+
+func (p *ProgressPrinter) Print(dupe bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inc()
+	p.Events.emit(event{Event: "progress", Phase: p.Phase, Current: p.current, Total: p.Total, Dupe: dupe})
+	if p.Bar {
+		p.drawBar()
+		return
+	}
+	chars := p.chars()
+	if dupe && !p.Plain {
+		fmt.Fprint(p.out(), string(chars.Dupe))
+	} else {
+		fmt.Fprint(p.out(), string(chars.OK))
+	}
+}
+
+// Done draws the bar a last time for phases without a Total, where no entry is known to be the last one
+func (p *ProgressPrinter) Done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Bar && p.current > 0 {
+		p.drawn = time.Time{}
+		p.drawBar()
+	}
+}
+
+func (p *ProgressPrinter) inc() {
+	if p.Bar {
+		if p.current == 0 {
+			p.started = time.Now()
+		}
+		p.current++
+		return
+	}
+	if p.lineCount == wrapColumn(p.Width) || p.lineCount == 0 {
+		if p.Total == 0 {
+			fmt.Fprintf(p.out(), "\n   ")
+		} else {
+			fmt.Fprintf(p.out(), "\n%2.0f%% ", float32(p.current)/float32(p.Total)*100)
+		}
+		p.lineCount = 0
+	}
+	p.current++
+	p.lineCount++
+}
+
+// defaultWrapColumn is how many characters are printed on a line when the width of the terminal isn't known
+const defaultWrapColumn = 77
+
+// progressPrefixWidth is how wide the percentage at the start of each line is at most, like "100% "
+const progressPrefixWidth = 5
+
+// wrapColumn returns how many characters fit on a line after the percentage in a terminal that is width columns wide.
+// The last column is left empty since some terminals already wrap once it is written to.
+func wrapColumn(width int) int {
+	if width <= 0 {
+		return defaultWrapColumn
+	}
+	if columns := width - progressPrefixWidth - 1; columns > 0 {
+		return columns
+	}
+	return 1
+}
+
+// terminalWidth returns the number of columns of the terminal w writes to, or zero if it isn't one
+func terminalWidth(w io.Writer) int {
+	f, ok := w.(*os.File)
+	if !ok || !isTerminal(w) {
+		return 0
+	}
+	width, _, err := term.GetSize(int(f.Fd()))
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
+func (p *ProgressPrinter) out() io.Writer {
+	if p.Out == nil {
+		return os.Stderr
+	}
+	return p.Out
+}
+
+func (p *ProgressPrinter) chars() ProgressChars {
+	if p.Chars == (ProgressChars{}) {
+		return defaultProgressChars
+	}
+	return p.Chars
+}
+
+// barRedraw is how often the bar is redrawn at most, drawing it for every file would flood slow terminals
+const barRedraw = 100 * time.Millisecond
+
+// barWidth is the number of cells in the bar
+const barWidth = 30
+
+// drawBar redraws the bar line, at most every barRedraw and always for the last entry
+func (p *ProgressPrinter) drawBar() {
+	now := time.Now()
+	if now.Sub(p.drawn) < barRedraw && p.current != p.Total {
+		return
+	}
+	p.drawn = now
+	fmt.Fprintf(p.out(), "\r%s", barLine(p.current, p.Total, now.Sub(p.started)))
+}
+
+// barLine is the bar for current out of total entries done after elapsed. Without a total there is nothing to fill or
+// count down to, so it only shows the count and the rate.
+func barLine(current, total int, elapsed time.Duration) string {
+	rate, left := estimate(current, total, elapsed)
+	if total == 0 {
+		return fmt.Sprintf("%s files  %.0f files/s", thousands(current), rate)
+	}
+	filled := current * barWidth / total
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", barWidth-filled)
+	eta := "ETA --"
+	if current > 0 {
+		eta = fmt.Sprintf("ETA %s", left.Round(time.Second))
+	}
+	return fmt.Sprintf("[%s] %3.0f%%  %s/%s files  %s  ", bar, float64(current)/float64(total)*100, thousands(current), thousands(total), eta)
+}
+
+// estimate returns how many entries per second were done so far and how long the rest of total will take at that rate
+func estimate(current, total int, elapsed time.Duration) (float64, time.Duration) {
+	if current == 0 || elapsed <= 0 {
+		return 0, 0
+	}
+	rate := float64(current) / elapsed.Seconds()
+	if total <= current {
+		return rate, 0
+	}
+	return rate, time.Duration(float64(total-current) / rate * float64(time.Second))
+}
+
+// isTerminal reports if w is a terminal, the bar is only drawn on one since redrawing a line doesn't work in a log file
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// useProgressBar reports if the -progress mode draws a bar on out, a bar falls back to dots when out isn't a terminal
+func useProgressBar(mode string, out io.Writer) (bool, error) {
+	switch mode {
+	case "dots":
+		return false, nil
+	case "bar":
+		return isTerminal(out), nil
+	}
+	return false, fmt.Errorf("unknown -progress '%s', use dots or bar", mode)
+}
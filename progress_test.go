@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		current, total int
+		elapsed        time.Duration
+		rate           float64
+		left           time.Duration
+	}{
+		{25, 100, 10 * time.Second, 2.5, 30 * time.Second},
+		{50, 100, time.Minute, 50.0 / 60, time.Minute},
+		{100, 100, 4 * time.Second, 25, 0},
+		{0, 100, time.Second, 0, 0},
+		{10, 0, 2 * time.Second, 5, 0},
+	}
+	for _, test := range tests {
+		rate, left := estimate(test.current, test.total, test.elapsed)
+		if rate != test.rate || left != test.left {
+			t.Errorf("estimate(%d, %d, %s) = %v, %s, want %v, %s", test.current, test.total, test.elapsed, rate, left, test.rate, test.left)
+		}
+	}
+}
+
+func TestBarLine(t *testing.T) {
+	line := barLine(1500, 3000, 30*time.Second)
+	for _, want := range []string{"[" + strings.Repeat("#", barWidth/2) + strings.Repeat("-", barWidth/2) + "]", " 50%", "1,500/3,000 files", "ETA 30s"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("barLine() = %q, want it to contain %q", line, want)
+		}
+	}
+	if line := barLine(0, 3000, 0); !strings.Contains(line, "ETA --") {
+		t.Errorf("barLine() before the first file = %q, want no estimate", line)
+	}
+}
+
+func TestWrapColumn(t *testing.T) {
+	for width, want := range map[int]int{0: defaultWrapColumn, -1: defaultWrapColumn, 80: 74, 120: 114, 40: 34, 6: 1, 3: 1} {
+		if got := wrapColumn(width); got != want {
+			t.Errorf("wrapColumn(%d) = %d, want %d", width, got, want)
+		}
+	}
+}
+
+func TestProgressPrinter_WrapsAtWidth(t *testing.T) {
+	var out bytes.Buffer
+	p := &ProgressPrinter{Total: 1000, Out: &out, Width: 40}
+	for i := 0; i < 1000; i++ {
+		p.Print(false)
+	}
+	for _, line := range strings.Split(strings.TrimPrefix(out.String(), "\n"), "\n") {
+		if len(line) >= 40 {
+			t.Fatalf("line %q is %d characters wide, want less than 40", line, len(line))
+		}
+	}
+}
+
+func TestTerminalWidth_NotATerminal(t *testing.T) {
+	if got := terminalWidth(&bytes.Buffer{}); got != 0 {
+		t.Errorf("terminalWidth() of a buffer = %d, want 0", got)
+	}
+}
+
+func TestUseProgressBar_FallsBackWithoutTerminal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, err := os.Create(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	for _, out := range []io.Writer{&bytes.Buffer{}, file, ioutil.Discard} {
+		bar, err := useProgressBar("bar", out)
+		if err != nil || bar {
+			t.Errorf("useProgressBar(bar, %T) = %t, %v, want the dot fallback", out, bar, err)
+		}
+	}
+	if _, err := useProgressBar("spinner", file); err == nil {
+		t.Error("useProgressBar() accepted an unknown mode")
+	}
+}
+
+func TestProgressPrinter_Bar(t *testing.T) {
+	var out bytes.Buffer
+	p := &ProgressPrinter{Total: 3, Out: &out, Bar: true}
+	p.Print(false)
+	p.Print(true)
+	p.Err()
+	lines := strings.Split(out.String(), "\r")
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, "[") || strings.Contains(line, "\n") {
+			t.Errorf("the bar printed %q, want only redrawn bars", out.String())
+		}
+	}
+	if last := lines[len(lines)-1]; !strings.Contains(last, "100%  3/3 files") {
+		t.Errorf("the last bar = %q, want it complete", last)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// pruneEmptyDirs finds the directories that are empty once the files in leaving are gone, for -prune-empty. It starts
+// at dirs, the directories files were moved out of, and goes up through their parents, deepest first, so a directory
+// that only held directories that are pruned is pruned too. Only directories below one of the roots are looked at,
+// never a root itself. A directory that files arrive in, or a directory above it, is never empty.
+//
+// After the moves leaving and arriving are empty since the filesystem already shows what is there. For a dry run they
+// hold the planned moves, and with a nil remove the directories are only reported.
+func pruneEmptyDirs(dirs, roots []string, leaving, arriving map[string]bool, remove func(string) error) ([]string, []error) {
+	var absRoots []string
+	for _, root := range roots {
+		if abs, err := filepath.Abs(root); err == nil {
+			absRoots = append(absRoots, abs)
+		}
+	}
+	belowRoot := func(dir string) bool {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return false
+		}
+		for _, root := range absRoots {
+			if isInside(abs, root) {
+				return true
+			}
+		}
+		return false
+	}
+
+	candidates := make(map[string]bool)
+	for _, dir := range dirs {
+		for ; belowRoot(dir) && !candidates[dir]; dir = filepath.Dir(dir) {
+			candidates[dir] = true
+		}
+	}
+	var order []string
+	for dir := range candidates {
+		order = append(order, dir)
+	}
+	// a directory is longer than its parent, so every directory comes before the one it is in
+	sort.Slice(order, func(i, j int) bool {
+		if len(order[i]) != len(order[j]) {
+			return len(order[i]) > len(order[j])
+		}
+		return order[i] < order[j]
+	})
+
+	var pruned []string
+	var errs []error
+	gone := make(map[string]bool)
+	for _, dir := range order {
+		if arriving[dir] || !emptyWithout(dir, leaving, gone) {
+			continue
+		}
+		if remove != nil {
+			if err := remove(dir); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		gone[dir] = true
+		pruned = append(pruned, dir)
+	}
+	return pruned, errs
+}
+
+// emptyWithout reports if dir exists and every entry in it is in one of the sets
+func emptyWithout(dir string, sets ...map[string]bool) bool {
+	f, err := os.Open(dir)
+	if err != nil {
+		return false
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return false
+	}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		found := false
+		for _, set := range sets {
+			found = found || set[path]
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// withParents adds dir and every directory above it to set
+func withParents(set map[string]bool, dir string) {
+	for !set[dir] {
+		set[dir] = true
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPruneEmptyDirs(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a/b/moved.jpg", "a/kept.jpg", "c/d/moved.jpg", "e/moved.jpg", "e/original.jpg"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	join := func(name string) string { return filepath.Join(root, filepath.FromSlash(name)) }
+	dirs := []string{join("a/b"), join("c/d"), join("e")}
+	leaving := map[string]bool{join("a/b/moved.jpg"): true, join("c/d/moved.jpg"): true, join("e/moved.jpg"): true}
+	arriving := make(map[string]bool)
+	// e/moved.jpg goes into a reject folder next to e/original.jpg that doesn't exist yet
+	withParents(arriving, join("e/_Rejected"))
+
+	// a dry run only reports, a keeps its other file and c is left empty as well once c/d is gone
+	want := []string{join("a/b"), join("c/d"), join("c")}
+	pruned, errs := pruneEmptyDirs(dirs, []string{root}, leaving, arriving, nil)
+	if !reflect.DeepEqual(pruned, want) || len(errs) > 0 {
+		t.Errorf("pruneEmptyDirs() in a dry run = %q, %v, want %q", pruned, errs, want)
+	}
+	if !exists(join("a/b/moved.jpg")) {
+		t.Fatalf("a dry run removed a file")
+	}
+
+	for path := range leaving {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// after the moves e is empty too now that nothing arrived in it, the root is never pruned
+	want = []string{join("a/b"), join("c/d"), join("c"), join("e")}
+	if err := os.Remove(join("e/original.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	pruned, errs = pruneEmptyDirs(append(dirs, root), []string{root}, nil, nil, os.Remove)
+	if !reflect.DeepEqual(pruned, want) || len(errs) > 0 {
+		t.Errorf("pruneEmptyDirs() = %q, %v, want %q", pruned, errs, want)
+	}
+	for _, dir := range want {
+		if exists(dir) {
+			t.Errorf("%s wasn't removed", dir)
+		}
+	}
+	if !exists(join("a/kept.jpg")) || !exists(root) {
+		t.Errorf("a directory that wasn't empty was removed")
+	}
+}
+
+func TestPruneEmptyDirs_OutsideRoots(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	root := filepath.Join(dir, "root")
+	for _, d := range []string{outside, root} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pruned, _ := pruneEmptyDirs([]string{outside, root}, []string{root}, nil, nil, os.Remove)
+	if len(pruned) > 0 || !exists(outside) || !exists(root) {
+		t.Errorf("pruneEmptyDirs() removed %q, want nothing outside of or at the root", pruned)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// rawExtensions are the camera RAW formats that -raw-jpeg pairs with a JPEG of the same name
+var rawExtensions = []string{".nef", ".raf", ".dng", ".cr2", ".cr3", ".arw", ".orf", ".rw2", ".pef", ".srw"}
+
+// rawJPEGPair is a RAW file and the JPEG the camera saved next to it from the same shot
+type rawJPEGPair struct {
+	RAW  string
+	JPEG string
+}
+
+// rawJPEGPairs returns the RAW and JPEG files in fileSizes that are in the same directory and have the same name apart
+// from the extension and its case, ordered by the RAW path. A name with several RAW files, like a .nef and a .dng, is
+// left alone since it isn't clear which one the JPEG belongs to, a name with several JPEGs pairs each with the RAW.
+func rawJPEGPairs(fileSizes map[int64][]string) []rawJPEGPair {
+	rawExt := newExtensionSet(rawExtensions)
+	raws := make(map[string][]string)
+	jpegs := make(map[string][]string)
+	for _, paths := range fileSizes {
+		for _, path := range paths {
+			ext := strings.ToLower(filepath.Ext(path))
+			shot := strings.ToLower(strings.TrimSuffix(path, filepath.Ext(path)))
+			switch {
+			case ext == ".jpg" || ext == ".jpeg":
+				jpegs[shot] = append(jpegs[shot], path)
+			case rawExt.matches(path):
+				raws[shot] = append(raws[shot], path)
+			}
+		}
+	}
+	var pairs []rawJPEGPair
+	for shot, raw := range raws {
+		if len(raw) != 1 {
+			continue
+		}
+		for _, jpeg := range jpegs[shot] {
+			pairs = append(pairs, rawJPEGPair{RAW: raw[0], JPEG: jpeg})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].RAW != pairs[j].RAW {
+			return pairs[i].RAW < pairs[j].RAW
+		}
+		return pairs[i].JPEG < pairs[j].JPEG
+	})
+	return pairs
+}
+
+// handleRawJPEGPairs prints the file that is kept and the one that is dropped for each pair, and with apply moves the
+// dropped one into the reject folder next to it. A dropped file in one of the references directories or matching the
+// protected names is left where it is, like a duplicate would be. It returns the errors of the files that couldn't be
+// moved.
+func handleRawJPEGPairs(pairs []rawJPEGPair, keepRaw, apply bool, rejectFolder, template string, references, protected []string, stdout, status io.Writer, display func(string) string) []error {
+	var errs []error
+	taken := make(map[string]bool)
+	for _, pair := range pairs {
+		keep, drop := pair.RAW, pair.JPEG
+		if !keepRaw {
+			keep, drop = drop, keep
+		}
+		fmt.Fprintf(stdout, "\n%s\n%s\n", display(keep), display(drop))
+		if reason, ok := leftInPlace(drop, references, protected); ok {
+			fmt.Fprintf(status, "Leaving %s where it is since it %s\n", display(drop), reason)
+			continue
+		}
+		if !apply {
+			continue
+		}
+		if err := moveIntoRejectFolder(drop, rejectFolder, template, taken); err != nil {
+			fmt.Fprintf(status, "Could not move %s, %s\n", display(drop), err)
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// leftInPlace reports if path is in one of the references directories or matches the protected names, which keeps it
+// where it is like a duplicate would be, and the reason to print for it
+func leftInPlace(path string, references, protected []string) (string, bool) {
+	if len(preferredPaths([]string{path}, references)) > 0 {
+		return "is in a -reference directory", true
+	}
+	if isProtected(path, protected) {
+		return "matches -protect-names", true
+	}
+	return "", false
+}
+
+// moveIntoRejectFolder moves path into the reject folder next to it, named by template with {name} and {ext} of path
+// itself so that it keeps its extension. taken holds the names already given out during this run.
+func moveIntoRejectFolder(path, rejectFolder, template string, taken map[string]bool) error {
+	rejectedDir := filepath.Join(filepath.Dir(path), rejectFolder)
+	if !exists(rejectedDir) {
+		guardWrite("create", rejectedDir)
+		if err := os.Mkdir(longPath(rejectedDir), 0755); err != nil {
+			return err
+		}
+	}
+	dst := freeCopyPath(template, rejectFields{original: path, duplicate: path, number: 1}, rejectedDir, taken)
+	guardWrite("move", path)
+	return renameNoClobber(longPath(path), longPath(dst))
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRawJPEGPairs(t *testing.T) {
+	join := filepath.FromSlash
+	got := rawJPEGPairs(map[int64][]string{
+		100: {join("a/DSC_001.nef"), join("a/DSC_002.NEF")},
+		20:  {join("a/DSC_001.jpg"), join("a/dsc_002.JPG"), join("b/DSC_001.jpg")},
+		30:  {join("a/DSC_003.jpg"), join("c/IMG_1.cr2"), join("c/IMG_1.dng"), join("c/IMG_1.jpeg")},
+	})
+	want := []rawJPEGPair{
+		{RAW: join("a/DSC_001.nef"), JPEG: join("a/DSC_001.jpg")},
+		{RAW: join("a/DSC_002.NEF"), JPEG: join("a/dsc_002.JPG")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rawJPEGPairs() = %v, want %v", got, want)
+	}
+}
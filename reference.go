@@ -0,0 +1,20 @@
+package main
+
+// splitReference returns the paths inside one of the -reference directories and the paths outside of them, in the
+// order they were given
+func splitReference(paths, dirs []string) (refs, rest []string) {
+	if len(dirs) == 0 {
+		return nil, paths
+	}
+	refs = preferredPaths(paths, dirs)
+	inside := make(map[string]bool, len(refs))
+	for _, path := range refs {
+		inside[path] = true
+	}
+	for _, path := range paths {
+		if !inside[path] {
+			rest = append(rest, path)
+		}
+	}
+	return refs, rest
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/stojg/deduper/dedupe"
+)
+
+// outsideRejectFolders returns the paths that aren't inside a folder named rejectFolder
+func outsideRejectFolders(paths []string, rejectFolder string) []string {
+	var result []string
+	for _, path := range paths {
+		if !dedupe.InRejectFolder(path, rejectFolder) {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+// findOrphans walks the roots and returns the files inside rejectFolder folders that have no identical copy left
+// outside of them, they are probably the only copy and should be restored. Snapshot directories don't count as a
+// copy unless includeSnapshots is set. It also returns how many rejected files were checked.
+//
+// Like the scan, a file or directory that can't be read is left out with its error instead of ending the check. A
+// rejected file that can't be hashed isn't reported either way, and a copy that can't be hashed doesn't count.
+func findOrphans(roots []string, rejectFolder string, includeSnapshots bool, sum func(path string) (Hash, error)) ([]string, int, []error) {
+	var rejected []string
+	var errs []error
+	sizes := make(map[string]int64)
+	kept := make(map[int64][]string)
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+			if info.IsDir() && path != root && !includeSnapshots && isSnapshotDir(info.Name()) {
+				return filepath.SkipDir
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			if dedupe.InRejectFolder(path, rejectFolder) {
+				rejected = append(rejected, path)
+				sizes[path] = info.Size()
+			} else {
+				kept[info.Size()] = append(kept[info.Size()], path)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	sort.Strings(rejected)
+
+	sums := make(map[string]Hash)
+	failed := make(map[string]bool)
+	hashed := func(path string) (Hash, bool) {
+		if s, ok := sums[path]; ok {
+			return s, true
+		}
+		if failed[path] {
+			return "", false
+		}
+		s, err := sum(path)
+		if err != nil {
+			errs = append(errs, err)
+			failed[path] = true
+			return "", false
+		}
+		sums[path] = s
+		return s, true
+	}
+
+	var orphans []string
+	checked := 0
+	for _, path := range rejected {
+		want, ok := hashed(path)
+		if !ok {
+			continue
+		}
+		checked++
+		found := false
+		for _, other := range kept[sizes[path]] {
+			if got, ok := hashed(other); ok && got == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			orphans = append(orphans, path)
+		}
+	}
+	return orphans, checked, errs
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindOrphans(t *testing.T) {
+	dir := t.TempDir()
+	rejected := filepath.Join(dir, "2019", defaultRejectFolder)
+	if err := os.MkdirAll(rejected, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(dir, "2019", "photo.jpg"):   "kept photo",
+		filepath.Join(rejected, "photo_1.jpg"):    "kept photo",
+		filepath.Join(dir, "2019", "renamed.jpg"): "other photo",
+		filepath.Join(rejected, "photo_2.jpg"):    "other photo",
+		// the original was deleted, and a different file of the same size doesn't count as a copy
+		filepath.Join(rejected, "orphan_1.jpg"): "gone photo",
+		filepath.Join(dir, "unrelated.jpg"):     "other size",
+		filepath.Join(dir, "samesize.jpg"):      "gone phot0",
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orphans, checked, errs := findOrphans([]string{dir}, defaultRejectFolder, false, fileSum)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if want := []string{filepath.Join(rejected, "orphan_1.jpg")}; !reflect.DeepEqual(orphans, want) {
+		t.Errorf("findOrphans() = %v, want %v", orphans, want)
+	}
+	if checked != 3 {
+		t.Errorf("findOrphans() checked %d rejected files, want 3", checked)
+	}
+}
+
+func TestFindOrphans_UnreadableFiles(t *testing.T) {
+	dir := t.TempDir()
+	rejected := filepath.Join(dir, defaultRejectFolder)
+	if err := os.MkdirAll(rejected, 0755); err != nil {
+		t.Fatal(err)
+	}
+	unreadable := map[string]bool{}
+	for name, broken := range map[string]bool{
+		"photo.jpg":              false,
+		"copy.jpg":               true,
+		"other.jpg":              false,
+		"_Rejected/photo_1.jpg":  false,
+		"_Rejected/broken_1.jpg": true,
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := ioutil.WriteFile(path, []byte("same photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		unreadable[path] = broken
+	}
+	sum := func(path string) (Hash, error) {
+		if unreadable[path] {
+			return "", &os.PathError{Op: "read", Path: path, Err: errors.New("input/output error")}
+		}
+		return fileSum(path)
+	}
+
+	orphans, checked, errs := findOrphans([]string{dir}, defaultRejectFolder, false, sum)
+	if len(orphans) != 0 || checked != 1 {
+		t.Errorf("findOrphans() = %v, %d checked, want photo_1.jpg checked against the readable copies", orphans, checked)
+	}
+	if len(errs) != 2 {
+		t.Errorf("findOrphans() = %v errors, want one for each unreadable file", errs)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Group is a set of identical files where Original is the file that will be kept
+type Group struct {
+	Original   string   `json:"original"`
+	Duplicates []string `json:"duplicates"`
+	Size       int64    `json:"size"`
+	Hash       string   `json:"hash"`
+	Reason     string   `json:"-"` // why Original was kept, for -explain
+}
+
+// wasted returns how many bytes are freed by removing the duplicates of a group
+func (g Group) wasted() int64 {
+	return g.Size * int64(len(g.Duplicates))
+}
+
+// reclaimableSpace returns how many bytes and files are freed by removing every duplicate in the groups
+func reclaimableSpace(groups []Group) (int64, int) {
+	var size int64
+	var files int
+	for _, group := range groups {
+		size += group.wasted()
+		files += len(group.Duplicates)
+	}
+	return size, files
+}
+
+// ByWaste sorts groups with the most wasted space first
+type ByWaste []Group
+
+func (s ByWaste) Len() int { return len(s) }
+
+func (s ByWaste) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s ByWaste) Less(i, j int) bool { return s[i].wasted() > s[j].wasted() }
+
+// ByOriginal orders groups by the path of the file that is kept, ignoring case
+type ByOriginal []Group
+
+func (s ByOriginal) Len() int { return len(s) }
+
+func (s ByOriginal) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s ByOriginal) Less(i, j int) bool {
+	a, b := s[i].Original, s[j].Original
+	if lowerA, lowerB := strings.ToLower(a), strings.ToLower(b); lowerA != lowerB {
+		return lowerA < lowerB
+	}
+	// paths that only differ in case are ordered exactly, or the order of the groups would depend on the sort
+	return a < b
+}
+
+// ReportError is an error encountered while scanning or hashing a file
+type ReportError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ScanParameters records how a run was configured, so that a saved report explains how its results came about
+type ScanParameters struct {
+	Version         string   `json:"version"`
+	Roots           []string `json:"roots"`
+	Extensions      []string `json:"extensions,omitempty"`
+	ByMime          bool     `json:"by_mime"`
+	MinSize         int64    `json:"min_size"`
+	Exclude         []string `json:"exclude,omitempty"`
+	Keep            string   `json:"keep"`
+	Prefer          []string `json:"prefer,omitempty"`
+	Reference       []string `json:"reference,omitempty"`
+	Hash            string   `json:"hash"`
+	HashIncludeSize bool     `json:"hash_include_size"`
+	SameDir         bool     `json:"same_dir"`
+	HeadBytes       int64    `json:"head_bytes"`
+	EndBytes        int64    `json:"end_bytes"`
+	DryRun          bool     `json:"dryrun"`
+}
+
+// Report is the structured result of a run that is written to the machine readable outputs
+type Report struct {
+	Scan   ScanParameters `json:"scan"`
+	Groups []Group        `json:"groups"`
+	Errors []ReportError  `json:"errors"`
+}
+
+func newReportError(err error) ReportError {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return ReportError{Path: pathErr.Path, Message: pathErr.Err.Error()}
+	}
+	return ReportError{Message: err.Error()}
+}
+
+// writeJSONReport writes the report as an indented JSON document to filePath
+func writeJSONReport(filePath string, report Report) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeJSONGroups writes the groups to w as a JSON array for -format json, an empty array if there are none
+func writeJSONGroups(w io.Writer, groups []Group) error {
+	if groups == nil {
+		groups = []Group{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(groups)
+}
+
+// writeCSVReport writes one row per file in the report to filePath, the group_id links duplicates to their original.
+// Errors are written as rows with the error role and the message in the last column.
+func writeCSVReport(filePath string, report Report) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if err := writeCSVScanComments(file, report.Scan); err != nil {
+		file.Close()
+		return err
+	}
+	w := csv.NewWriter(file)
+	rows := [][]string{{"group_id", "role", "path", "size_bytes", "hash", "error"}}
+	for _, row := range csvGroupRows(report.Groups) {
+		rows = append(rows, append(row, ""))
+	}
+	for _, e := range report.Errors {
+		rows = append(rows, []string{"", "error", e.Path, "", "", e.Message})
+	}
+	if err := w.WriteAll(rows); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeCSVGroups writes the groups to w for -format csv, with one row per file and the same group_id for the files of
+// a group
+func writeCSVGroups(w io.Writer, groups []Group) error {
+	cw := csv.NewWriter(w)
+	rows := append([][]string{{"group_id", "role", "path", "size_bytes", "hash"}}, csvGroupRows(groups)...)
+	return cw.WriteAll(rows)
+}
+
+// csvGroupRows returns a group_id, role, path, size_bytes and hash row for every file in groups, the group ids count
+// from 1 in the order of groups
+func csvGroupRows(groups []Group) [][]string {
+	var rows [][]string
+	for i, group := range groups {
+		id := strconv.Itoa(i + 1)
+		size := strconv.FormatInt(group.Size, 10)
+		rows = append(rows, []string{id, "original", group.Original, size, group.Hash})
+		for _, dupe := range group.Duplicates {
+			rows = append(rows, []string{id, "duplicate", dupe, size, group.Hash})
+		}
+	}
+	return rows
+}
+
+// writeCSVScanComments writes the scan parameters as # comment lines above the CSV header
+func writeCSVScanComments(w io.Writer, scan ScanParameters) error {
+	lines := []string{
+		"version: " + scan.Version,
+		"roots: " + strings.Join(scan.Roots, ","),
+		"extensions: " + strings.Join(scan.Extensions, ","),
+		"by_mime: " + strconv.FormatBool(scan.ByMime),
+		"keep: " + scan.Keep,
+		"hash: " + scan.Hash,
+		"hash_include_size: " + strconv.FormatBool(scan.HashIncludeSize),
+		"same_dir: " + strconv.FormatBool(scan.SameDir),
+		"head_bytes: " + strconv.FormatInt(scan.HeadBytes, 10),
+		"end_bytes: " + strconv.FormatInt(scan.EndBytes, 10),
+		"dryrun: " + strconv.FormatBool(scan.DryRun),
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(w, "# %s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uniqueStrings returns values without repeats, in the order they first appear
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
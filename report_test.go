@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReclaimableSpace(t *testing.T) {
+	groups := []Group{
+		{Original: "a.jpg", Duplicates: []string{"b.jpg", "c.jpg"}, Size: 1000},
+		{Original: "movie.mov", Duplicates: []string{"copy.mov"}, Size: 1 << 30},
+	}
+	size, files := reclaimableSpace(groups)
+	if size != 2000+1<<30 || files != 3 {
+		t.Errorf("reclaimableSpace() = %d bytes in %d files, want %d bytes in 3 files", size, files, 2000+1<<30)
+	}
+}
+
+func TestJSONReport_RoundTrip(t *testing.T) {
+	report := Report{
+		Scan: ScanParameters{
+			Version:    "test",
+			Roots:      []string{"photos"},
+			Extensions: []string{".jpg", ".png"},
+			MinSize:    500 << 10,
+			Exclude:    []string{"*.tmp", "cache"},
+			Keep:       "oldest",
+			Prefer:     []string{"photos/Originals"},
+			Reference:  []string{"archive"},
+			Hash:       "sha256",
+			HeadBytes:  4096,
+			EndBytes:   4096,
+			DryRun:     true,
+		},
+		Groups: []Group{{Original: "photos/a.jpg", Duplicates: []string{"photos/b.jpg"}, Size: 600 << 10, Hash: "abc"}},
+		Errors: []ReportError{{Path: "photos/c.jpg", Message: "permission denied"}},
+	}
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := writeJSONReport(path, report); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readJSONReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, report) {
+		t.Errorf("readJSONReport() = %+v, want the report that was written %+v", got, report)
+	}
+}
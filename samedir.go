@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/sha1"
+	"path/filepath"
+)
+
+// sameDirSizes keeps the paths in fileSizes that share their size with another file in the same directory. With
+// -same-dir files in different directories are never compared, so a size has to repeat within one directory.
+func sameDirSizes(fileSizes map[int64][]string) map[int64][]string {
+	result := make(map[int64][]string)
+	for size, paths := range fileSizes {
+		if len(paths) < 2 {
+			continue
+		}
+		byDir := make(map[string][]string)
+		for _, path := range paths {
+			dir := filepath.Dir(path)
+			byDir[dir] = append(byDir[dir], path)
+		}
+		for _, dirPaths := range byDir {
+			if len(dirPaths) > 1 {
+				result[size] = append(result[size], dirPaths...)
+			}
+		}
+	}
+	return result
+}
+
+// dirKey combines a sum with the directory of the file, so that identical files in different directories end up in
+// different groups
+func dirKey(sum Hash, path string) Hash {
+	hasher := sha1.New()
+	hasher.Write([]byte(filepath.Dir(path)))
+	hasher.Write([]byte{0})
+	hasher.Write([]byte(sum))
+	return sumOf(hasher)
+}
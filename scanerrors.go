@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/stojg/deduper/dedupe"
+)
+
+// How many errors of each kind are printed in full, the rest are only counted
+const errorExamples = 3
+
+// errorSummary is the number of errors of one kind, like permission denied, and the first few of them
+type errorSummary struct {
+	Kind     string
+	Count    int
+	Examples []error
+}
+
+// errorKind returns what went wrong without the path, so that the same problem on many files is counted together
+func errorKind(err error) string {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err.Error()
+	}
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		return linkErr.Err.Error()
+	}
+	if errors.Is(err, dedupe.ErrShortRead) {
+		return dedupe.ErrShortRead.Error()
+	}
+	return err.Error()
+}
+
+// summarizeErrors groups errs by kind, the most common kind first
+func summarizeErrors(errs []error) []errorSummary {
+	byKind := make(map[string]*errorSummary)
+	var summaries []*errorSummary
+	for _, err := range errs {
+		kind := errorKind(err)
+		summary, ok := byKind[kind]
+		if !ok {
+			summary = &errorSummary{Kind: kind}
+			byKind[kind] = summary
+			summaries = append(summaries, summary)
+		}
+		summary.Count++
+		if len(summary.Examples) < errorExamples {
+			summary.Examples = append(summary.Examples, err)
+		}
+	}
+	sort.SliceStable(summaries, func(i, j int) bool { return summaries[i].Count > summaries[j].Count })
+	result := make([]errorSummary, len(summaries))
+	for i, summary := range summaries {
+		result[i] = *summary
+	}
+	return result
+}
+
+// printErrorSummary writes a count and a few examples of each kind of error under title
+func printErrorSummary(w io.Writer, title string, errs []error) {
+	if len(errs) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s:\n\n", title)
+	for _, summary := range summarizeErrors(errs) {
+		if summary.Count == 1 {
+			fmt.Fprintf(w, "1 %s error:\n", summary.Kind)
+		} else if summary.Count > len(summary.Examples) {
+			fmt.Fprintf(w, "%s %s errors, %d examples:\n", thousands(summary.Count), summary.Kind, len(summary.Examples))
+		} else {
+			fmt.Fprintf(w, "%s %s errors:\n", thousands(summary.Count), summary.Kind)
+		}
+		for _, err := range summary.Examples {
+			fmt.Fprintf(w, " - '%s'\n", err)
+		}
+	}
+	fmt.Fprint(w, "\n")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestPrintErrorSummary_GroupsByKind(t *testing.T) {
+	var errs []error
+	for i := 0; i < 1204; i++ {
+		errs = append(errs, &os.PathError{Op: "open", Path: fmt.Sprintf("/share/%d.jpg", i), Err: syscall.EACCES})
+	}
+	errs = append(errs, &os.PathError{Op: "lstat", Path: "/share/gone.jpg", Err: syscall.ENOENT})
+	errs = append(errs, &os.LinkError{Op: "rename", Old: "/share/moved.jpg", New: "/share/_Rejected/moved.jpg", Err: syscall.ENOENT})
+
+	var out bytes.Buffer
+	printErrorSummary(&out, "Errors", errs)
+	for _, want := range []string{
+		"1,204 permission denied errors, 3 examples:\n - 'open /share/0.jpg: permission denied'\n",
+		"2 no such file or directory errors:\n - 'lstat /share/gone.jpg: no such file or directory'\n - 'rename /share/moved.jpg /share/_Rejected/moved.jpg: no such file or directory'\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("summary is missing %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "/share/3.jpg") {
+		t.Errorf("more than %d examples were printed:\n%s", errorExamples, out.String())
+	}
+}
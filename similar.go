@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"sort"
+	"time"
+
+	"github.com/stojg/deduper/dedupe"
+)
+
+// Default -similar-threshold, the number of differing bits out of 64 for two images to still count as the same picture
+const defaultSimilarThreshold = 10
+
+// dHash is a difference hash of an image. The image is shrunk to 9x8 grey cells and each of the 64 bits tells if a
+// cell is brighter than its right neighbour, so scaling and re-encoding the image only flips a few bits.
+func dHash(img image.Image) uint64 {
+	const w, h = 9, 8
+	var sums [h][w]float64
+	var counts [h][w]int
+	b := img.Bounds()
+	if b.Empty() {
+		return 0
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		cy := (y - b.Min.Y) * h / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cx := (x - b.Min.X) * w / b.Dx()
+			sums[cy][cx] += luminance(img, x, y)
+			counts[cy][cx]++
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if cellMean(sums[y][x], counts[y][x]) > cellMean(sums[y][x+1], counts[y][x+1]) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// luminance returns how bright the pixel at x, y is
+func luminance(img image.Image, x, y int) float64 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+}
+
+func cellMean(sum float64, count int) float64 {
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// imageHash decodes the image at path and returns its dHash
+func imageHash(path string) (uint64, error) {
+	img, err := decodeImage(path)
+	if err != nil {
+		return 0, err
+	}
+	return dHash(img), nil
+}
+
+// decodeImage reads the image at path in any of the registered formats
+func decodeImage(path string) (image.Image, error) {
+	in, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	img, _, err := image.Decode(in)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return img, nil
+}
+
+// similarGroups groups the paths whose hashes differ in at most threshold bits. With a window, two images also have to
+// be taken at most window apart according to taken, so that the same view shot on another day stays apart. Being
+// similar isn't transitive, so a group is every image that can be reached from another image in it through pairs
+// within the threshold and window, even if the two ends of such a chain are further apart. Each group and the paths in
+// it are sorted.
+func similarGroups(hashes map[string]uint64, threshold int, taken map[string]time.Time, window time.Duration) [][]string {
+	paths := make([]string, 0, len(hashes))
+	for path := range hashes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// union-find over the indexes of paths
+	parent := make([]int, len(paths))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range paths {
+		for j := i + 1; j < len(paths); j++ {
+			if bits.OnesCount64(hashes[paths[i]]^hashes[paths[j]]) > threshold {
+				continue
+			}
+			if window > 0 && absDuration(taken[paths[i]].Sub(taken[paths[j]])) > window {
+				continue
+			}
+			parent[find(j)] = find(i)
+		}
+	}
+
+	members := make(map[int][]string)
+	for i, path := range paths {
+		root := find(i)
+		members[root] = append(members[root], path)
+	}
+	var groups [][]string
+	for _, group := range members {
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+// absDuration returns d without its sign
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// reportSimilarImages is the -similar pipeline. It hashes every decodable image in fileSizes and prints the groups of
+// similar images, each starting with the shortest path like the groups of identical files. With a window only images
+// taken at most that long apart are grouped.
+func reportSimilarImages(fileSizes map[int64][]string, threshold int, window time.Duration, newPrinter func(int, string) *ProgressPrinter, stdout, progress, status io.Writer, display func(string) string) {
+	var images []string
+	for _, paths := range fileSizes {
+		for _, path := range paths {
+			if canThumbnail(path) {
+				images = append(images, path)
+			}
+		}
+	}
+	sort.Strings(images)
+
+	fmt.Fprintf(progress, "Comparing how %d images look\n", len(images))
+	printer := newPrinter(len(images), "similar")
+	hashes := make(map[string]uint64)
+	taken := make(map[string]time.Time)
+	var errs []error
+	for _, path := range images {
+		if window > 0 {
+			date, err := captureDate(path)
+			if err != nil {
+				errs = append(errs, err)
+				printer.Err()
+				continue
+			}
+			taken[path] = date
+		}
+		hash, err := imageHash(path)
+		if err != nil {
+			errs = append(errs, err)
+			printer.Err()
+			continue
+		}
+		hashes[path] = hash
+		printer.Print(false)
+	}
+	fmt.Fprintf(progress, "\n\n")
+
+	if len(errs) > 0 {
+		fmt.Fprint(status, "The following images could not be decoded:\n\n")
+		for _, err := range errs {
+			fmt.Fprintf(status, " - '%s'\n", err)
+		}
+		fmt.Fprint(status, "\n")
+	}
+
+	groups := similarGroups(hashes, threshold, taken, window)
+	for _, group := range groups {
+		i := dedupe.ShortestIdx(group)
+		group[0], group[i] = group[i], group[0]
+		fmt.Fprintf(stdout, "\n%s\n", display(group[0]))
+		for _, path := range group[1:] {
+			fmt.Fprintln(stdout, display(path))
+		}
+	}
+	fmt.Fprintf(status, "\nFound %s groups of similar images among %s images\n", thousands(len(groups)), thousands(len(hashes)))
+}
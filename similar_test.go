@@ -0,0 +1,98 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testPattern draws a w by h image of a few soft blobs, flip mirrors it so it looks nothing alike
+func testPattern(w, h int, flip bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			if flip {
+				fx = 1 - fx
+			}
+			v := uint8(255 * fx * fx * (1 - fy))
+			if (fx-0.3)*(fx-0.3)+(fy-0.6)*(fy-0.6) < 0.04 {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{R: v, G: v / 2, B: 255 - v, A: 255})
+		}
+	}
+	return img
+}
+
+func TestSimilarGroups_ScaledCopy(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, img image.Image) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if filepath.Ext(name) == ".png" {
+			err = png.Encode(f, img)
+		} else {
+			err = jpeg.Encode(f, img, &jpeg.Options{Quality: 85})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	photo := write("photo.jpg", testPattern(320, 240, false))
+	resized := write("photo_small.png", testPattern(120, 90, false))
+	other := write("other.jpg", testPattern(320, 240, true))
+
+	hashes := make(map[string]uint64)
+	for _, path := range []string{photo, resized, other} {
+		hash, err := imageHash(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[path] = hash
+	}
+
+	want := [][]string{{photo, resized}}
+	if got := similarGroups(hashes, defaultSimilarThreshold, nil, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("similarGroups() = %v, want %v", got, want)
+	}
+}
+
+func TestSimilarGroups_Chains(t *testing.T) {
+	// a and c are 12 bits apart but both within 6 bits of b, so the three end up in one group
+	hashes := map[string]uint64{"a": 0, "b": 0x3f, "c": 0xfff, "d": ^uint64(0)}
+	want := [][]string{{"a", "b", "c"}}
+	if got := similarGroups(hashes, 6, nil, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("similarGroups() = %v, want %v", got, want)
+	}
+}
+
+func TestSimilarGroups_TimeWindow(t *testing.T) {
+	day := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	hashes := map[string]uint64{"a": 0, "b": 0x1, "c": 0x3, "d": 0x7}
+	taken := map[string]time.Time{
+		"a": day,
+		"b": day.Add(20 * time.Minute),
+		// the same view the next day is another shot
+		"c": day.Add(24 * time.Hour),
+		"d": day.Add(24*time.Hour - 30*time.Minute),
+	}
+	want := [][]string{{"a", "b"}, {"c", "d"}}
+	if got := similarGroups(hashes, 6, taken, time.Hour); !reflect.DeepEqual(got, want) {
+		t.Errorf("similarGroups() with a window = %v, want %v", got, want)
+	}
+	if got := similarGroups(hashes, 6, taken, 0); len(got) != 1 {
+		t.Errorf("similarGroups() without a window = %v, want a single group", got)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// How many bytes an indexed path is estimated to use on top of its own length, for the map entry and string header
+const sizeIndexEntryOverhead = 48
+
+// sizeIndex groups the scanned paths by file size. Most sizes in a photo library are unique, so a size seen only once
+// keeps its single path in a plain map without a slice, and those are dropped after the walk unless asked for, since
+// only sizes shared by two or more files can contain duplicates.
+//
+// With a memory limit the paths are spilled to a temporary file once the estimated size of the maps goes over it. From
+// then on only a count per size is kept in memory and the paths are read back from the file after the walk.
+//
+// It has no lock of its own. parallelWalk only runs one call of the walk function at a time, so with any number of
+// walk workers the index is updated by one goroutine at a time and there is no contention on its maps to shard away.
+type sizeIndex struct {
+	unique map[int64]string
+	shared map[int64][]string
+
+	limit  int64
+	used   int64
+	counts map[int64]int
+	spill  *os.File
+	writer *bufio.Writer
+	err    error
+}
+
+func newSizeIndex() *sizeIndex {
+	return &sizeIndex{unique: make(map[int64]string), shared: make(map[int64][]string)}
+}
+
+// newSpillingSizeIndex returns an index that moves its paths to disk once they use more than about limit bytes
+func newSpillingSizeIndex(limit int64) *sizeIndex {
+	s := newSizeIndex()
+	s.limit = limit
+	return s
+}
+
+// add records path under size and returns how many files now have that size. An error means the paths couldn't be
+// spilled to disk, the scan should stop there rather than walk everything only to fail at the end.
+func (s *sizeIndex) add(size int64, path string) (int, error) {
+	if s.spill != nil {
+		s.counts[size]++
+		s.write(size, path)
+		return s.counts[size], s.err
+	}
+
+	s.used += int64(len(path)) + sizeIndexEntryOverhead
+	count := s.addInMemory(size, path)
+	if s.limit > 0 && s.used > s.limit {
+		s.startSpill()
+	}
+	return count, s.err
+}
+
+func (s *sizeIndex) addInMemory(size int64, path string) int {
+	if paths, ok := s.shared[size]; ok {
+		s.shared[size] = append(paths, path)
+		return len(paths) + 1
+	}
+	if first, ok := s.unique[size]; ok {
+		delete(s.unique, size)
+		s.shared[size] = []string{first, path}
+		return 2
+	}
+	s.unique[size] = path
+	return 1
+}
+
+// spilled reports if the index moved its paths to disk
+func (s *sizeIndex) spilled() bool {
+	return s.spill != nil
+}
+
+// startSpill moves every path in the maps into a temporary file, the file is unlinked straight away where the OS
+// allows it so that nothing is left behind if the program exits early
+func (s *sizeIndex) startSpill() {
+	file, err := ioutil.TempFile("", "deduper-sizes-")
+	if err != nil {
+		s.err = fmt.Errorf("can't spill the scanned paths to disk for -max-memory: %w", err)
+		return
+	}
+	// fails on Windows for open files, readSpill removes it there
+	os.Remove(file.Name())
+	s.spill, s.writer = file, bufio.NewWriter(file)
+	s.counts = make(map[int64]int)
+	for size, path := range s.unique {
+		s.counts[size]++
+		s.write(size, path)
+	}
+	for size, paths := range s.shared {
+		for _, path := range paths {
+			s.counts[size]++
+			s.write(size, path)
+		}
+	}
+	s.unique, s.shared = nil, nil
+}
+
+func (s *sizeIndex) write(size int64, path string) {
+	if s.err != nil {
+		return
+	}
+	var header [12]byte
+	binary.LittleEndian.PutUint64(header[:8], uint64(size))
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(path)))
+	if _, err := s.writer.Write(header[:]); err != nil {
+		s.err = err
+		return
+	}
+	_, s.err = s.writer.WriteString(path)
+}
+
+// bySize returns the paths grouped by size and releases the index. Sizes with a single file are only included if
+// keepUnique is set.
+func (s *sizeIndex) bySize(keepUnique bool) (map[int64][]string, error) {
+	if s.spill != nil {
+		return s.readSpill(keepUnique)
+	}
+	result := s.shared
+	if keepUnique {
+		for size, path := range s.unique {
+			result[size] = []string{path}
+		}
+	}
+	s.unique, s.shared = nil, nil
+	return result, s.err
+}
+
+// readSpill reads the spilled paths back, skipping the ones with a unique size unless keepUnique is set, and removes
+// the temporary file
+func (s *sizeIndex) readSpill(keepUnique bool) (map[int64][]string, error) {
+	defer func() {
+		s.spill.Close()
+		os.Remove(s.spill.Name())
+		s.spill, s.writer, s.counts = nil, nil, nil
+	}()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if err := s.writer.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := s.spill.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	result := make(map[int64][]string)
+	reader := bufio.NewReader(s.spill)
+	var header [12]byte
+	for {
+		if _, err := io.ReadFull(reader, header[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		size := int64(binary.LittleEndian.Uint64(header[:8]))
+		path := make([]byte, binary.LittleEndian.Uint32(header[8:]))
+		if _, err := io.ReadFull(reader, path); err != nil {
+			return nil, err
+		}
+		if keepUnique || s.counts[size] > 1 {
+			result[size] = append(result[size], string(path))
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestSizeIndex_DropsUniqueSizes(t *testing.T) {
+	idx := newSizeIndex()
+	for i := 0; i < 10000; i++ {
+		idx.add(int64(i), fmt.Sprintf("unique_%d.jpg", i))
+	}
+	idx.add(42, "another_42.jpg")
+	if got, err := idx.add(42, "third_42.jpg"); err != nil || got != 3 {
+		t.Errorf("add() = %d files with size 42, %v, want 3", got, err)
+	}
+	if len(idx.unique) != 9999 {
+		t.Errorf("len(unique) = %d during the walk, want 9999", len(idx.unique))
+	}
+
+	bySize, err := idx.bySize(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bySize) != 1 {
+		t.Fatalf("bySize(false) kept %d sizes, want only the shared one", len(bySize))
+	}
+	want := []string{"unique_42.jpg", "another_42.jpg", "third_42.jpg"}
+	if fmt.Sprint(bySize[42]) != fmt.Sprint(want) {
+		t.Errorf("bySize(false)[42] = %v, want %v", bySize[42], want)
+	}
+	if idx.unique != nil || idx.shared != nil {
+		t.Errorf("bySize() didn't release the index")
+	}
+}
+
+func TestSizeIndex_KeepUnique(t *testing.T) {
+	idx := newSizeIndex()
+	idx.add(1, "a.jpg")
+	idx.add(2, "b.jpg")
+	idx.add(2, "c.jpg")
+
+	bySize, err := idx.bySize(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bySize) != 2 || len(bySize[1]) != 1 || len(bySize[2]) != 2 {
+		t.Errorf("bySize(true) = %v, want both sizes", bySize)
+	}
+}
+
+func TestSizeIndex_Spill(t *testing.T) {
+	idx := newSpillingSizeIndex(1000)
+	for i := 0; i < 100; i++ {
+		idx.add(int64(i), fmt.Sprintf("unique_%d.jpg", i))
+	}
+	idx.add(42, "another_42.jpg")
+	if got, err := idx.add(42, "third_42.jpg"); err != nil || got != 3 {
+		t.Errorf("add() after spilling = %d files with size 42, %v, want 3", got, err)
+	}
+	if !idx.spilled() {
+		t.Fatalf("index didn't spill after going over its limit")
+	}
+
+	bySize, err := idx.bySize(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bySize) != 1 {
+		t.Fatalf("bySize(false) kept %d sizes, want only the shared one", len(bySize))
+	}
+	want := []string{"unique_42.jpg", "another_42.jpg", "third_42.jpg"}
+	if fmt.Sprint(bySize[42]) != fmt.Sprint(want) {
+		t.Errorf("bySize(false)[42] = %v, want %v", bySize[42], want)
+	}
+}
+
+func TestSizeIndex_SpillFails(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	// os.TempDir reads TMPDIR, and TMP or TEMP on Windows
+	for _, name := range []string{"TMPDIR", "TMP", "TEMP"} {
+		t.Setenv(name, missing)
+	}
+	idx := newSpillingSizeIndex(100)
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		_, err = idx.add(int64(i), fmt.Sprintf("unique_%d.jpg", i))
+	}
+	if err == nil {
+		t.Fatal("add() = nil error, want the scan to stop once the paths can't be spilled")
+	}
+}
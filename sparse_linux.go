@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+const (
+	seekData = 3 // SEEK_DATA
+	seekHole = 4 // SEEK_HOLE
+)
+
+// copyContents copies the size bytes of in to the start of out and keeps the holes of a sparse file, such as a disk
+// image, as holes instead of writing them out as zeros. Filesystems that can't tell where the holes are get a plain
+// copy.
+func copyContents(out, in *os.File, size int64) error {
+	var offset int64
+	for offset < size {
+		data, err := in.Seek(offset, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			// only a hole is left until the end of the file
+			break
+		}
+		if err != nil {
+			if offset == 0 && errors.Is(err, syscall.EINVAL) {
+				// nothing was read yet, the offset of in is still at the start
+				_, err = io.Copy(out, in)
+			}
+			return err
+		}
+		hole, err := in.Seek(data, seekHole)
+		if err != nil {
+			return err
+		}
+		if _, err := in.Seek(data, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := out.Seek(data, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(out, in, hole-data); err != nil {
+			return err
+		}
+		offset = hole
+	}
+	// a trailing hole only exists once the file is made as long as src
+	return out.Truncate(size)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMoveFile_CrossDeviceKeepsHoles(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "disk.img"), filepath.Join(dir, "rejected.img")
+	// data at the start and in the middle, with holes between, after and at the end
+	const size = 8 << 20
+	file, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range []int64{0, 4 << 20} {
+		if _, err := file.WriteAt(bytes.Repeat([]byte("disk"), 1024), offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := file.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated(t, src) >= size {
+		t.Skip("the filesystem of the temporary directory doesn't keep holes")
+	}
+
+	if err := moveFile(src, dst, crossDeviceRename); err != nil {
+		t.Fatalf("moveFile() returned %v", err)
+	}
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("the copy has other content than %s", filepath.Base(src))
+	}
+	if n := allocated(t, dst); n >= size {
+		t.Errorf("the copy takes %d of its %d bytes on disk, its holes were written out", n, size)
+	}
+}
+
+// allocated returns how many bytes of the disk a file takes
+func allocated(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Sys().(*syscall.Stat_t).Blocks * 512
+}
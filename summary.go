@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// runSummary are the totals printed at the end of a run
+type runSummary struct {
+	Found       int   // regular files found by the walk or listed on stdin
+	Matching    int   // files that passed the extension and size filters and weren't a hardlink of another
+	Candidates  int   // files that share their size with another file
+	Groups      int   // confirmed duplicate groups
+	Duplicates  int   // files in the groups that aren't the original
+	Reclaimable int64 // bytes freed by removing the duplicates
+	ByExtension []extensionTotal
+}
+
+// extensionTotal is how many of the duplicates have one extension and the bytes removing them frees
+type extensionTotal struct {
+	Ext         string // lower case with the dot, empty for files without an extension
+	Duplicates  int
+	Reclaimable int64
+}
+
+// reclaimableByExtension splits the duplicates of the groups by their extension, the extensions that free the most
+// bytes come first
+func reclaimableByExtension(groups []Group) []extensionTotal {
+	byExt := make(map[string]*extensionTotal)
+	for _, group := range groups {
+		for _, path := range group.Duplicates {
+			ext := strings.ToLower(filepath.Ext(path))
+			total, ok := byExt[ext]
+			if !ok {
+				total = &extensionTotal{Ext: ext}
+				byExt[ext] = total
+			}
+			total.Duplicates++
+			total.Reclaimable += group.Size
+		}
+	}
+	var totals []extensionTotal
+	for _, total := range byExt {
+		totals = append(totals, *total)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Reclaimable != totals[j].Reclaimable {
+			return totals[i].Reclaimable > totals[j].Reclaimable
+		}
+		return totals[i].Ext < totals[j].Ext
+	})
+	return totals
+}
+
+// printSummary writes s as an aligned block
+func printSummary(w io.Writer, s runSummary) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "\nSummary\n")
+	fmt.Fprintf(tw, "  Files found:\t%s\n", thousands(s.Found))
+	fmt.Fprintf(tw, "  Matching files:\t%s\n", thousands(s.Matching))
+	fmt.Fprintf(tw, "  Same size candidates:\t%s\n", thousands(s.Candidates))
+	fmt.Fprintf(tw, "  Duplicate groups:\t%s\n", thousands(s.Groups))
+	fmt.Fprintf(tw, "  Duplicate files:\t%s\n", thousands(s.Duplicates))
+	fmt.Fprintf(tw, "  Reclaimable:\t%s\n", humanBytes(s.Reclaimable))
+	for _, total := range s.ByExtension {
+		ext := total.Ext
+		if ext == "" {
+			ext = "(none)"
+		}
+		fmt.Fprintf(tw, "    %s:\t%s duplicates, %s\n", ext, thousands(total.Duplicates), humanBytes(total.Reclaimable))
+	}
+	tw.Flush()
+}
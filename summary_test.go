@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReclaimableByExtension(t *testing.T) {
+	groups := []Group{
+		{Original: "a.jpg", Duplicates: []string{"b.jpg", "c.JPG"}, Size: 100},
+		{Original: "clip.mov", Duplicates: []string{"clip copy.mov"}, Size: 5000},
+		// the same content can be saved under another extension
+		{Original: "d.jpg", Duplicates: []string{"d.jpeg", "README"}, Size: 10},
+		{Original: "e.png", Duplicates: []string{"f.png"}, Size: 10},
+	}
+	want := []extensionTotal{
+		{Ext: ".mov", Duplicates: 1, Reclaimable: 5000},
+		{Ext: ".jpg", Duplicates: 2, Reclaimable: 200},
+		{Ext: "", Duplicates: 1, Reclaimable: 10},
+		{Ext: ".jpeg", Duplicates: 1, Reclaimable: 10},
+		{Ext: ".png", Duplicates: 1, Reclaimable: 10},
+	}
+	if got := reclaimableByExtension(groups); !reflect.DeepEqual(got, want) {
+		t.Errorf("reclaimableByExtension() = %+v, want %+v", got, want)
+	}
+	if got := reclaimableByExtension(nil); len(got) != 0 {
+		t.Errorf("reclaimableByExtension(nil) = %+v, want nothing", got)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders for the formats thumbnails can be made from
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Longest side in pixels of a generated thumbnail
+const thumbnailSize = 160
+
+// canThumbnail reports if a thumbnail can be decoded from path, raw and video files are skipped
+func canThumbnail(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+// writeGroupThumbnails writes a thumbnail for each member of a group into dir, named by the group id and the member
+// index where the original is member 0
+func writeGroupThumbnails(dir string, id int, group Group) error {
+	members := append([]string{group.Original}, group.Duplicates...)
+	for i, path := range members {
+		if !canThumbnail(path) {
+			continue
+		}
+		dest := filepath.Join(dir, fmt.Sprintf("%d_%d.jpg", id, i))
+		if err := writeThumbnail(path, dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeThumbnail(src, dest string) error {
+	in, err := openFile(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	img, _, err := image.Decode(in)
+	if err != nil {
+		return fmt.Errorf("%s: %s", src, err)
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(out, scaleDown(img, thumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// scaleDown does a nearest neighbour resize so that the longest side is at most max pixels
+func scaleDown(img image.Image, max int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return img
+	}
+	tw, th := max, h*max/w
+	if h > w {
+		tw, th = w*max/h, max
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			thumb.Set(x, y, img.At(b.Min.X+x*w/tw, b.Min.Y+y*h/th))
+		}
+	}
+	return thumb
+}
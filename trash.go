@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// errTrashUnsupported is returned by newTrash on platforms without a supported trash
+var errTrashUnsupported = errors.New("moving files to the trash isn't supported on this platform")
+
+// trash is where -trash moves duplicates, so they can be restored from the file manager like any deleted file
+type trash interface {
+	// filesDir returns the directory in the trash that path is moved into, or an error if path can't be trashed
+	filesDir(path string) (string, error)
+	// put moves src to dst inside a filesDir and records whatever the file manager needs to restore it
+	put(src, dst string) error
+}
+
+// xdgTrash follows the freedesktop.org trash spec. Files on the same filesystem as the home trash go there, files on
+// other filesystems go to a .Trash-$uid directory at the top of their own, since a rename can't cross filesystems.
+type xdgTrash struct {
+	home string // the home trash, $XDG_DATA_HOME/Trash
+	uid  int
+	now  func() time.Time
+}
+
+func (t *xdgTrash) filesDir(path string) (string, error) {
+	dir, _, err := t.trashDir(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "files"), nil
+}
+
+// trashDir returns the trash directory for path and the directory the paths in its info files are relative to, which
+// is empty for the home trash where they are absolute
+func (t *xdgTrash) trashDir(path string) (string, string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", "", err
+	}
+	dev, ok := deviceID(abs)
+	if !ok {
+		return t.home, "", nil
+	}
+	if homeDev, ok := deviceID(existingParent(t.home)); !ok || homeDev == dev {
+		return t.home, "", nil
+	}
+	top := filepath.Dir(abs)
+	for {
+		parent := filepath.Dir(top)
+		if parentDev, ok := deviceID(parent); parent == top || !ok || parentDev != dev {
+			break
+		}
+		top = parent
+	}
+	return filepath.Join(top, ".Trash-"+strconv.Itoa(t.uid)), top, nil
+}
+
+func (t *xdgTrash) put(src, dst string) error {
+	dir := filepath.Dir(filepath.Dir(dst))
+	_, top, err := t.trashDir(src)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	original := abs
+	if top != "" {
+		if original, err = filepath.Rel(top, abs); err != nil {
+			return err
+		}
+	}
+	for _, sub := range []string{"files", "info"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return err
+		}
+	}
+
+	// the spec reserves a name in the trash by creating its info file, so it is written before the file is moved
+	infoPath := filepath.Join(dir, "info", filepath.Base(dst)+".trashinfo")
+	info, err := os.OpenFile(infoPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = info.WriteString(trashInfo(original, t.now()))
+	if closeErr := info.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = renameNoClobber(src, dst)
+	}
+	if err != nil {
+		os.Remove(infoPath)
+	}
+	return err
+}
+
+// trashInfo is the content of the info file that tells the file manager where a trashed file came from and when
+func trashInfo(original string, deleted time.Time) string {
+	return fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n", (&url.URL{Path: original}).EscapedPath(), deleted.Format("2006-01-02T15:04:05"))
+}
+
+// macTrash is the trash in the home directory on macOS. Finder keeps no record of where files came from, so a file is
+// simply moved there, but only from the volume the home directory is on.
+type macTrash struct {
+	dir string // ~/.Trash
+}
+
+func (t *macTrash) filesDir(path string) (string, error) {
+	dev, ok := deviceID(path)
+	homeDev, homeOK := deviceID(existingParent(t.dir))
+	if ok && homeOK && dev != homeDev {
+		return "", fmt.Errorf("%s isn't on the same volume as %s", path, t.dir)
+	}
+	return t.dir, nil
+}
+
+func (t *macTrash) put(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	return renameNoClobber(src, dst)
+}
+
+// existingParent returns path or its closest parent that exists
+func existingParent(path string) string {
+	for !exists(path) {
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return path
+}
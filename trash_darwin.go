@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// newTrash returns the trash in the home directory that Finder shows
+func newTrash() (trash, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return &macTrash{dir: filepath.Join(home, ".Trash")}, nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// newTrash returns the freedesktop.org trash of the current user
+func newTrash() (trash, error) {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		data = filepath.Join(home, ".local", "share")
+	}
+	return &xdgTrash{home: filepath.Join(data, "Trash"), uid: os.Getuid(), now: time.Now}, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+// newTrash isn't supported here, -trash falls back to the reject folders
+func newTrash() (trash, error) {
+	return nil, errTrashUnsupported
+}
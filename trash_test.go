@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTrashInfo(t *testing.T) {
+	deleted := time.Date(2020, 7, 1, 18, 4, 5, 0, time.UTC)
+	want := "[Trash Info]\nPath=/photos/summer%202020/beach%23.jpg\nDeletionDate=2020-07-01T18:04:05\n"
+	if got := trashInfo("/photos/summer 2020/beach#.jpg", deleted); got != want {
+		t.Errorf("trashInfo() = %q, want %q", got, want)
+	}
+}
+
+func TestXDGTrash_HomeTrash(t *testing.T) {
+	dir := t.TempDir()
+	duplicate := filepath.Join(dir, "photos", "beach.jpg")
+	if err := os.MkdirAll(filepath.Dir(duplicate), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(duplicate, []byte("the same photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deleted := time.Date(2020, 7, 1, 18, 4, 5, 0, time.Local)
+	bin := &xdgTrash{home: filepath.Join(dir, "share", "Trash"), uid: 1000, now: func() time.Time { return deleted }}
+
+	files, err := bin.filesDir(duplicate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "share", "Trash", "files"); files != want {
+		t.Fatalf("filesDir() = %s, want the home trash %s", files, want)
+	}
+
+	dst := filepath.Join(files, "beach.jpg")
+	if err := bin.put(duplicate, dst); err != nil {
+		t.Fatal(err)
+	}
+	if exists(duplicate) || !exists(dst) {
+		t.Errorf("put() didn't move %s to %s", duplicate, dst)
+	}
+	info, err := ioutil.ReadFile(filepath.Join(dir, "share", "Trash", "info", "beach.jpg.trashinfo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := trashInfo(duplicate, deleted); string(info) != want {
+		t.Errorf("the info file is %q, want %q", info, want)
+	}
+}
+
+func TestXDGTrash_ReservedNameKeepsFile(t *testing.T) {
+	dir := t.TempDir()
+	duplicate := filepath.Join(dir, "beach.jpg")
+	if err := ioutil.WriteFile(duplicate, []byte("the same photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	home := filepath.Join(dir, "Trash")
+	if err := os.MkdirAll(filepath.Join(home, "info"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(home, "info", "beach.jpg.trashinfo"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	bin := &xdgTrash{home: home, uid: 1000, now: time.Now}
+	if err := bin.put(duplicate, filepath.Join(home, "files", "beach.jpg")); err == nil {
+		t.Error("put() replaced the info file of another trashed file")
+	}
+	if !exists(duplicate) {
+		t.Error("the duplicate was moved without an info file")
+	}
+}
+
+func TestRun_Trash(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the XDG trash is only used on linux")
+	}
+	dir := duplicateTree(t)
+	data := filepath.Join(dir, "share")
+	old, had := os.LookupEnv("XDG_DATA_HOME")
+	os.Setenv("XDG_DATA_HOME", data)
+	defer func() {
+		if had {
+			os.Setenv("XDG_DATA_HOME", old)
+		} else {
+			os.Unsetenv("XDG_DATA_HOME")
+		}
+	}()
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-apply", "-trash", dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("run() = %d: %s", code, stderr.String())
+	}
+	if !exists(filepath.Join(data, "Trash", "files", "photo.jpg")) || !exists(filepath.Join(data, "Trash", "info", "photo.jpg.trashinfo")) {
+		t.Errorf("the duplicate wasn't moved to the trash: %s", stdout.String())
+	}
+	if exists(filepath.Join(dir, defaultRejectFolder)) {
+		t.Errorf("-trash created a %s folder", defaultRejectFolder)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// humanBytes formats a byte count with binary units, like 14.3 GiB
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// thousands formats n with thousands separators, like 45,000
+func thousands(n int) string {
+	if n < 0 {
+		return "-" + thousands(-n)
+	}
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// parseSize parses a byte count with an optional binary unit, like 500k, 1M or 2GiB
+func parseSize(s string) (int64, error) {
+	spec := strings.ToUpper(strings.TrimSpace(s))
+	spec = strings.TrimSuffix(strings.TrimSuffix(spec, "B"), "I")
+	multiplier := int64(1)
+	if n := len(spec); n > 0 {
+		if exp := strings.IndexByte("KMGTPE", spec[n-1]); exp >= 0 {
+			multiplier <<= 10 * uint(exp+1)
+			spec = spec[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(spec), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s', use a number of bytes with an optional unit like 500k or 1M", s)
+	}
+	return n * multiplier, nil
+}
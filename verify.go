@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// How much of each file filesEqual compares at a time
+const verifyChunkSize = 64 << 10
+
+// verifiedDuplicates compares every duplicate byte for byte with the original using equal. It returns the duplicates
+// that are identical and those that only share the hash, which would be a hash collision.
+func verifiedDuplicates(original string, paths []string, equal func(a, b string) (bool, error)) ([]string, []string, error) {
+	var same, collisions []string
+	for _, path := range paths {
+		ok, err := equal(original, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			same = append(same, path)
+		} else {
+			collisions = append(collisions, path)
+		}
+	}
+	return same, collisions, nil
+}
+
+// filesEqual streams both files and reports if they have exactly the same content
+func filesEqual(a, b string) (bool, error) {
+	fileA, err := openFile(a)
+	if err != nil {
+		return false, err
+	}
+	defer fileA.Close()
+	fileB, err := openFile(b)
+	if err != nil {
+		return false, err
+	}
+	defer fileB.Close()
+
+	readerA, readerB := bufio.NewReaderSize(fileA, verifyChunkSize), bufio.NewReaderSize(fileB, verifyChunkSize)
+	bufA, bufB := make([]byte, verifyChunkSize), make([]byte, verifyChunkSize)
+	for {
+		nA, errA := io.ReadFull(readerA, bufA)
+		nB, errB := io.ReadFull(readerB, bufB)
+		if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !endA {
+			return false, errA
+		}
+		if errB != nil && !endB {
+			return false, errB
+		}
+		if endA || endB {
+			return endA == endB, nil
+		}
+	}
+}
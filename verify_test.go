@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifiedDuplicates_ExcludesCollisions(t *testing.T) {
+	// the stub pretends forged.jpg hashed the same as the original but has different bytes
+	equal := func(a, b string) (bool, error) { return b != "forged.jpg", nil }
+
+	same, collisions, err := verifiedDuplicates("original.jpg", []string{"copy.jpg", "forged.jpg", "other.jpg"}, equal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(same) != "[copy.jpg other.jpg]" {
+		t.Errorf("verifiedDuplicates() kept %v to be moved, want [copy.jpg other.jpg]", same)
+	}
+	if fmt.Sprint(collisions) != "[forged.jpg]" {
+		t.Errorf("verifiedDuplicates() reported %v as collisions, want [forged.jpg]", collisions)
+	}
+}
+
+func TestFilesEqual(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("deduper"), 20000)
+	files := map[string][]byte{
+		"a.jpg":       data,
+		"b.jpg":       data,
+		"longer.jpg":  append(append([]byte(nil), data...), 'x'),
+		"flipped.jpg": append([]byte{'D'}, data[1:]...),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for other, want := range map[string]bool{"b.jpg": true, "longer.jpg": false, "flipped.jpg": false} {
+		got, err := filesEqual(filepath.Join(dir, "a.jpg"), filepath.Join(dir, other))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("filesEqual(a.jpg, %s) = %t, want %t", other, got, want)
+		}
+	}
+}
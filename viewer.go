@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// The images and videos that the o action of -interactive opens, other files have no useful default application
+var viewerExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".tif":  true,
+	".tiff": true,
+	".heic": true,
+	".webp": true,
+	".nef":  true,
+	".raf":  true,
+	".dng":  true,
+	".mov":  true,
+	".mp4":  true,
+	".mkv":  true,
+}
+
+// viewable returns the paths that are images or videos
+func viewable(paths []string) []string {
+	var result []string
+	for _, path := range paths {
+		if viewerExtensions[strings.ToLower(filepath.Ext(path))] {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+// openInViewer starts the default application of the OS for path without waiting for it to be closed, a variable so
+// that tests don't start one
+var openInViewer = func(path string) error {
+	name, args := viewerCommand(runtime.GOOS, path)
+	cmd := exec.Command(name, args...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// viewerCommand returns the command that opens path in its default application on goos
+func viewerCommand(goos, path string) (string, []string) {
+	switch goos {
+	case "darwin":
+		return "open", []string{path}
+	case "windows":
+		// the empty argument is the window title, start would take a quoted path for it
+		return "cmd", []string{"/c", "start", "", path}
+	default:
+		return "xdg-open", []string{path}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// parallelWalk works like filepath.Walk but reads up to workers directories at the same time, which hides the latency
+// of network mounts where every directory read and stat is a round trip. The directory reads are what runs in
+// parallel, fn is still called for one path at a time so that it can update its state without locking. The order fn
+// sees the paths in is only sorted within each directory.
+//
+// SkipDir works like it does for filepath.Walk: returned for a directory it skips the directory, returned for a file it
+// skips the rest of the files in the same directory. Any other error stops the walk and is returned.
+func parallelWalk(root string, workers int, fn filepath.WalkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = fn(root, info, nil)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	if err != nil || info == nil || !info.IsDir() {
+		return err
+	}
+
+	w := &walker{fn: fn}
+	w.cond = sync.NewCond(&w.mu)
+	w.push(walkDir{path: root, info: info})
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				dir, ok := w.pop()
+				if !ok {
+					return
+				}
+				w.read(dir)
+				w.done()
+			}
+		}()
+	}
+	wg.Wait()
+	return w.err
+}
+
+// walkDir is a directory waiting to be read, info is what fn was called with for it
+type walkDir struct {
+	path string
+	info os.FileInfo
+}
+
+// walker is the state shared by the goroutines of parallelWalk. mu guards the queue and the error, callMu makes sure
+// only one call to fn runs at a time.
+type walker struct {
+	fn     filepath.WalkFunc
+	callMu sync.Mutex
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []walkDir
+	pending int // directories queued or being read
+	err     error
+}
+
+func (w *walker) push(dir walkDir) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.queue = append(w.queue, dir)
+	w.pending++
+	w.cond.Signal()
+}
+
+// pop waits for a directory to read, it returns false once every directory was read or the walk failed
+func (w *walker) pop() (walkDir, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.queue) == 0 && w.pending > 0 && w.err == nil {
+		w.cond.Wait()
+	}
+	if len(w.queue) == 0 || w.err != nil {
+		return walkDir{}, false
+	}
+	dir := w.queue[len(w.queue)-1]
+	w.queue = w.queue[:len(w.queue)-1]
+	return dir, true
+}
+
+// done marks a popped directory as read and wakes the waiting goroutines once there is nothing left to wait for
+func (w *walker) done() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending--
+	if w.pending == 0 {
+		w.cond.Broadcast()
+	}
+}
+
+func (w *walker) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.cond.Broadcast()
+}
+
+func (w *walker) failed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err != nil
+}
+
+func (w *walker) call(path string, info os.FileInfo, err error) error {
+	w.callMu.Lock()
+	defer w.callMu.Unlock()
+	return w.fn(path, info, err)
+}
+
+// read lists dir, calls fn for every entry and queues the subdirectories that fn didn't skip
+func (w *walker) read(dir walkDir) {
+	infos, err := readDirInfos(dir.path)
+	if err != nil {
+		if err := w.call(dir.path, dir.info, err); err != nil && err != filepath.SkipDir {
+			w.fail(err)
+		}
+		return
+	}
+	for _, info := range infos {
+		if w.failed() {
+			return
+		}
+		path := filepath.Join(dir.path, info.Name())
+		err := w.call(path, info, nil)
+		if err == filepath.SkipDir {
+			if info.IsDir() {
+				continue
+			}
+			return
+		}
+		if err != nil {
+			w.fail(err)
+			return
+		}
+		if info.IsDir() {
+			w.push(walkDir{path: path, info: info})
+		}
+	}
+}
+
+// readDirInfos returns the lstat info of every entry in dir sorted by name, like filepath.Walk reads them
+func readDirInfos(dir string) ([]os.FileInfo, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
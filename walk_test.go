@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// wideTree creates dirs directories with files files each, plus a second level below every tenth directory
+func wideTree(tb testing.TB, dirs, files int) string {
+	tb.Helper()
+	root := tb.TempDir()
+	for d := 0; d < dirs; d++ {
+		dir := filepath.Join(root, fmt.Sprintf("dir_%03d", d))
+		if d%10 == 0 {
+			dir = filepath.Join(dir, "nested")
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		for f := 0; f < files; f++ {
+			if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("photo_%d.jpg", f)), []byte{byte(f)}, 0644); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+// visitedPaths walks root with walk and returns every path the callback saw, sorted. Directories named skip are
+// skipped and files named stop.jpg skip the rest of their directory, the callback locks since filepath.Walk callers
+// don't have to.
+func visitedPaths(t *testing.T, root string, walk func(string, filepath.WalkFunc) error) []string {
+	t.Helper()
+	var mu sync.Mutex
+	var paths []string
+	err := walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, path)
+		if info.IsDir() && info.Name() == "skip" {
+			return filepath.SkipDir
+		}
+		if info.Name() == "photo_1.jpg" {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestParallelWalk_SameAsWalk(t *testing.T) {
+	root := wideTree(t, 50, 4)
+	skipped := filepath.Join(root, "dir_001", "skip")
+	if err := os.Mkdir(skipped, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(skipped, "hidden.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := visitedPaths(t, root, filepath.Walk)
+	for _, workers := range []int{1, 4, 16} {
+		got := visitedPaths(t, root, func(root string, fn filepath.WalkFunc) error { return parallelWalk(root, workers, fn) })
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("parallelWalk() with %d workers visited %d paths, filepath.Walk visited %d", workers, len(got), len(want))
+		}
+	}
+}
+
+func TestParallelWalk_StopsOnError(t *testing.T) {
+	root := wideTree(t, 20, 2)
+	stop := errors.New("stop")
+	var calls int
+	err := parallelWalk(root, 4, func(path string, info os.FileInfo, err error) error {
+		calls++
+		if calls == 5 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("parallelWalk() = %v, want the error returned by the callback", err)
+	}
+}
+
+func TestParallelWalk_MissingRoot(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	var seen error
+	err := parallelWalk(missing, 4, func(path string, info os.FileInfo, err error) error {
+		seen = err
+		return nil
+	})
+	if err != nil || !os.IsNotExist(seen) {
+		t.Errorf("parallelWalk() on a missing root = %v with %v passed to the callback, want nil and a not exist error", err, seen)
+	}
+}
+
+func benchmarkWalk(b *testing.B, walk func(string, filepath.WalkFunc) error) {
+	root := wideTree(b, 200, 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := walk(root, func(string, os.FileInfo, error) error { return nil }); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWalk_Serial(b *testing.B) { benchmarkWalk(b, filepath.Walk) }
+
+func BenchmarkWalk_Parallel(b *testing.B) {
+	benchmarkWalk(b, func(root string, fn filepath.WalkFunc) error { return parallelWalk(root, 8, fn) })
+}
+
+// BenchmarkWalk_SizeIndex16 walks with 16 workers with and without filling a size index like the scan does, which
+// shows what the index updates cost while parallelWalk runs one callback at a time
+func BenchmarkWalk_SizeIndex16(b *testing.B) {
+	root := wideTree(b, 200, 20)
+	b.Run("empty", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := parallelWalk(root, 16, func(string, os.FileInfo, error) error { return nil }); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			index := newSizeIndex()
+			err := parallelWalk(root, 16, func(path string, info os.FileInfo, err error) error {
+				if err == nil && info.Mode().IsRegular() {
+					_, err = index.add(info.Size(), path)
+				}
+				return err
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	webhookAttempts = 3
+	webhookTimeout  = 10 * time.Second
+)
+
+// How long to wait before the second attempt, doubled for each one after it. A variable so that tests don't wait.
+var webhookBackoff = time.Second
+
+// webhookPayload is the JSON document that is posted to the -webhook url when a run is done
+type webhookPayload struct {
+	FilesScanned     int     `json:"files_scanned"`
+	DuplicateGroups  int     `json:"duplicate_groups"`
+	DuplicateFiles   int     `json:"duplicate_files"`
+	ReclaimableBytes int64   `json:"reclaimable_bytes"`
+	Groups           []Group `json:"groups"`
+}
+
+// postWebhook posts the payload as JSON to url, retrying network errors and 5xx or 429 responses with a backoff
+func postWebhook(url string, payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err = postOnce(client, url, body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		if _, ok := err.(permanentError); ok {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// permanentError is a webhook failure that retrying won't fix
+type permanentError struct{ status string }
+
+func (e permanentError) Error() string { return "webhook rejected the request with " + e.status }
+
+func postOnce(client *http.Client, url string, body []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webhook failed with %s", resp.Status)
+	default:
+		return permanentError{status: resp.Status}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCLI_WebhookRetries(t *testing.T) {
+	defer func(backoff time.Duration) { webhookBackoff = backoff }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	tests := []struct {
+		name     string
+		failures int32 // how many requests fail before one succeeds
+		status   int
+		required bool
+		attempts int32
+		code     int
+		output   string // the error that is shown, nothing about the webhook is shown if empty
+	}{
+		{"succeeds after retrying", webhookAttempts - 1, http.StatusServiceUnavailable, true, webhookAttempts, exitDuplicatesFound, ""},
+		{"retries run out", webhookAttempts, http.StatusBadGateway, false, webhookAttempts, exitDuplicatesFound, "Could not deliver the webhook: webhook failed with 502 Bad Gateway"},
+		{"retries run out with -webhook-required", webhookAttempts, http.StatusTooManyRequests, true, webhookAttempts, 1, "Error: 'webhook failed with 429 Too Many Requests'"},
+		{"rejected requests aren't retried", webhookAttempts, http.StatusBadRequest, true, 1, 1, "webhook rejected the request with 400 Bad Request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&attempts, 1) <= tt.failures {
+					w.WriteHeader(tt.status)
+				}
+			}))
+			defer server.Close()
+
+			args := []string{"-webhook", server.URL, duplicateTree(t)}
+			if tt.required {
+				args = append([]string{"-webhook-required"}, args...)
+			}
+			var stdout, stderr bytes.Buffer
+			if code := run(args, &stdout, &stderr); code != tt.code {
+				t.Errorf("run() = %d, want %d: %s", code, tt.code, stderr.String())
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.attempts {
+				t.Errorf("the webhook was posted %d times, want %d", got, tt.attempts)
+			}
+			if tt.output == "" && strings.Contains(stderr.String(), "webhook") || !strings.Contains(stderr.String(), tt.output) {
+				t.Errorf("run() printed %q, want %q", stderr.String(), tt.output)
+			}
+		})
+	}
+}